@@ -7,6 +7,7 @@ import (
 	"os"
 	"strconv"
 	"unicode"
+	"unicode/utf8"
 
 	"context"
 
@@ -25,6 +26,10 @@ var nameToActions map[string]Action
 // This is the default keybinding used by NewKeymap()
 var defaultKeyBinding map[string]Action
 
+// This is the default keybinding for each mode, which is looked up
+// before defaultKeyBinding while the mode is active
+var defaultModeKeyBinding map[string]map[string]Action
+
 // Execute fulfills the Action interface for AfterFunc
 func (a ActionFunc) Execute(ctx context.Context, state *Peco, e termbox.Event) {
 	a(ctx, state, e)
@@ -127,9 +132,77 @@ func init() {
 	ActionFunc(doToggleQuery).Register("ToggleQuery", termbox.KeyCtrlT)
 	ActionFunc(doRefreshScreen).Register("RefreshScreen", termbox.KeyCtrlL)
 	ActionFunc(doToggleSingleKeyJump).Register("ToggleSingleKeyJump")
+	ActionFunc(doTogglePreview).Register("TogglePreview")
+	ActionFunc(doHistoryPrevious).Register("HistoryPrevious")
+	ActionFunc(doHistoryNext).Register("HistoryNext")
+	ActionFunc(doHistorySearch).Register("HistorySearch")
+	ActionFunc(doScrollPreviewUp).Register("ScrollPreviewUp")
+	ActionFunc(doScrollPreviewDown).Register("ScrollPreviewDown")
+	ActionFunc(doScrollPreviewPageUp).Register("ScrollPreviewPageUp")
+	ActionFunc(doScrollPreviewPageDown).Register("ScrollPreviewPageDown")
+	ActionFunc(doReloadSource).Register("ReloadSource")
 
 	ActionFunc(doToggleViewArround).Register("ViewArround", termbox.KeyCtrlV)
 
+	ActionFunc(doVimNormalMode).Register("VimNormalMode")
+	ActionFunc(doVimInsert).Register("VimInsert")
+	ActionFunc(doVimAppend).Register("VimAppend")
+	ActionFunc(doVimInsertBeginningOfLine).Register("VimInsertBeginningOfLine")
+	ActionFunc(doVimAppendEndOfLine).Register("VimAppendEndOfLine")
+	makeVimMotion(vimBackwardChar).Register("VimBackwardChar")
+	makeVimMotion(vimForwardChar).Register("VimForwardChar")
+	ActionFunc(doVimForwardWord).Register("VimForwardWord")
+	makeVimMotion(vimBackwardWord).Register("VimBackwardWord")
+	makeVimMotion(vimEndOfWord).Register("VimEndOfWord")
+	makeVimMotion(vimBeginningOfLine).Register("VimBeginningOfLine")
+	makeVimMotion(vimEndOfLine).Register("VimEndOfLine")
+	ActionFunc(doVimFindChar).Register("VimFindChar")
+	makeVimOperator('d').Register("VimDelete")
+	makeVimOperator('c').Register("VimChange")
+	makeVimOperator('y').Register("VimYank")
+	ActionFunc(doVimDeleteChar).Register("VimDeleteChar")
+	ActionFunc(doVimPaste).Register("VimPaste")
+	ActionFunc(doVimSelectDown).Register("VimSelectDown")
+	ActionFunc(doVimSelectUp).Register("VimSelectUp")
+	ActionFunc(doVimCount).Register("VimCount")
+	ActionFunc(doVimCancel).Register("VimCancel")
+
+	defaultModeKeyBinding = map[string]map[string]Action{
+		keymapRangeMode: {
+			"C-c": nameToActions["peco.CancelRangeMode"],
+			"Esc": nameToActions["peco.CancelRangeMode"],
+		},
+		keymapSingleKeyJumpMode: {},
+		keymapPreviewMode:       {},
+		vimInsertMode: {
+			"Esc": nameToActions["peco.VimNormalMode"],
+		},
+		vimNormalMode: {
+			"Esc": nameToActions["peco.VimCancel"],
+			"i":   nameToActions["peco.VimInsert"],
+			"a":   nameToActions["peco.VimAppend"],
+			"I":   nameToActions["peco.VimInsertBeginningOfLine"],
+			"A":   nameToActions["peco.VimAppendEndOfLine"],
+			"h":   nameToActions["peco.VimBackwardChar"],
+			"l":   nameToActions["peco.VimForwardChar"],
+			"w":   nameToActions["peco.VimForwardWord"],
+			"b":   nameToActions["peco.VimBackwardWord"],
+			"e":   nameToActions["peco.VimEndOfWord"],
+			"$":   nameToActions["peco.VimEndOfLine"],
+			"f":   nameToActions["peco.VimFindChar"],
+			"d":   nameToActions["peco.VimDelete"],
+			"c":   nameToActions["peco.VimChange"],
+			"y":   nameToActions["peco.VimYank"],
+			"x":   nameToActions["peco.VimDeleteChar"],
+			"p":   nameToActions["peco.VimPaste"],
+			"j":   nameToActions["peco.VimSelectDown"],
+			"k":   nameToActions["peco.VimSelectUp"],
+		},
+	}
+	for _, ch := range "0123456789" {
+		defaultModeKeyBinding[vimNormalMode][string(ch)] = nameToActions["peco.VimCount"]
+	}
+
 	ActionFunc(doGoToNextSelection).Register("GoToNextSelection", termbox.KeyCtrlK)
 	ActionFunc(doGoToPreviousSelection).Register("	doGoToPreviousSelection", termbox.KeyCtrlJ)
 
@@ -171,6 +244,13 @@ func doAcceptChar(ctx context.Context, state *Peco, e termbox.Event) {
 		return
 	}
 
+	if v := state.vim; v != nil && v.Mode() == vimNormalMode {
+		// Characters are commands in normal mode, and unbound ones
+		// just cancel whatever is pending
+		v.reset()
+		return
+	}
+
 	q := state.Query()
 	c := state.Caret()
 
@@ -303,18 +383,53 @@ func (err errCollectResults) Error() string {
 func (err errCollectResults) CollectResults() bool {
 	return true
 }
-func doFinish(ctx context.Context, state *Peco, _ termbox.Event) {
+func doFinish(ctx context.Context, state *Peco, e termbox.Event) {
 	if pdebug.Enabled {
 		g := pdebug.Marker("doFinish")
 		defer g.End()
 	}
 
+	if h := state.history; h != nil {
+		if err := h.Add(state.Query().String()); err != nil {
+			state.Hub().SendStatusMsg(ctx, "Failed to save history: "+err.Error())
+		}
+	}
+
 	ccarg := state.execOnFinish
 	if len(ccarg) == 0 {
+		state.finishAction = "peco.Finish"
+		if e.Key != 0 || e.Ch != 0 {
+			state.finishKey = eventToKey(e).String()
+		}
 		state.Exit(errCollectResults{})
 		return
 	}
 
+	sel := selectionOrCurrentLine(state)
+	stdin := selectionInput(sel)
+
+	var err error
+	state.Hub().SendStatusMsg(ctx, "Executing " + ccarg)
+	cmd := util.Shell(ccarg)
+	cmd.Stdin = stdin
+	cmd.Stdout = state.Stdout
+	cmd.Stderr = state.Stderr
+	cmd.Env = commandEnv(state, sel)
+
+	state.screen.Suspend()
+
+	err = cmd.Run()
+	state.screen.Resume()
+	state.Hub().SendDraw(ctx, &DrawOptions{DisableCache: true})
+	if err != nil {
+		// bail out, or otherwise the user cannot know what happened
+		state.Exit(errors.Wrap(err, `failed to execute command`))
+	}
+}
+
+// selectionOrCurrentLine returns the selected lines, or the current
+// line if there are none
+func selectionOrCurrentLine(state *Peco) *Selection {
 	sel := NewSelection()
 	state.Selection().Copy(sel)
 	if sel.Len() == 0 {
@@ -322,21 +437,25 @@ func doFinish(ctx context.Context, state *Peco, _ termbox.Event) {
 			sel.Add(l)
 		}
 	}
+	return sel
+}
 
-	var stdin bytes.Buffer
+// selectionInput returns the lines in sel, for use as the standard
+// input of a command
+func selectionInput(sel *Selection) *bytes.Buffer {
+	var buf bytes.Buffer
 	sel.Ascend(func(it btree.Item) bool {
 		line := it.(line.Line)
-		stdin.WriteString(line.Buffer())
-		stdin.WriteRune('\n')
+		buf.WriteString(line.Buffer())
+		buf.WriteRune('\n')
 		return true
 	})
+	return &buf
+}
 
-	var err error
-	state.Hub().SendStatusMsg(ctx, "Executing " + ccarg)
-	cmd := util.Shell(ccarg)
-	cmd.Stdin = &stdin
-	cmd.Stdout = state.Stdout
-	cmd.Stderr = state.Stderr
+// commandEnv returns the environment for commands run by peco, which
+// is the current environment plus some PECO specific variables
+func commandEnv(state *Peco, sel *Selection) []string {
 	// Setup some environment variables. Start with a copy of the current
 	// environment...
 	env := os.Environ()
@@ -355,21 +474,10 @@ func doFinish(ctx context.Context, state *Peco, _ termbox.Event) {
 		)
 	}
 
-	env = append(env,
+	return append(env,
 		`PECO_QUERY=`+state.Query().String(),
 		`PECO_MATCHED_LINE_COUNT=`+strconv.Itoa(sel.Len()),
 	)
-	cmd.Env = env
-
-	state.screen.Suspend()
-
-	err = cmd.Run()
-	state.screen.Resume()
-	state.Hub().SendDraw(ctx, &DrawOptions{DisableCache: true})
-	if err != nil {
-		// bail out, or otherwise the user cannot know what happened
-		state.Exit(errors.Wrap(err, `failed to execute command`))
-	}
 }
 
 func doCancel(ctx context.Context, state *Peco, e termbox.Event) {
@@ -380,11 +488,6 @@ func doCancel(ctx context.Context, state *Peco, e termbox.Event) {
 		return
 	}
 
-	if state.SelectionRangeStart().Valid() {
-		doCancelRangeMode(ctx, state, e)
-		return
-	}
-
 	// peco.Cancel -> end program, exit with failure
 	err := makeIgnorable(errors.New("user canceled"))
 	if state.onCancel == errorKey {
@@ -765,6 +868,53 @@ func doToggleQuery(ctx context.Context, state *Peco, _ termbox.Event) {
 	state.Hub().SendDrawPrompt(ctx)
 }
 
+func doHistoryPrevious(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doHistoryPrevious")
+		defer g.End()
+	}
+	loadHistory(ctx, state, (*History).Previous, "No older queries in history")
+}
+
+func doHistoryNext(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doHistoryNext")
+		defer g.End()
+	}
+	loadHistory(ctx, state, (*History).Next, "No newer queries in history")
+}
+
+func doHistorySearch(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doHistorySearch")
+		defer g.End()
+	}
+	loadHistory(ctx, state, (*History).Search, "No matching queries in history")
+}
+
+// loadHistory replaces the query with the entry from the history that
+// is returned by next, and moves the caret to the end of the query
+func loadHistory(ctx context.Context, state *Peco, next func(*History, string) (string, bool), notFound string) {
+	if state.history == nil {
+		state.Hub().SendStatusMsg(ctx, "History is disabled")
+		return
+	}
+
+	q := state.Query()
+	s, ok := next(state.history, q.String())
+	if !ok {
+		state.Hub().SendStatusMsg(ctx, notFound)
+		return
+	}
+	q.Set(s)
+	state.Caret().SetPos(utf8.RuneCountInString(s))
+
+	if state.ExecQuery(nil) {
+		return
+	}
+	state.Hub().SendDrawPrompt(ctx)
+}
+
 func doKonamiCommand(ctx context.Context, state *Peco, e termbox.Event) {
 	state.Hub().SendStatusMsg(ctx, "All your filters are belongs to us")
 }
@@ -777,6 +927,35 @@ func doToggleSingleKeyJump(ctx context.Context, state *Peco, e termbox.Event) {
 	state.ToggleSingleKeyJumpMode()
 }
 
+func doTogglePreview(ctx context.Context, state *Peco, e termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doTogglePreview")
+		defer g.End()
+	}
+	if state.preview == nil {
+		state.Hub().SendStatusMsg(ctx, "No preview command specified")
+		return
+	}
+	state.preview.Toggle()
+	state.Hub().SendDraw(ctx, &DrawOptions{DisableCache: true})
+}
+
+func doScrollPreviewUp(ctx context.Context, state *Peco, e termbox.Event) {
+	state.Hub().SendPaging(ctx, ToScrollPreviewUp)
+}
+
+func doScrollPreviewDown(ctx context.Context, state *Peco, e termbox.Event) {
+	state.Hub().SendPaging(ctx, ToScrollPreviewDown)
+}
+
+func doScrollPreviewPageUp(ctx context.Context, state *Peco, e termbox.Event) {
+	state.Hub().SendPaging(ctx, ToScrollPreviewPageUp)
+}
+
+func doScrollPreviewPageDown(ctx context.Context, state *Peco, e termbox.Event) {
+	state.Hub().SendPaging(ctx, ToScrollPreviewPageDown)
+}
+
 func doToggleViewArround(ctx context.Context, state *Peco, e termbox.Event) {
 	if pdebug.Enabled {
 		g := pdebug.Marker("doToggleViewArround")
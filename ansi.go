@@ -0,0 +1,189 @@
+package peco
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+const ansiAttrMask = termbox.AttrBold | termbox.AttrUnderline | termbox.AttrReverse
+
+// newANSIState creates the state for parsing ANSI escape sequences.
+// Text that is not colored by escape sequences uses fg and bg. If
+// use256Color is false, colors are limited to the 8 basic colors
+func newANSIState(fg, bg termbox.Attribute, use256Color bool) *ansiState {
+	return &ansiState{
+		defaultFg:   fg,
+		defaultBg:   bg,
+		fg:          fg,
+		bg:          bg,
+		use256Color: use256Color,
+	}
+}
+
+// Parse splits s into segments of text with the same attributes, as
+// specified by SGR escape sequences. Other escape sequences are
+// removed. The state carries over to the next call, as attributes
+// may span multiple lines
+func (st *ansiState) Parse(s string) []ansiSegment {
+	var segments []ansiSegment
+	var buf strings.Builder
+	flush := func() {
+		if buf.Len() == 0 {
+			return
+		}
+		segments = append(segments, ansiSegment{text: buf.String(), fg: st.fg, bg: st.bg})
+		buf.Reset()
+	}
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\x1b' && i+1 < len(s) && s[i+1] == '[':
+			// CSI: parameters and intermediate bytes, then a final byte
+			j := i + 2
+			for j < len(s) && (s[j] < 0x40 || s[j] > 0x7e) {
+				j++
+			}
+			if j >= len(s) {
+				i = len(s)
+				continue
+			}
+			if s[j] == 'm' {
+				flush()
+				st.apply(s[i+2 : j])
+			}
+			i = j + 1
+		case c == '\x1b' && i+1 < len(s) && s[i+1] == ']':
+			// OSC: terminated by BEL or ST
+			j := i + 2
+			for j < len(s) && s[j] != '\a' && !(s[j] == '\x1b' && j+1 < len(s) && s[j+1] == '\\') {
+				j++
+			}
+			switch {
+			case j >= len(s):
+				i = len(s)
+			case s[j] == '\a':
+				i = j + 1
+			default:
+				i = j + 2
+			}
+		case c == '\x1b':
+			// Some other two character sequence
+			i += 2
+		case c == '\r':
+			i++
+		default:
+			buf.WriteByte(c)
+			i++
+		}
+	}
+	flush()
+	return segments
+}
+
+// apply updates the state using the parameters of a SGR sequence
+func (st *ansiState) apply(params string) {
+	codes := strings.FieldsFunc(params, func(r rune) bool { return r == ';' || r == ':' })
+	if len(codes) == 0 {
+		codes = []string{"0"}
+	}
+
+	for i := 0; i < len(codes); i++ {
+		n, err := strconv.Atoi(codes[i])
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case n == 0:
+			st.fg, st.bg = st.defaultFg, st.defaultBg
+		case n == 1:
+			st.fg |= termbox.AttrBold
+		case n == 4:
+			st.fg |= termbox.AttrUnderline
+		case n == 7:
+			st.fg |= termbox.AttrReverse
+		case n == 22:
+			st.fg &^= termbox.AttrBold
+		case n == 24:
+			st.fg &^= termbox.AttrUnderline
+		case n == 27:
+			st.fg &^= termbox.AttrReverse
+		case 30 <= n && n <= 37:
+			st.fg = st.fg&ansiAttrMask | st.color(n-30)
+		case n == 39:
+			st.fg = st.fg&ansiAttrMask | st.defaultFg&^ansiAttrMask
+		case 40 <= n && n <= 47:
+			st.bg = st.color(n - 40)
+		case n == 49:
+			st.bg = st.defaultBg
+		case 90 <= n && n <= 97:
+			st.fg = st.fg&ansiAttrMask | st.color(n-90+8)
+		case 100 <= n && n <= 107:
+			st.bg = st.color(n - 100 + 8)
+		case n == 38 || n == 48:
+			c, used := st.extendedColor(codes[i+1:])
+			i += used
+			if c == 0 {
+				continue
+			}
+			if n == 38 {
+				st.fg = st.fg&ansiAttrMask | c
+			} else {
+				st.bg = c
+			}
+		}
+	}
+}
+
+// extendedColor parses the arguments to an extended color sequence
+// (38 or 48). Returns the color, and the number of arguments used.
+// If the color can't be displayed, zero is returned
+func (st *ansiState) extendedColor(args []string) (termbox.Attribute, int) {
+	if len(args) == 0 {
+		return 0, 0
+	}
+
+	atoi := func(s string) int {
+		n, _ := strconv.Atoi(s)
+		if n < 0 || n > 255 {
+			return 0
+		}
+		return n
+	}
+
+	switch args[0] {
+	case "5":
+		if len(args) < 2 {
+			return 0, len(args)
+		}
+		return st.color(atoi(args[1])), 2
+	case "2":
+		if len(args) < 4 {
+			return 0, len(args)
+		}
+		// Use the closest color in the 6x6x6 color cube
+		r, g, b := atoi(args[1]), atoi(args[2]), atoi(args[3])
+		return st.color(16 + 36*((r+25)/51) + 6*((g+25)/51) + (b+25)/51), 4
+	}
+	return 0, len(args)
+}
+
+// color converts an index into the 256 color palette into a termbox
+// color. Colors that can't be displayed are returned as zero, which
+// is the default color
+func (st *ansiState) color(n int) termbox.Attribute {
+	if st.use256Color {
+		return termbox.Attribute(n + 1)
+	}
+
+	switch {
+	case n < 8:
+		return termbox.ColorBlack + termbox.Attribute(n)
+	case n < 16:
+		return termbox.ColorBlack + termbox.Attribute(n-8)
+	}
+	return 0
+}
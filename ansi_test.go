@@ -0,0 +1,42 @@
+package peco
+
+import (
+	"testing"
+
+	"github.com/nsf/termbox-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestANSIState(t *testing.T) {
+	testValues := []struct {
+		input       string
+		use256Color bool
+		expected    []ansiSegment
+	}{
+		{"plain", false, []ansiSegment{{"plain", termbox.ColorDefault, termbox.ColorDefault}}},
+		{"\x1b[31mred\x1b[0m plain", false, []ansiSegment{
+			{"red", termbox.ColorRed, termbox.ColorDefault},
+			{" plain", termbox.ColorDefault, termbox.ColorDefault},
+		}},
+		{"\x1b[1;32;44mbold\x1b[22mnormal\x1b[m", false, []ansiSegment{
+			{"bold", termbox.ColorGreen | termbox.AttrBold, termbox.ColorBlue},
+			{"normal", termbox.ColorGreen, termbox.ColorBlue},
+		}},
+		{"\x1b[38;5;208mx", true, []ansiSegment{{"x", termbox.Attribute(209), termbox.ColorDefault}}},
+		{"\x1b[38;5;208mx", false, []ansiSegment{{"x", termbox.ColorDefault, termbox.ColorDefault}}},
+		{"\x1b[91mx", false, []ansiSegment{{"x", termbox.ColorRed, termbox.ColorDefault}}},
+		{"\x1b[38;2;255;0;0mx", true, []ansiSegment{{"x", termbox.Attribute(197), termbox.ColorDefault}}},
+		{"\x1b]0;title\ax\x1b[2Ky\r", false, []ansiSegment{{"xy", termbox.ColorDefault, termbox.ColorDefault}}},
+	}
+
+	for _, v := range testValues {
+		st := newANSIState(termbox.ColorDefault, termbox.ColorDefault, v.use256Color)
+		assert.Equal(t, v.expected, st.Parse(v.input), "Parse(%q) should match", v.input)
+	}
+
+	t.Run("State carries over", func(t *testing.T) {
+		st := newANSIState(termbox.ColorDefault, termbox.ColorDefault, false)
+		st.Parse("\x1b[33mfoo")
+		assert.Equal(t, []ansiSegment{{"bar", termbox.ColorYellow, termbox.ColorDefault}}, st.Parse("bar"), "attributes should carry over to the next line")
+	})
+}
@@ -1,6 +1,7 @@
 package peco
 
 import (
+	"sort"
 	"time"
 
 	"context"
@@ -85,6 +86,12 @@ func bufferSize(lines []line.Line) int {
 	return len(lines)
 }
 
+func (mb *MemoryBuffer) Trimmed() int {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+	return mb.trimmed
+}
+
 func (mb *MemoryBuffer) Reset() {
 	mb.mutex.Lock()
 	defer mb.mutex.Unlock()
@@ -94,6 +101,7 @@ func (mb *MemoryBuffer) Reset() {
 	}
 	mb.done = make(chan struct{})
 	mb.lines = []line.Line(nil)
+	mb.trimmed = 0
 }
 
 func (mb *MemoryBuffer) Done() <-chan struct{} {
@@ -128,17 +136,78 @@ func (mb *MemoryBuffer) Accept(ctx context.Context, in chan interface{}, _ pipel
 					if pdebug.Enabled {
 						pdebug.Printf("MemoryBuffer received end mark (read %d lines, %s since starting accept loop)", len(mb.lines), time.Since(start).String())
 					}
+					if mb.rankByScore {
+						mb.sortByScore()
+					}
 					return
 				}
 			case line.Line:
 				mb.mutex.Lock()
-				mb.lines = append(mb.lines, v.(line.Line))
+				mb.received++
+				if mb.rankIncrementally {
+					mb.lines = insertByScore(mb.lines, v.(line.Line), mb.capacity)
+				} else {
+					var removed int
+					mb.lines, removed = appendWithCapacity(mb.lines, v.(line.Line), mb.capacity)
+					mb.trimmed += removed
+				}
 				mb.mutex.Unlock()
 			}
 		}
 	}
 }
 
+// sortByScore orders the lines by descending score. Lines with the
+// same score retain the order in which they were received
+func (mb *MemoryBuffer) sortByScore() {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	sort.SliceStable(mb.lines, func(i, j int) bool {
+		return lineScore(mb.lines[i]) > lineScore(mb.lines[j])
+	})
+}
+
+// insertByScore inserts l after the lines with the same or a higher
+// score, so that lines stay sorted like sortByScore does. If capacity is
+// greater than 0, the oldest line is removed so that at most capacity
+// lines are kept
+func insertByScore(lines []line.Line, l line.Line, capacity int) []line.Line {
+	score := lineScore(l)
+	i := sort.Search(len(lines), func(i int) bool {
+		return lineScore(lines[i]) < score
+	})
+	lines = append(lines, nil)
+	copy(lines[i+1:], lines[i:])
+	lines[i] = l
+
+	if capacity > 0 && len(lines) > capacity {
+		oldest := 0
+		for j, x := range lines {
+			if x.ID() < lines[oldest].ID() {
+				oldest = j
+			}
+		}
+		lines = append(lines[:oldest], lines[oldest+1:]...)
+	}
+	return lines
+}
+
+// receivedCount returns the number of lines received so far, including
+// those that have been removed since
+func (mb *MemoryBuffer) receivedCount() int {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+	return mb.received
+}
+
+func lineScore(l line.Line) int {
+	if s, ok := l.(line.Scorer); ok {
+		return s.Score()
+	}
+	return 0
+}
+
 func (mb *MemoryBuffer) LineAt(n int) (line.Line, error) {
 	mb.mutex.RLock()
 	defer mb.mutex.RUnlock()
@@ -151,6 +220,20 @@ func (mb *MemoryBuffer) linesInRange(start, end int) []line.Line {
 	return mb.lines[start:end]
 }
 
+// appendWithCapacity appends l to lines. If capacity is greater than 0,
+// the oldest lines are removed so that at most capacity lines are kept.
+// The number of lines that were removed is also returned
+func appendWithCapacity(lines []line.Line, l line.Line, capacity int) ([]line.Line, int) {
+	lines = append(lines, l)
+	if capacity > 0 && len(lines) > capacity {
+		// The removed lines are released once append has to grow the
+		// slice, as only the remaining lines are copied
+		removed := len(lines) - capacity
+		return lines[removed:], removed
+	}
+	return lines, 0
+}
+
 func bufferLineAt(lines []line.Line, n int) (line.Line, error) {
 	if s := len(lines); s <= 0 || n >= s {
 		return nil, errors.New("empty buffer")
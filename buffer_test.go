@@ -0,0 +1,75 @@
+package peco
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/peco/peco/line"
+	"github.com/peco/peco/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryBufferRankByScore(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mb := NewMemoryBuffer()
+	mb.rankByScore = true
+
+	in := make(chan interface{})
+	go mb.Accept(ctx, in, nil)
+
+	scores := []int{10, 30, 20, 30}
+	for i, s := range scores {
+		in <- line.NewScoredMatched(line.NewRaw(uint64(i), "", false), nil, s)
+	}
+	in <- pipeline.EndMark{}
+
+	select {
+	case <-ctx.Done():
+		t.Errorf("timed out waiting for MemoryBuffer.Accept")
+		return
+	case <-mb.Done():
+	}
+
+	expected := []uint64{1, 3, 2, 0}
+	for i, id := range expected {
+		l, err := mb.LineAt(i)
+		if !assert.NoError(t, err, "mb.LineAt(%d) should succeed", i) {
+			return
+		}
+		if !assert.Equal(t, id, l.ID(), "line %d should have ID %d", i, id) {
+			return
+		}
+	}
+}
+
+func TestInsertByScore(t *testing.T) {
+	testValues := []struct {
+		name     string
+		scores   []int
+		capacity int
+		expected []uint64
+	}{
+		{"sorted", []int{10, 30, 20, 30}, 0, []uint64{1, 3, 2, 0}},
+		// The oldest line goes, whatever its score is
+		{"capacity", []int{10, 30, 20, 30, 5}, 3, []uint64{3, 2, 4}},
+		{"capacity with the highest score", []int{30, 10, 20, 5}, 3, []uint64{2, 1, 3}},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			var lines []line.Line
+			for i, s := range v.scores {
+				lines = insertByScore(lines, line.NewScoredMatched(line.NewRaw(uint64(i), "", false), nil, s), v.capacity)
+			}
+
+			var ids []uint64
+			for _, l := range lines {
+				ids = append(ids, l.ID())
+			}
+			assert.Equal(t, v.expected, ids, "lines should be sorted by score")
+		})
+	}
+}
@@ -36,7 +36,10 @@ func _main() int {
 	if err := cli.Run(ctx); err != nil {
 		switch {
 		case util.IsCollectResultsError(err):
-			cli.PrintResults()
+			if err := cli.PrintResults(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+				return 1
+			}
 			return 0
 		case util.IsIgnorableError(err):
 			if st, ok := util.GetExitStatus(err); ok {
@@ -115,6 +115,8 @@ func (ss *StyleSet) Init() {
 	ss.SavedSelection.bg = termbox.ColorCyan
 	ss.Selected.fg = termbox.ColorDefault | termbox.AttrUnderline
 	ss.Selected.bg = termbox.ColorMagenta
+	ss.Origin.fg = termbox.ColorBlack | termbox.AttrBold
+	ss.Origin.bg = termbox.ColorDefault
 }
 
 // UnmarshalJSON satisfies json.RawMessage.
@@ -64,6 +64,10 @@ func TestReadRC(t *testing.T) {
 				fg: termbox.ColorBlack | termbox.AttrBold,
 				bg: termbox.ColorCyan,
 			},
+			Origin: Style{
+				fg: termbox.ColorBlack | termbox.AttrBold,
+				bg: termbox.ColorDefault,
+			},
 		},
 	}
 
@@ -0,0 +1,78 @@
+package peco
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/lestrrat-go/pdebug"
+	"github.com/peco/peco/internal/util"
+)
+
+// dynamicQuerySeparator separates the part of the query that is given
+// to the dynamic source command from the part that is used to filter
+// its output using the current filter
+const dynamicQuerySeparator = " -- "
+
+// splitDynamicQuery splits the query into the part that is given to
+// the dynamic source command, and the part that is used to filter its
+// output
+func splitDynamicQuery(query string) (string, string) {
+	i := strings.Index(query, dynamicQuerySeparator)
+	if i < 0 {
+		return query, ""
+	}
+	return query[:i], query[i+len(dynamicQuerySeparator):]
+}
+
+// dynamicSourceCommand creates the dynamic source command for the
+// query. {q} in the command is replaced with the query, which is also
+// available as PECO_QUERY
+func (p *Peco) dynamicSourceCommand(query string) *exec.Cmd {
+	command := strings.Replace(p.dynamicSource, "{q}", util.ShellQuote(query), -1)
+	cmd := util.Shell(command)
+	cmd.Env = append(os.Environ(), "PECO_QUERY="+query)
+	return cmd
+}
+
+// updateDynamicSource runs the dynamic source command again if the part
+// of the query that is given to it has changed, and returns the rest of
+// the query, which is used to filter its output
+func (p *Peco) updateDynamicSource(query string) string {
+	query, filterQuery := splitDynamicQuery(query)
+
+	p.reloadMutex.Lock()
+	defer p.reloadMutex.Unlock()
+
+	if query == p.dynamicQuery {
+		return filterQuery
+	}
+
+	if pdebug.Enabled {
+		g := pdebug.Marker("Peco.updateDynamicSource (query=%s)", query)
+		defer g.End()
+	}
+
+	if p.reloadCancel != nil {
+		p.reloadCancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p.reloadCancel = cancel
+	p.dynamicQuery = query
+
+	src, err := p.newCommandSource(ctx, p.dynamicSourceCommand(query), p.currentSource().Name(), true)
+	if err != nil {
+		p.Hub().SendStatusMsgAndClear(ctx, err.Error(), executeStatusClearDelay)
+		return filterQuery
+	}
+
+	p.mutex.Lock()
+	old := p.source
+	p.source = src
+	p.mutex.Unlock()
+	p.resultCache.Remove(old)
+	go src.Setup(ctx, p)
+
+	return filterQuery
+}
@@ -0,0 +1,68 @@
+package peco
+
+import (
+	"context"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitDynamicQuery(t *testing.T) {
+	testValues := []struct {
+		query       string
+		command     string
+		filterQuery string
+	}{
+		{"foo", "foo", ""},
+		{"foo -- bar", "foo", "bar"},
+		{"foo -- bar -- baz", "foo", "bar -- baz"},
+		{"foo --bar", "foo --bar", ""},
+		{"", "", ""},
+	}
+
+	for _, v := range testValues {
+		command, filterQuery := splitDynamicQuery(v.query)
+		assert.Equal(t, v.command, command, "command query for %q should match", v.query)
+		assert.Equal(t, v.filterQuery, filterQuery, "filter query for %q should match", v.query)
+	}
+}
+
+func TestUpdateDynamicSource(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses /bin/sh")
+	}
+
+	state := newPeco()
+	state.Argv = []string{"peco", "--dynamic-source", `echo {q}-1; echo $PECO_QUERY-2`}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go state.Run(ctx)
+
+	<-state.Ready()
+
+	expectLines := func(src *Source, expected ...string) {
+		<-src.SetupDone()
+		var lines []string
+		for i := 0; i < src.Size(); i++ {
+			l, _ := src.LineAt(i)
+			lines = append(lines, l.Buffer())
+		}
+		assert.Equal(t, expected, lines, "lines should match")
+	}
+	expectLines(state.currentSource(), "-1", "-2")
+
+	assert.Equal(t, "2", state.updateDynamicSource("foo -- 2"), "the filter query should be returned")
+	src := state.currentSource()
+	expectLines(src, "foo-1", "foo-2")
+
+	assert.Equal(t, "", state.updateDynamicSource("foo"), "the filter query should be returned")
+	assert.Equal(t, src, state.currentSource(), "the command should not be run again for the same query")
+
+	f := state.Filters().Current()
+	state.resultCache.Add(src, f, "2", NewMemoryBuffer())
+	state.updateDynamicSource("bar")
+	expectLines(state.currentSource(), "bar-1", "bar-2")
+	_, ok := state.resultCache.Get(src, f, "2")
+	assert.False(t, ok, "results for the replaced source should be dropped")
+}
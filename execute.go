@@ -0,0 +1,152 @@
+package peco
+
+import (
+	"context"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/btree"
+	"github.com/lestrrat-go/pdebug"
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/internal/util"
+	"github.com/peco/peco/line"
+	"github.com/pkg/errors"
+)
+
+// executeModes maps the names of the peco.Execute actions, which are
+// followed by a colon and the command, to what is done with the command
+var executeModes = map[string]executeMode{
+	"peco.Execute":       executeInteractive,
+	"peco.ExecuteSilent": executeSilent,
+	"peco.ExecuteReload": executeReload,
+	"peco.ExecuteStatus": executeStatus,
+}
+
+// executeStatusClearDelay is how long the output of commands and errors
+// are displayed in the status bar
+const executeStatusClearDelay = 5 * time.Second
+
+// reExecutePlaceholder matches the placeholders in commands: {} for the
+// current line, {+} for the selected lines, {q} for the query, and {f}
+// for the name of the input file
+var reExecutePlaceholder = regexp.MustCompile(`\{[+qf]?\}`)
+
+// parseExecuteAction creates the action for a name such as
+// "peco.ExecuteSilent:touch {}". Returns false if the name is not one
+// of the peco.Execute actions
+func parseExecuteAction(name string) (Action, bool) {
+	i := strings.IndexByte(name, ':')
+	if i < 0 {
+		return nil, false
+	}
+	mode, ok := executeModes[name[:i]]
+	if !ok {
+		return nil, false
+	}
+
+	command := name[i+1:]
+	return ActionFunc(func(ctx context.Context, state *Peco, _ termbox.Event) {
+		doExecute(ctx, state, mode, command)
+	}), true
+}
+
+// expandExecuteCommand replaces the placeholders in command with the
+// shell quoted values
+func expandExecuteCommand(command, current string, selected []string, query, filename string) string {
+	return reExecutePlaceholder.ReplaceAllStringFunc(command, func(s string) string {
+		switch s {
+		case "{+}":
+			quoted := make([]string, len(selected))
+			for i, l := range selected {
+				quoted[i] = util.ShellQuote(l)
+			}
+			return strings.Join(quoted, " ")
+		case "{q}":
+			return util.ShellQuote(query)
+		case "{f}":
+			return util.ShellQuote(filename)
+		default:
+			return util.ShellQuote(current)
+		}
+	})
+}
+
+func doExecute(ctx context.Context, state *Peco, mode executeMode, command string) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doExecute %d %s", mode, command)
+		defer g.End()
+	}
+
+	var current string
+	if l, err := state.CurrentLineBuffer().LineAt(state.Location().LineNumber()); err == nil {
+		current = l.Output()
+	}
+	sel := selectionOrCurrentLine(state)
+	var selected []string
+	sel.Ascend(func(it btree.Item) bool {
+		selected = append(selected, it.(line.Line).Output())
+		return true
+	})
+	var filename string
+	if s, ok := state.Source().(*Source); ok {
+		filename = s.Name()
+	}
+
+	cmd := util.Shell(expandExecuteCommand(command, current, selected, state.Query().String(), filename))
+	cmd.Env = commandEnv(state, sel)
+
+	if mode == executeInteractive {
+		in, out, err := util.OpenTty()
+		if err != nil {
+			state.Hub().SendStatusMsgAndClear(ctx, err.Error(), executeStatusClearDelay)
+			return
+		}
+		defer in.Close()
+		if out != in {
+			defer out.Close()
+		}
+		cmd.Stdin = in
+		cmd.Stdout = out
+		cmd.Stderr = out
+
+		state.screen.Suspend()
+		err = cmd.Run()
+		state.screen.Resume()
+		state.Hub().SendDraw(ctx, &DrawOptions{DisableCache: true})
+		if err != nil {
+			state.Hub().SendStatusMsgAndClear(ctx, "Command failed: "+err.Error(), executeStatusClearDelay)
+		}
+		return
+	}
+
+	// The other commands are run in the background, so that peco can
+	// still be used in the meantime
+	cmd.Stdin = selectionInput(sel)
+	go func() {
+		var err error
+		switch mode {
+		case executeReload:
+			state.Hub().SendStatusMsg(context.Background(), "Reloading...")
+			if err = state.reloadCommand(ctx, cmd); err == nil {
+				state.Hub().SendStatusMsg(context.Background(), "")
+			}
+		case executeStatus:
+			var out []byte
+			if out, err = cmd.Output(); err == nil {
+				msg := strings.Join(strings.Fields(string(out)), " ")
+				state.Hub().SendStatusMsgAndClear(context.Background(), msg, executeStatusClearDelay)
+			}
+		default:
+			cmd.Stdout = ioutil.Discard
+			cmd.Stderr = ioutil.Discard
+			err = cmd.Run()
+		}
+
+		if err != nil && ctx.Err() == nil {
+			err = errors.Wrap(err, "command failed")
+			state.Hub().SendStatusMsgAndClear(context.Background(), err.Error(), executeStatusClearDelay)
+		}
+	}()
+}
@@ -0,0 +1,51 @@
+package peco
+
+import (
+	"testing"
+
+	"github.com/peco/peco/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandExecuteCommand(t *testing.T) {
+	q := util.ShellQuote
+	testValues := []struct {
+		name     string
+		command  string
+		selected []string
+		expected string
+	}{
+		{"current line", "echo {}", nil, "echo " + q("foo bar")},
+		{"selected lines", "rm {+}", []string{"a", "b c"}, "rm " + q("a") + " " + q("b c")},
+		{"query and filename", "grep {q} {f}", nil, "grep " + q("it's") + " " + q("input.txt")},
+		{"no placeholders", "echo {x} {{}", nil, "echo {x} {" + q("foo bar")},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			s := expandExecuteCommand(v.command, "foo bar", v.selected, "it's", "input.txt")
+			assert.Equal(t, v.expected, s, "expanded command should match")
+		})
+	}
+}
+
+func TestParseExecuteAction(t *testing.T) {
+	testValues := []struct {
+		name string
+		ok   bool
+	}{
+		{"peco.Execute:vim {}", true},
+		{"peco.ExecuteSilent:touch {+}", true},
+		{"peco.ExecuteReload:ls", true},
+		{"peco.ExecuteStatus:wc -l {}", true},
+		{"peco.Execute", false},
+		{"peco.ExecuteForeground:ls", false},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			_, ok := parseExecuteAction(v.name)
+			assert.Equal(t, v.ok, ok, "ok should match")
+		})
+	}
+}
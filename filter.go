@@ -1,6 +1,9 @@
 package peco
 
 import (
+	"fmt"
+	"runtime"
+	"sort"
 	"sync"
 	"time"
 
@@ -21,13 +24,33 @@ func newFilterProcessor(f filter.Filter, q string) *filterProcessor {
 	}
 }
 
+// filterErrorClearDelay is how long errors reported by filters are
+// displayed in the status bar
+const filterErrorClearDelay = 5 * time.Second
+
 func (fp *filterProcessor) Accept(ctx context.Context, in chan interface{}, out pipeline.ChanOutput) {
-	acceptAndFilter(ctx, fp.filter, in, out)
+	acceptAndFilter(ctx, fp.filter, in, out, fp.setErr)
+}
+
+// setErr records the first error reported by the filter
+func (fp *filterProcessor) setErr(err error) {
+	fp.mutex.Lock()
+	defer fp.mutex.Unlock()
+	if fp.err == nil {
+		fp.err = err
+	}
+}
+
+// Err returns the first error reported by the filter, if any
+func (fp *filterProcessor) Err() error {
+	fp.mutex.Lock()
+	defer fp.mutex.Unlock()
+	return fp.err
 }
 
 // This flusher is run in a separate goroutine so that the filter can
 // run separately from accepting incoming messages
-func flusher(ctx context.Context, f filter.Filter, incoming chan []line.Line, done chan struct{}, out pipeline.ChanOutput) {
+func flusher(ctx context.Context, f filter.Filter, incoming chan []line.Line, done chan struct{}, out pipeline.ChanOutput, onError func(error)) {
 	if pdebug.Enabled {
 		g := pdebug.Marker("flusher goroutine")
 		defer g.End()
@@ -45,16 +68,109 @@ func flusher(ctx context.Context, f filter.Filter, incoming chan []line.Line, do
 				return
 			}
 			pdebug.Printf("flusher: %#v", buf)
-			f.Apply(ctx, buf, out)
+			applyFilter(ctx, f, buf, out, onError)
 			buffer.ReleaseLineListBuf(buf)
 		}
 	}
 }
 
-func acceptAndFilter(ctx context.Context, f filter.Filter, in chan interface{}, out pipeline.ChanOutput) {
+// parallelFlusher is like flusher, but applies the filter to up to
+// `workers` batches at the same time. The results are sent in the
+// order that the batches were received, so the output is the same as
+// that of flusher
+func parallelFlusher(ctx context.Context, f filter.Filter, incoming chan []line.Line, done chan struct{}, out pipeline.ChanOutput, onError func(error), workers int) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("parallelFlusher goroutine (%d workers)", workers)
+		defer g.End()
+	}
+
+	defer close(done)
+	defer out.SendEndMark("end of filter")
+
+	// Each batch gets a slot that its results are delivered to. The
+	// slots are queued in the order that the batches were received,
+	// and are drained in that order. The size of the queue limits the
+	// number of batches being worked on at the same time
+	slots := make(chan chan []interface{}, workers)
+	mergeDone := make(chan struct{})
+	go func() {
+		defer close(mergeDone)
+		for slot := range slots {
+			select {
+			case <-ctx.Done():
+				return
+			case results := <-slot:
+				for _, v := range results {
+					out.Send(v)
+				}
+			}
+		}
+	}()
+	defer func() { <-mergeDone }()
+	defer close(slots)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case buf, ok := <-incoming:
+			if !ok {
+				return
+			}
+
+			slot := make(chan []interface{}, 1)
+			select {
+			case <-ctx.Done():
+				return
+			case slots <- slot:
+			}
+
+			go func(buf []line.Line) {
+				results := make(chan interface{}, len(buf))
+				go func() {
+					defer close(results)
+					applyFilter(ctx, f, buf, pipeline.ChanOutput(results), onError)
+					buffer.ReleaseLineListBuf(buf)
+				}()
+
+				var collected []interface{}
+				for v := range results {
+					collected = append(collected, v)
+				}
+				slot <- collected
+			}(buf)
+		}
+	}
+}
+
+// applyFilter applies the filter to a batch of lines, and reports
+// errors that are not caused by the query being canceled
+func applyFilter(ctx context.Context, f filter.Filter, buf []line.Line, out pipeline.ChanOutput, onError func(error)) {
+	if err := f.Apply(ctx, buf, out); err != nil && ctx.Err() == nil {
+		if pdebug.Enabled {
+			pdebug.Printf("filter returned error: %s", err)
+		}
+		onError(err)
+	}
+}
+
+// filterWorkers returns the number of batches that may be filtered at
+// the same time using the given filter
+func filterWorkers(f filter.Filter) int {
+	if c, ok := f.(filter.ConcurrentApplier); ok && c.ConcurrentApply() {
+		return runtime.GOMAXPROCS(0)
+	}
+	return 1
+}
+
+func acceptAndFilter(ctx context.Context, f filter.Filter, in chan interface{}, out pipeline.ChanOutput, onError func(error)) {
 	flush := make(chan []line.Line)
 	flushDone := make(chan struct{})
-	go flusher(ctx, f, flush, flushDone, out)
+	if workers := filterWorkers(f); workers > 1 {
+		go parallelFlusher(ctx, f, flush, flushDone, out, onError, workers)
+	} else {
+		go flusher(ctx, f, flush, flushDone, out, onError)
+	}
 
 	buf := buffer.GetLineListBuf()
 	bufsiz := f.BufSize()
@@ -115,10 +231,134 @@ func acceptAndFilter(ctx context.Context, f filter.Filter, in chan interface{},
 
 func NewFilter(state *Peco) *Filter {
 	return &Filter{
+		cache: &state.resultCache,
 		state: state,
 	}
 }
 
+func newBufferSource(buf *MemoryBuffer) *bufferSource {
+	buf.mutex.RLock()
+	defer buf.mutex.RUnlock()
+
+	lines := make([]line.Line, len(buf.lines))
+	for i, l := range buf.lines {
+		// Unwrap the results of the previous query, so that we don't
+		// accumulate layers of line.Matched as the query grows
+		for {
+			m, ok := l.(*line.Matched)
+			if !ok {
+				break
+			}
+			l = m.Line
+		}
+		lines[i] = l
+	}
+
+	// Results may have been ranked by the filter. Restore the order
+	// in which the lines were read, as the source would have sent them
+	if !sort.SliceIsSorted(lines, func(i, j int) bool { return lines[i].ID() < lines[j].ID() }) {
+		sort.Slice(lines, func(i, j int) bool { return lines[i].ID() < lines[j].ID() })
+	}
+	return &bufferSource{lines: lines}
+}
+
+// Start sends the lines, in the order that they were read
+func (bs *bufferSource) Start(ctx context.Context, out pipeline.ChanOutput) {
+	defer out.SendEndMark("end of buffer")
+
+	for _, l := range bs.lines {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			out.Send(l)
+		}
+	}
+}
+
+// Reset is a no-op, as the lines never change
+func (bs *bufferSource) Reset() {}
+
+// Get returns the results for the given query, if they are cached
+func (rc *resultCache) Get(src pipeline.Source, f filter.Filter, query string) (*MemoryBuffer, bool) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	for _, e := range rc.entries {
+		if e.source == src && e.filter == f && e.query == query {
+			return e.buf, true
+		}
+	}
+	return nil, false
+}
+
+// Narrowest returns the smallest cached result set that is guaranteed
+// to contain all of the results for the given query, if any
+func (rc *resultCache) Narrowest(src pipeline.Source, f filter.Filter, query string) *MemoryBuffer {
+	n, ok := f.(filter.Narrower)
+	if !ok {
+		return nil
+	}
+
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	var found *MemoryBuffer
+	for _, e := range rc.entries {
+		if e.source != src || e.filter != f || !n.Narrows(e.query, query) {
+			continue
+		}
+		if found == nil || e.buf.Size() < found.Size() {
+			found = e.buf
+		}
+	}
+	return found
+}
+
+// Add remembers the results for the given query, evicting the oldest
+// results if the cache is full
+func (rc *resultCache) Add(src pipeline.Source, f filter.Filter, query string, buf *MemoryBuffer) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	for i, e := range rc.entries {
+		if e.source == src && e.filter == f && e.query == query {
+			rc.entries = append(rc.entries[:i], rc.entries[i+1:]...)
+			break
+		}
+	}
+
+	if len(rc.entries) >= resultCacheSize {
+		rc.entries = rc.entries[1:]
+	}
+	rc.entries = append(rc.entries, resultCacheEntry{
+		buf:    buf,
+		filter: f,
+		query:  query,
+		source: src,
+	})
+}
+
+// Remove forgets the results for the given source, so that the
+// source and its lines can be released
+func (rc *resultCache) Remove(src pipeline.Source) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	entries := rc.entries[:0]
+	for _, e := range rc.entries {
+		if e.source != src {
+			entries = append(entries, e)
+		}
+	}
+	// Clear the entries that are no longer used, so that they don't
+	// keep their sources alive
+	for i := len(entries); i < len(rc.entries); i++ {
+		rc.entries[i] = resultCacheEntry{}
+	}
+	rc.entries = entries
+}
+
 // Work is the actual work horse that that does the matching
 // in a goroutine of its own. It wraps Matcher.Match().
 func (f *Filter) Work(ctx context.Context, q hub.Payload) {
@@ -143,16 +383,62 @@ func (f *Filter) Work(ctx context.Context, q hub.Payload) {
 		return
 	}
 
+	selectedFilter := state.Filters().Current()
+
+	// Results can only be reused if we have read all of the input.
+	// Otherwise they would be missing the lines read since then
+	src := state.Source()
+	var cacheable bool
+	if s, ok := src.(*Source); ok {
+		select {
+		case <-s.SetupDone():
+			cacheable = true
+		default:
+		}
+	}
+
+	if cacheable {
+		if buf, ok := f.cache.Get(src, selectedFilter, query); ok {
+			if pdebug.Enabled {
+				pdebug.Printf("Reusing cached results for '%s'", query)
+			}
+			state.SetCurrentLineBuffer(buf)
+			state.Hub().SendStatusMsg(ctx, "")
+			if !state.config.StickySelection {
+				state.Selection().Reset()
+			}
+			return
+		}
+	}
+
 	// Create a new pipeline
 	p := pipeline.New()
-	p.SetSource(state.Source())
+	p.SetSource(src)
+	if cacheable {
+		// If the query narrows a previous query, we only need to look
+		// at the results of that query
+		if buf := f.cache.Narrowest(src, selectedFilter, query); buf != nil {
+			if pdebug.Enabled {
+				pdebug.Printf("Narrowing down %d cached results for '%s'", buf.Size(), query)
+			}
+			p.SetSource(newBufferSource(buf))
+		}
+	}
 
 	// Wraps the actual filter
-	selectedFilter := state.Filters().Current()
 	ctx = selectedFilter.NewContext(ctx, query)
-	p.Add(newFilterProcessor(selectedFilter, query))
+	fp := newFilterProcessor(selectedFilter, query)
+	p.Add(fp)
 
 	buf := NewMemoryBuffer()
+	if r, ok := selectedFilter.(filter.Ranker); ok {
+		buf.rankByScore = r.RankResults()
+		// With --follow, the end of the input never comes
+		buf.rankIncrementally = buf.rankByScore && state.follow
+	}
+	// Sources that are still being read may keep sending lines, so
+	// the results are limited like the lines in the source
+	buf.capacity = state.bufferSize
 	p.SetDestination(buf)
 	state.SetCurrentLineBuffer(buf)
 
@@ -170,20 +456,40 @@ func (f *Filter) Work(ctx context.Context, q hub.Payload) {
 		}
 		t := time.NewTicker(5 * time.Millisecond)
 		defer t.Stop()
-		defer state.Hub().SendStatusMsg(ctx, "")
+		defer func() {
+			// Let the user know that the filter is broken, as opposed
+			// to simply not matching anything
+			if err := fp.Err(); err != nil {
+				state.Hub().SendStatusMsgAndClear(ctx, fmt.Sprintf("%s: %s", selectedFilter, err), filterErrorClearDelay)
+				return
+			}
+			state.Hub().SendStatusMsg(ctx, "")
+		}()
 		defer state.Hub().SendDraw(ctx, &DrawOptions{RunningQuery: true})
+
+		// Inputs that never end keep the query running, so the screen
+		// is only drawn when there are new results
+		drawn := -1
 		for {
 			select {
 			case <-p.Done():
 				return
 			case <-t.C:
-				state.Hub().SendDraw(ctx, &DrawOptions{RunningQuery: true})
+				if n := buf.receivedCount(); n != drawn {
+					drawn = n
+					state.Hub().SendDraw(ctx, &DrawOptions{RunningQuery: true})
+				}
 			}
 		}
 	}()
 
 	<-p.Done()
 
+	// Only complete results may be reused
+	if cacheable && ctx.Err() == nil && fp.Err() == nil {
+		f.cache.Add(src, selectedFilter, query, buf)
+	}
+
 	if !state.config.StickySelection {
 		state.Selection().Reset()
 	}
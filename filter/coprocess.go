@@ -0,0 +1,242 @@
+package filter
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os/exec"
+	"time"
+
+	pdebug "github.com/lestrrat-go/pdebug"
+	"github.com/pkg/errors"
+)
+
+// coprocessStopTimeout is how long we wait for a coprocess to exit by
+// itself after closing its stdin, before killing it
+const coprocessStopTimeout = time.Second
+
+var errCoprocessExited = errors.New("custom filter command exited")
+
+// startCoprocess starts the command, and a goroutine that reads its
+// responses
+func startCoprocess(name string, args []string) (*coprocess, error) {
+	cmd := exec.Command(name, args...)
+	if pdebug.Enabled {
+		pdebug.Printf("Starting coprocess %s %v", cmd.Path, cmd.Args)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to get stdin pipe`)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to get stdout pipe`)
+	}
+
+	stderr := &stderrBuffer{}
+	cmd.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Wrap(err, `failed to start command`)
+	}
+
+	cp := &coprocess{
+		cmd:     cmd,
+		done:    make(chan struct{}),
+		enc:     json.NewEncoder(stdin),
+		pending: make(map[uint64]*coprocessRequest),
+		stderr:  stderr,
+		stdin:   stdin,
+	}
+	go cp.readLoop(stdout)
+	return cp, nil
+}
+
+// Exited returns true if the command is no longer running
+func (cp *coprocess) Exited() bool {
+	select {
+	case <-cp.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Query sends a query along with the lines to match against. Matches
+// are sent through the returned channel, which is closed once the
+// command is done with this request. If ctx is done before the query
+// could be sent, the command is killed
+func (cp *coprocess) Query(ctx context.Context, query string, lines []coprocessLine) (uint64, *coprocessRequest, error) {
+	req := &coprocessRequest{
+		canceled: make(chan struct{}),
+		ch:       make(chan coprocessResponse),
+	}
+
+	cp.mutex.Lock()
+	if cp.pending == nil {
+		cp.mutex.Unlock()
+		return 0, nil, errCoprocessExited
+	}
+	cp.nextID++
+	id := cp.nextID
+	cp.pending[id] = req
+	cp.mutex.Unlock()
+
+	err := cp.sendContext(ctx, coprocessMessage{
+		Type:    "query",
+		Request: id,
+		Query:   query,
+		Lines:   lines,
+	})
+	if err != nil {
+		cp.forget(id)
+		return 0, nil, errors.Wrap(err, `failed to send query`)
+	}
+	return id, req, nil
+}
+
+// Cancel tells the command that we are no longer interested in the
+// results for the given request
+func (cp *coprocess) Cancel(id uint64) {
+	if !cp.forget(id) {
+		return
+	}
+
+	// This is just a hint for the command, so errors are not fatal.
+	// It is sent in the background, as the command may not be reading
+	// its input. The write fails once the command is stopped
+	go func() {
+		if err := cp.send(coprocessMessage{Type: "cancel", Request: id}); err != nil {
+			if pdebug.Enabled {
+				pdebug.Printf("failed to send cancel for request %d: %s", id, err)
+			}
+		}
+	}()
+}
+
+// forget removes a pending request. Returns false if the request was
+// already completed
+func (cp *coprocess) forget(id uint64) bool {
+	cp.mutex.Lock()
+	req, ok := cp.pending[id]
+	if ok {
+		delete(cp.pending, id)
+	}
+	cp.mutex.Unlock()
+
+	if ok {
+		close(req.canceled)
+	}
+	return ok
+}
+
+func (cp *coprocess) send(msg coprocessMessage) error {
+	cp.writeMutex.Lock()
+	defer cp.writeMutex.Unlock()
+	return cp.enc.Encode(msg)
+}
+
+// sendContext sends msg like send, but gives up once ctx is done. The
+// command may have received part of the message by then, so it is
+// killed, and started again for the next query
+func (cp *coprocess) sendContext(ctx context.Context, msg coprocessMessage) error {
+	errCh := make(chan error, 1)
+	go func() { errCh <- cp.send(msg) }()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		if pdebug.Enabled {
+			pdebug.Printf("gave up sending %s %d, killing coprocess", msg.Type, msg.Request)
+		}
+		if p := cp.cmd.Process; p != nil {
+			p.Kill()
+		}
+		// Wait for the command to be gone, so that it is not used
+		// for the next query
+		<-cp.done
+		return ctx.Err()
+	}
+}
+
+func (cp *coprocess) readLoop(r io.Reader) {
+	dec := json.NewDecoder(r)
+	var err error
+	for {
+		var res coprocessResponse
+		if err = dec.Decode(&res); err != nil {
+			break
+		}
+
+		cp.mutex.Lock()
+		req, ok := cp.pending[res.Request]
+		if ok && res.Done {
+			delete(cp.pending, res.Request)
+		}
+		cp.mutex.Unlock()
+
+		if !ok {
+			// stale response for a canceled request
+			continue
+		}
+
+		if res.Done {
+			close(req.ch)
+			continue
+		}
+
+		select {
+		case req.ch <- res:
+		case <-req.canceled:
+		}
+	}
+
+	if err != io.EOF {
+		// Make sure the command is gone, as we can't talk to it anymore
+		err = errors.Wrap(err, `failed to decode response`)
+		if p := cp.cmd.Process; p != nil {
+			p.Kill()
+		}
+	}
+
+	if werr := cp.cmd.Wait(); werr != nil && err == io.EOF {
+		err = commandError(werr, cp.stderr)
+	} else if err == io.EOF {
+		err = errCoprocessExited
+	}
+	if pdebug.Enabled {
+		pdebug.Printf("coprocess read loop exiting: %s", err)
+	}
+
+	cp.mutex.Lock()
+	pending := cp.pending
+	cp.pending = nil
+	cp.mutex.Unlock()
+
+	for _, req := range pending {
+		req.err = err
+		close(req.ch)
+	}
+	close(cp.done)
+}
+
+// Stop closes the command's stdin, which should be its cue to exit.
+// If it doesn't exit in time, it is killed
+func (cp *coprocess) Stop() error {
+	// Not holding writeMutex here, as a pending write may be blocked
+	// on a command that is not reading its input
+	cp.stdin.Close()
+
+	select {
+	case <-cp.done:
+	case <-time.After(coprocessStopTimeout):
+		if p := cp.cmd.Process; p != nil {
+			p.Kill()
+		}
+		<-cp.done
+	}
+	return nil
+}
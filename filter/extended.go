@@ -0,0 +1,253 @@
+package filter
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/peco/peco/internal/util"
+	"github.com/peco/peco/line"
+	"github.com/peco/peco/pipeline"
+	"github.com/pkg/errors"
+)
+
+type extendedTermType int
+
+const (
+	extendedFuzzy  extendedTermType = iota // foo
+	extendedExact                          // 'foo
+	extendedPrefix                         // ^foo
+	extendedSuffix                         // foo$
+	extendedEqual                          // ^foo$
+)
+
+// extendedTerm is a single term in the extended search syntax
+type extendedTerm struct {
+	typ           extendedTermType
+	inverse       bool
+	caseSensitive bool
+	text          []rune         // used by fuzzy terms
+	rx            *regexp.Regexp // used by everything else
+}
+
+// NewExtended creates a filter that understands an extended search
+// syntax similar to that of fzf. The query is split by white space
+// into terms, all of which must match:
+//
+//	foo     fuzzy match
+//	'foo    exact substring match
+//	^foo    prefix match
+//	foo$    suffix match
+//	^foo$   the whole line must be equal to foo
+//	^$      the line must be empty
+//	!foo    inverse match (also works with ^ and $)
+//	a | b   matches if either a or b matches
+//
+// Each term uses smart case matching, like the SmartCase filter.
+func NewExtended() *Extended {
+	return &Extended{}
+}
+
+func (ef *Extended) BufSize() int {
+	return 0
+}
+
+func (ef *Extended) NewContext(ctx context.Context, query string) context.Context {
+	return newContext(ctx, query)
+}
+
+func (ef *Extended) String() string {
+	return "Extended"
+}
+
+// ConcurrentApply returns true, as the parsed query is shared safely
+// between calls to Apply
+func (ef *Extended) ConcurrentApply() bool {
+	return true
+}
+
+// compile parses the query. The result of the last successful
+// parse is cached, as Apply is called many times for the same query
+func (ef *Extended) compile(query string) ([][]extendedTerm, error) {
+	ef.mutex.Lock()
+	defer ef.mutex.Unlock()
+
+	if ef.terms != nil && ef.query == query {
+		return ef.terms, nil
+	}
+
+	terms, err := parseExtendedQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	ef.query = query
+	ef.terms = terms
+	return terms, nil
+}
+
+func (ef *Extended) Apply(ctx context.Context, lines []line.Line, out pipeline.ChanOutput) error {
+	query := ctx.Value(queryKey).(string)
+	groups, err := ef.compile(query)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse query")
+	}
+
+OUTER:
+	for _, l := range lines {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		txt := l.MatchString()
+		matches := [][]int{}
+		for _, group := range groups {
+			groupMatched := false
+			for _, term := range group {
+				ok, indices := term.match(txt)
+				if !ok {
+					continue
+				}
+				groupMatched = true
+				matches = append(matches, indices...)
+			}
+			if !groupMatched {
+				continue OUTER
+			}
+		}
+		if len(matches) > 0 {
+			matches = dedupeMatches(matches)
+		}
+		out.Send(line.NewMatched(l, matches))
+	}
+	return nil
+}
+
+// parseExtendedQuery parses the query into a list of groups. Every
+// group must match for a line to match, and a group matches if any
+// one of its terms matches. A "|" is only an operator between two
+// terms: at either end of the query, or right after another "|",
+// it is a literal term
+func parseExtendedQuery(query string) ([][]extendedTerm, error) {
+	var groups [][]extendedTerm
+	var continueGroup bool
+	tokens := strings.Fields(query)
+	for i, token := range tokens {
+		if token == "|" && len(groups) > 0 && !continueGroup && i < len(tokens)-1 {
+			continueGroup = true
+			continue
+		}
+
+		term, err := parseExtendedTerm(token)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse term '%s'", token)
+		}
+
+		if continueGroup {
+			groups[len(groups)-1] = append(groups[len(groups)-1], term)
+			continueGroup = false
+			continue
+		}
+		groups = append(groups, []extendedTerm{term})
+	}
+	return groups, nil
+}
+
+func parseExtendedTerm(token string) (extendedTerm, error) {
+	var term extendedTerm
+	text := token
+
+	if strings.HasPrefix(text, "!") {
+		term.inverse = true
+		text = text[1:]
+		// inverse matches are always exact
+		term.typ = extendedExact
+	}
+
+	switch {
+	case strings.HasPrefix(text, "'"):
+		term.typ = extendedExact
+		text = text[1:]
+	case strings.HasPrefix(text, "^"):
+		term.typ = extendedPrefix
+		text = text[1:]
+	}
+
+	// "^$" matches empty lines
+	if term.typ == extendedPrefix && text == "$" {
+		term.typ = extendedEqual
+		return compileExtendedTerm(term, "")
+	}
+
+	if len(text) > 1 && strings.HasSuffix(text, "$") {
+		if term.typ == extendedPrefix {
+			term.typ = extendedEqual
+		} else {
+			term.typ = extendedSuffix
+		}
+		text = text[:len(text)-1]
+	}
+
+	// Things like a lone "^" or "!" are treated as literal strings
+	if text == "" {
+		text = token
+		term.typ = extendedExact
+		term.inverse = false
+	}
+
+	return compileExtendedTerm(term, text)
+}
+
+func compileExtendedTerm(term extendedTerm, text string) (extendedTerm, error) {
+	term.caseSensitive = util.ContainsUpper(text)
+	if term.typ == extendedFuzzy {
+		term.text = []rune(text)
+		if !term.caseSensitive {
+			for i, r := range term.text {
+				term.text[i] = unicode.ToLower(r)
+			}
+		}
+		return term, nil
+	}
+
+	pattern := regexp.QuoteMeta(text)
+	switch term.typ {
+	case extendedPrefix:
+		pattern = "^" + pattern
+	case extendedSuffix:
+		pattern = pattern + "$"
+	case extendedEqual:
+		pattern = "^" + pattern + "$"
+	}
+
+	var flags []string
+	if !term.caseSensitive {
+		flags = ignoreCaseFlags
+	}
+	rx, err := regexpFor(pattern, flags, false)
+	if err != nil {
+		return term, err
+	}
+	term.rx = rx
+	return term, nil
+}
+
+// match returns true if the term matches against txt. For positive
+// terms the matched regions are also returned
+func (t extendedTerm) match(txt string) (bool, [][]int) {
+	var matched bool
+	var indices [][]int
+	if t.typ == extendedFuzzy {
+		_, indices, matched = scoreFuzzy(txt, t.text, t.caseSensitive)
+	} else {
+		indices = t.rx.FindAllStringIndex(txt, -1)
+		matched = indices != nil
+	}
+
+	if t.inverse {
+		return !matched, nil
+	}
+	return matched, indices
+}
@@ -5,6 +5,9 @@ import (
 	"bytes"
 	"context"
 	"os/exec"
+	"strconv"
+	"strings"
+	"time"
 
 	pdebug "github.com/lestrrat-go/pdebug"
 	"github.com/peco/peco/line"
@@ -13,9 +16,16 @@ import (
 )
 
 // NewExternalCmd creates a new filter that uses an external
-// command to filter the input
-func NewExternalCmd(name string, cmd string, args []string, threshold int, idgen line.IDGenerator, enableSep bool) *ExternalCmd {
-	if len(args) == 0 {
+// command to filter the input. If `persistent` is true, the command
+// is started only once, and is sent queries using the protocol
+// described in the README, instead of being invoked for every query.
+// If `timeout` is positive, commands that take longer than that to
+// process a batch of lines are considered to have failed.
+// If `lineID` is true, each line sent to the command is prefixed with
+// its ID, which the command must print back for matching lines.
+// Otherwise the command's output is mapped back to the input by text
+func NewExternalCmd(name string, cmd string, args []string, threshold int, idgen line.IDGenerator, enableSep bool, persistent bool, lineID bool, timeout time.Duration) *ExternalCmd {
+	if len(args) == 0 && !persistent {
 		args = []string{"$QUERY"}
 	}
 
@@ -28,13 +38,16 @@ func NewExternalCmd(name string, cmd string, args []string, threshold int, idgen
 		cmd:             cmd,
 		enableSep:       enableSep,
 		idgen:           idgen,
+		lineID:          lineID,
 		name:            name,
 		outCh:           pipeline.ChanOutput(make(chan interface{})),
+		persistent:      persistent,
 		thresholdBufsiz: threshold,
+		timeout:         timeout,
 	}
 }
 
-func (ecf ExternalCmd) BufSize() int {
+func (ecf *ExternalCmd) BufSize() int {
 	return ecf.thresholdBufsiz
 }
 
@@ -42,24 +55,177 @@ func (ecf *ExternalCmd) NewContext(ctx context.Context, query string) context.Co
 	return newContext(ctx, query)
 }
 
-func (ecf ExternalCmd) String() string {
+func (ecf *ExternalCmd) String() string {
 	return ecf.name
 }
 
-func (ecf *ExternalCmd) Apply(ctx context.Context, buf []line.Line, out pipeline.ChanOutput) (err error) {
-	defer func() {
-		if err := recover(); err != nil {
-			if pdebug.Enabled {
-				pdebug.Printf("err: %s", err)
+// Close stops the command, if it is running in persistent mode
+func (ecf *ExternalCmd) Close() error {
+	ecf.coprocMutex.Lock()
+	defer ecf.coprocMutex.Unlock()
+
+	if ecf.coproc == nil {
+		return nil
+	}
+	err := ecf.coproc.Stop()
+	ecf.coproc = nil
+	return err
+}
+
+// coprocess returns the running command for persistent mode, starting
+// it if it has not been started yet (or if it has exited)
+func (ecf *ExternalCmd) coprocess() (*coprocess, error) {
+	ecf.coprocMutex.Lock()
+	defer ecf.coprocMutex.Unlock()
+
+	if ecf.coproc != nil && !ecf.coproc.Exited() {
+		return ecf.coproc, nil
+	}
+
+	cp, err := startCoprocess(ecf.cmd, ecf.args)
+	if err != nil {
+		return nil, err
+	}
+	ecf.coproc = cp
+	return cp, nil
+}
+
+func (ecf *ExternalCmd) applyPersistent(ctx context.Context, query string, buf []line.Line, out pipeline.ChanOutput) error {
+	cp, err := ecf.coprocess()
+	if err != nil {
+		return errors.Wrap(err, `failed to start command`)
+	}
+
+	lines := make(map[uint64]line.Line, len(buf))
+	reqLines := make([]coprocessLine, len(buf))
+	for i, l := range buf {
+		lines[l.ID()] = l
+		reqLines[i] = coprocessLine{ID: l.ID(), Text: l.MatchString()}
+	}
+
+	// The timeout includes sending the query, as the command may not
+	// be reading its input
+	parent := ctx
+	ctx, cancel := ecf.withTimeout(ctx)
+	defer cancel()
+
+	id, req, err := cp.Query(ctx, query, reqLines)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ecf.timeoutError(parent, ctx)
+		}
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			cp.Cancel(id)
+			return ecf.timeoutError(parent, ctx)
+		case res, ok := <-req.ch:
+			if !ok {
+				return req.err
+			}
+
+			l, ok := lines[res.ID]
+			if !ok {
+				continue
 			}
+			out.Send(line.NewMatched(l, validRanges(res.Ranges, len(l.MatchString()))))
+		}
+	}
+}
+
+// resolve maps a line printed by the external command back to one of
+// the lines that were sent to it
+func (ecf *ExternalCmd) resolve(lines *externalLineMap, s string) line.Line {
+	if l := lines.Resolve(s); l != nil {
+		return line.NewMatched(l, nil)
+	}
+
+	if ecf.lineID {
+		if pdebug.Enabled {
+			pdebug.Printf("ExternalCmd: ignoring unknown line ID in '%s'", s)
+		}
+		return nil
+	}
+
+	// The command printed something that we did not send. We have no
+	// choice but to create a new line for it
+	return line.NewRaw(ecf.idgen.Next(), s, ecf.enableSep)
+}
+
+func newExternalLineMap(size int, lineID bool) *externalLineMap {
+	m := &externalLineMap{lineID: lineID}
+	if lineID {
+		m.byID = make(map[uint64]line.Line, size)
+	} else {
+		m.byText = make(map[string][]line.Line, size)
+	}
+	return m
+}
+
+// Add registers a line that is being sent to the external command
+func (m *externalLineMap) Add(l line.Line) {
+	if m.lineID {
+		m.byID[l.ID()] = l
+		return
+	}
+	txt := l.MatchString()
+	m.byText[txt] = append(m.byText[txt], l)
+}
+
+// Resolve returns the line that the external command referred to in
+// `s`, or nil if it cannot be found. When resolving by text, duplicate
+// lines are resolved in the order that they were added
+func (m *externalLineMap) Resolve(s string) line.Line {
+	if m.lineID {
+		if i := strings.IndexByte(s, '\t'); i >= 0 {
+			s = s[:i]
 		}
-	}() // ignore errors
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil
+		}
+		return m.byID[id]
+	}
+
+	candidates := m.byText[s]
+	if len(candidates) == 0 {
+		return nil
+	}
+	m.byText[s] = candidates[1:]
+	return candidates[0]
+}
+
+// validRanges drops ranges reported by external commands that do not
+// fit in a string of length `max`, so that they can be safely used
+// for highlighting
+func validRanges(ranges [][]int, max int) [][]int {
+	var valid [][]int
+	for _, r := range ranges {
+		if len(r) != 2 || r[0] < 0 || r[0] >= r[1] || r[1] > max {
+			continue
+		}
+		valid = append(valid, r)
+	}
+	if len(valid) == 0 {
+		return nil
+	}
+	return dedupeMatches(valid)
+}
+
+func (ecf *ExternalCmd) Apply(ctx context.Context, buf []line.Line, out pipeline.ChanOutput) (err error) {
 	if pdebug.Enabled {
 		g := pdebug.Marker("ExternalCmd.Apply").BindError(&err)
 		defer g.End()
 	}
 
 	query := ctx.Value(queryKey).(string)
+	if ecf.persistent {
+		return ecf.applyPersistent(ctx, query, buf, out)
+	}
+
 	args := append([]string(nil), ecf.args...)
 	for i, v := range args {
 		if v == "$QUERY" {
@@ -73,11 +239,18 @@ func (ecf *ExternalCmd) Apply(ctx context.Context, buf []line.Line, out pipeline
 	}
 
 	inbuf := &bytes.Buffer{}
+	lines := newExternalLineMap(len(buf), ecf.lineID)
 	for _, l := range buf {
-		inbuf.WriteString(l.DisplayString() + "\n")
+		if ecf.lineID {
+			inbuf.WriteString(strconv.FormatUint(l.ID(), 10) + "\t")
+		}
+		inbuf.WriteString(l.MatchString() + "\n")
+		lines.Add(l)
 	}
 
+	stderr := &stderrBuffer{}
 	cmd.Stdin = inbuf
+	cmd.Stderr = stderr
 	r, err := cmd.StdoutPipe()
 	if err != nil {
 		return errors.Wrap(err, `failed to get stdout pipe`)
@@ -88,12 +261,17 @@ func (ecf *ExternalCmd) Apply(ctx context.Context, buf []line.Line, out pipeline
 		return errors.Wrap(err, `failed to start command`)
 	}
 
-	go cmd.Wait()
+	parent := ctx
+	ctx, cancel := ecf.withTimeout(ctx)
+	defer cancel()
 
 	cmdCh := make(chan line.Line)
+	waitCh := make(chan error, 1)
 	go func(ctx context.Context, cmdCh chan line.Line, rdr *bufio.Reader) {
-		defer func() { recover() }()
 		defer close(cmdCh)
+		// Wait closes the stdout pipe, so it must not be called until
+		// we are done reading from it
+		defer func() { waitCh <- cmd.Wait() }()
 		for {
 			select {
 			case <-ctx.Done():
@@ -103,14 +281,12 @@ func (ecf *ExternalCmd) Apply(ctx context.Context, buf []line.Line, out pipeline
 
 			b, _, err := rdr.ReadLine()
 			if len(b) > 0 {
-				// TODO: need to redo the spec for custom matchers
-				// This is the ONLY location where we need to actually
-				// RECREATE a Raw, and thus the only place where
-				// ctx.enableSep is required.
-				select {
-				case cmdCh <- line.NewRaw(ecf.idgen.Next(), string(b), ecf.enableSep):
-				case <-ctx.Done():
-					return
+				if l := ecf.resolve(lines, string(b)); l != nil {
+					select {
+					case cmdCh <- l:
+					case <-ctx.Done():
+						return
+					}
 				}
 			}
 			if err != nil {
@@ -128,13 +304,70 @@ func (ecf *ExternalCmd) Apply(ctx context.Context, buf []line.Line, out pipeline
 	for {
 		select {
 		case <-ctx.Done():
-			return nil
+			return ecf.timeoutError(parent, ctx)
 		case l, ok := <-cmdCh:
-			if l == nil || !ok {
+			if !ok {
+				if err := <-waitCh; err != nil && !isNoMatch(err, stderr) {
+					return commandError(err, stderr)
+				}
 				return nil
 			}
 			out.Send(l)
 		}
 	}
+}
+
+// withTimeout applies the timeout configured for this filter, if any
+func (ecf *ExternalCmd) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ecf.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, ecf.timeout)
+}
+
+// timeoutError returns an error if ctx was canceled because the
+// command timed out. Cancellations of the parent context (e.g. because
+// the query changed) are not errors
+func (ecf *ExternalCmd) timeoutError(parent, ctx context.Context) error {
+	if parent.Err() == nil && ctx.Err() == context.DeadlineExceeded {
+		return errors.Errorf(`command timed out after %s`, ecf.timeout)
+	}
 	return nil
 }
+
+// isNoMatch returns true if the command exited like grep does when
+// none of the lines match: with status 1, and without any message
+func isNoMatch(err error, stderr *stderrBuffer) bool {
+	exitErr, ok := err.(*exec.ExitError)
+	return ok && exitErr.ExitCode() == 1 && stderr.LastLine() == ""
+}
+
+// commandError describes the failure of a command, including the last
+// line that the command printed to stderr
+func commandError(err error, stderr *stderrBuffer) error {
+	if msg := stderr.LastLine(); msg != "" {
+		return errors.Errorf(`command failed (%s): %s`, err, msg)
+	}
+	return errors.Wrap(err, `command failed`)
+}
+
+func (b *stderrBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	n := len(p)
+	b.buf = append(b.buf, p...)
+	if l := len(b.buf); l > stderrBufferSize {
+		b.buf = append(b.buf[:0], b.buf[l-stderrBufferSize:]...)
+	}
+	return n, nil
+}
+
+// LastLine returns the last non-empty line written to the buffer
+func (b *stderrBuffer) LastLine() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	lines := strings.Split(strings.TrimSpace(string(b.buf)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
@@ -1,6 +1,10 @@
 package filter
 
-import "context"
+import (
+	"context"
+	"sort"
+	"strings"
+)
 
 // newContext initializes the context so that it is suitable
 // to be passed to `Run()`
@@ -58,3 +62,57 @@ func mergeMatches(a []int, b []int) []int {
 	}
 	return ret
 }
+
+// dedupeMatches sorts the given matches, and merges the regions that
+// overlap. For example, if we matched the same region twice, we don't
+// want that to be drawn
+func dedupeMatches(matches [][]int) [][]int {
+	sort.Sort(byMatchStart(matches))
+
+	deduped := make([][]int, 0, len(matches))
+	for i, m := range matches {
+		// Always push the first one
+		if i == 0 {
+			deduped = append(deduped, m)
+			continue
+		}
+
+		prev := deduped[len(deduped)-1]
+		switch {
+		case matchContains(prev, m):
+			// If the previous match contains this one, then
+			// don't do anything
+			continue
+		case matchOverlaps(prev, m):
+			// If the previous match overlaps with this one,
+			// merge the results and make it a bigger one
+			deduped[len(deduped)-1] = mergeMatches(prev, m)
+		default:
+			deduped = append(deduped, m)
+		}
+	}
+	return deduped
+}
+
+// termsNarrow returns true if each of `prevTerms` is also in `terms`.
+// If `partial` is true, it is enough for each of `prevTerms` to be
+// a substring of one of `terms`. Empty terms match anything
+func termsNarrow(prevTerms, terms []string, partial bool) bool {
+	for _, p := range prevTerms {
+		if p == "" {
+			continue
+		}
+
+		var found bool
+		for _, t := range terms {
+			if t == p || partial && strings.Contains(t, p) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
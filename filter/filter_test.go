@@ -1,8 +1,12 @@
 package filter
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -70,3 +74,553 @@ func TestFuzzy(t *testing.T) {
 		})
 	}
 }
+
+func TestScoredFuzzy(t *testing.T) {
+	t.Run("indices reflect the best alignment", func(t *testing.T) {
+		testValues := []struct {
+			input    string
+			query    string
+			expected [][]int
+		}{
+			{"config.go", "cfg", [][]int{{0, 1}, {3, 4}, {7, 8}}},
+			{"abc abcd", "abcd", [][]int{{4, 8}}},
+			{"foo/bar/baz.go", "bz", [][]int{{8, 9}, {10, 11}}},
+			{"FooBarBaz", "fbb", [][]int{{0, 1}, {3, 4}, {6, 7}}},
+			{"日本語は難しいです", "難い", [][]int{{12, 15}, {18, 21}}},
+		}
+
+		for _, v := range testValues {
+			score, matches, ok := scoreFuzzy(v.input, []rune(v.query), false)
+			if !assert.True(t, ok, "%q should match %q", v.query, v.input) {
+				return
+			}
+			t.Logf("%q against %q: score = %d", v.query, v.input, score)
+			if !assert.Equal(t, v.expected, matches, "matches for %q against %q", v.query, v.input) {
+				return
+			}
+		}
+	})
+	t.Run("non-matching lines", func(t *testing.T) {
+		_, _, ok := scoreFuzzy("config.go", []rune("gfc"), false)
+		if !assert.False(t, ok, "out-of-order query should not match") {
+			return
+		}
+		_, _, ok = scoreFuzzy("config.go", []rune("CFG"), true)
+		if !assert.False(t, ok, "case sensitive query should not match") {
+			return
+		}
+	})
+	t.Run("better matches get higher scores", func(t *testing.T) {
+		testValues := []struct {
+			better string
+			worse  string
+			query  string
+		}{
+			{"config.go", "src/c/foo/grammar.go", "cfg"},
+			{"src/filter/fuzzy.go", "src/foo/util/zazzy.go", "fuzzy"},
+			{"FooBar", "foobar", "fb"},
+			{"foo_bar", "fooxbar", "fb"},
+		}
+
+		for _, v := range testValues {
+			q := []rune(v.query)
+			better, _, ok := scoreFuzzy(v.better, q, false)
+			if !assert.True(t, ok, "%q should match %q", v.query, v.better) {
+				return
+			}
+			worse, _, ok := scoreFuzzy(v.worse, q, false)
+			if !assert.True(t, ok, "%q should match %q", v.query, v.worse) {
+				return
+			}
+			if !assert.True(t, better > worse, "%q: expected %q (%d) to score higher than %q (%d)", v.query, v.better, better, v.worse, worse) {
+				return
+			}
+		}
+	})
+	t.Run("Apply emits scored lines", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		f := NewScoredFuzzy()
+		ctx = f.NewContext(ctx, "cfg")
+		lines := []line.Line{
+			line.NewRaw(0, "src/c/foo/grammar.go", false),
+			line.NewRaw(1, "README.md", false),
+			line.NewRaw(2, "config.go", false),
+		}
+
+		ch := make(chan interface{}, len(lines))
+		if !assert.NoError(t, f.Apply(ctx, lines, pipeline.ChanOutput(ch)), "f.Apply should succeed") {
+			return
+		}
+		close(ch)
+
+		var got []line.Line
+		for v := range ch {
+			got = append(got, v.(line.Line))
+		}
+		if !assert.Len(t, got, 2, "two lines should match") {
+			return
+		}
+		if !assert.True(t, got[1].(line.Scorer).Score() > got[0].(line.Scorer).Score(), "config.go should have the higher score") {
+			return
+		}
+	})
+}
+
+func TestExtended(t *testing.T) {
+	testValues := []struct {
+		input    string
+		query    string
+		selected bool
+		expected [][]int
+	}{
+		{"src/peco/filter.go", "^src .go$", true, [][]int{{0, 3}, {15, 18}}},
+		{"src/peco/filter_test.go", "^src .go$ !_test", false, nil},
+		{"src/peco/filter.go", "^src .go$ !_test", true, [][]int{{0, 3}, {15, 18}}},
+		{"lib/peco/filter.go", "^src", false, nil},
+		{"src/peco/filter.go", "^peco", false, nil},
+		{"src/peco/filter.go", "'peco", true, [][]int{{4, 8}}},
+		{"src/peco/filter.go", "'PECO", false, nil},
+		{"src/peco/filter.go", "'Handler | Router", false, nil},
+		{"src/http/router.go", "'Handler | router", true, [][]int{{9, 15}}},
+		{"src/http/handler.go", "'handler | router .go$", true, [][]int{{9, 19}}},
+		{"peco", "^peco$", true, [][]int{{0, 4}}},
+		{"peco peco", "^peco$", false, nil},
+		{"FooBar", "fb", true, [][]int{{0, 1}, {3, 4}}},
+		{"FooBar", "!^foo", false, nil},
+		{"FooBar", "!^Bar", true, [][]int{}},
+		{"a ^ b", "^", true, [][]int{{2, 3}}},
+		{"", "^$", true, [][]int{{0, 0}}},
+		{"$", "^$", false, nil},
+		{"foo", "!^$", true, [][]int{}},
+		{"", "!^$", false, nil},
+		{"foo", "foo |", false, nil},
+		{"foo | bar", "foo |", true, [][]int{{0, 3}, {4, 5}}},
+		{"bar", "| bar", false, nil},
+		{"a | b", "a | |", true, [][]int{{0, 1}, {2, 3}}},
+		{"b", "a | | b", false, nil},
+	}
+
+	f := NewExtended()
+	for i, v := range testValues {
+		t.Run(fmt.Sprintf(`"%s" against "%s", expect "%t"`, v.input, v.query, v.selected), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(f.NewContext(context.Background(), v.query), 10*time.Second)
+			defer cancel()
+
+			ch := make(chan interface{}, 1)
+			l := line.NewRaw(uint64(i), v.input, false)
+			if !assert.NoError(t, f.Apply(ctx, []line.Line{l}, pipeline.ChanOutput(ch)), `f.Apply should succeed`) {
+				return
+			}
+			close(ch)
+
+			got, ok := <-ch
+			if !assert.Equal(t, v.selected, ok, "line selection should match") {
+				return
+			}
+			if !ok {
+				return
+			}
+			if !assert.Equal(t, v.expected, got.(indexer).Indices(), "indices should match") {
+				return
+			}
+		})
+	}
+}
+
+// TestCoprocessHelper is not a real test. It is invoked as the custom
+// filter command by TestExternalCmdPersistent. It matches lines that
+// contain the query, and never responds to the query "block". After
+// the query "stall", it stops reading its input
+func TestCoprocessHelper(t *testing.T) {
+	if os.Getenv("PECO_TEST_COPROCESS") != "1" {
+		return
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var msg coprocessMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			os.Exit(1)
+		}
+		if msg.Type != "query" || msg.Query == "block" {
+			continue
+		}
+		if msg.Query == "stall" {
+			time.Sleep(time.Hour)
+		}
+		if msg.Query == "crash" {
+			fmt.Fprintln(os.Stderr, "crashed!")
+			os.Exit(3)
+		}
+
+		for _, l := range msg.Lines {
+			if i := strings.Index(l.Text, msg.Query); i >= 0 {
+				enc.Encode(coprocessResponse{Request: msg.Request, ID: l.ID, Ranges: [][]int{{i, i + len(msg.Query)}}})
+			}
+		}
+		enc.Encode(coprocessResponse{Request: msg.Request, Done: true})
+	}
+	os.Exit(0)
+}
+
+func TestExternalCmdPersistent(t *testing.T) {
+	os.Setenv("PECO_TEST_COPROCESS", "1")
+	defer os.Unsetenv("PECO_TEST_COPROCESS")
+
+	f := NewExternalCmd("Coprocess", os.Args[0], []string{"-test.run=^TestCoprocessHelper$"}, 0, nil, false, true, false, 0)
+	defer f.Close()
+
+	lines := []line.Line{
+		line.NewRaw(1, "peco", false),
+		line.NewRaw(2, "percol", false),
+		line.NewRaw(3, "fzf", false),
+	}
+
+	apply := func(query string, timeout time.Duration) ([]line.Line, error) {
+		ctx, cancel := context.WithTimeout(f.NewContext(context.Background(), query), timeout)
+		defer cancel()
+
+		ch := make(chan interface{}, len(lines))
+		err := f.Apply(ctx, lines, pipeline.ChanOutput(ch))
+		close(ch)
+
+		var got []line.Line
+		for v := range ch {
+			got = append(got, v.(line.Line))
+		}
+		return got, err
+	}
+
+	got, err := apply("pe", 10*time.Second)
+	if !assert.NoError(t, err, "f.Apply should succeed") {
+		return
+	}
+	if !assert.Len(t, got, 2, "two lines should match") {
+		return
+	}
+	assert.Equal(t, uint64(1), got[0].ID(), "matched lines should be the original lines")
+	assert.Equal(t, [][]int{{0, 2}}, got[0].(indexer).Indices(), "ranges from the command should be used")
+	cp := f.coproc
+
+	// The command never responds to this query, so this should
+	// only return because the context gets canceled
+	got, err = apply("block", 500*time.Millisecond)
+	if !assert.NoError(t, err, "f.Apply should succeed") {
+		return
+	}
+	assert.Empty(t, got, "no lines should match")
+
+	got, err = apply("fzf", 10*time.Second)
+	if !assert.NoError(t, err, "f.Apply should succeed") {
+		return
+	}
+	if !assert.Len(t, got, 1, "one line should match") {
+		return
+	}
+	assert.Equal(t, uint64(3), got[0].ID(), "matched lines should be the original lines")
+	assert.True(t, cp == f.coproc, "the command should only be started once")
+
+	_, err = apply("crash", 10*time.Second)
+	if assert.Error(t, err, "f.Apply should fail when the command crashes") {
+		assert.Contains(t, err.Error(), "crashed!", "error should include stderr")
+	}
+
+	// The command should be restarted
+	got, err = apply("pe", 10*time.Second)
+	if !assert.NoError(t, err, "f.Apply should succeed") {
+		return
+	}
+	assert.Len(t, got, 2, "two lines should match")
+	cp = f.coproc
+
+	if !assert.NoError(t, f.Close(), "f.Close should succeed") {
+		return
+	}
+	assert.True(t, cp.Exited(), "the command should have exited")
+}
+
+func TestExternalCmdPersistentStalled(t *testing.T) {
+	os.Setenv("PECO_TEST_COPROCESS", "1")
+	defer os.Unsetenv("PECO_TEST_COPROCESS")
+
+	f := NewExternalCmd("Coprocess", os.Args[0], []string{"-test.run=^TestCoprocessHelper$"}, 0, nil, false, true, false, 500*time.Millisecond)
+	defer f.Close()
+
+	apply := func(query string, lines []line.Line) ([]line.Line, error) {
+		ctx, cancel := context.WithTimeout(f.NewContext(context.Background(), query), 10*time.Second)
+		defer cancel()
+
+		ch := make(chan interface{}, len(lines))
+		err := f.Apply(ctx, lines, pipeline.ChanOutput(ch))
+		close(ch)
+
+		var got []line.Line
+		for v := range ch {
+			got = append(got, v.(line.Line))
+		}
+		return got, err
+	}
+
+	_, err := apply("stall", []line.Line{line.NewRaw(1, "peco", false)})
+	assert.Error(t, err, "f.Apply should time out")
+
+	// The command no longer reads its input, so this batch does not
+	// fit in the pipe. Sending it must give up once the timeout expires
+	var lines []line.Line
+	for i := 0; i < 5000; i++ {
+		lines = append(lines, line.NewRaw(uint64(i), strings.Repeat("x", 100), false))
+	}
+	start := time.Now()
+	_, err = apply("pe", lines)
+	if assert.Error(t, err, "f.Apply should time out") {
+		assert.Contains(t, err.Error(), "timed out", "error should be a timeout")
+	}
+	assert.True(t, time.Since(start) < 5*time.Second, "f.Apply should not block on writing")
+
+	// The command should be restarted
+	got, err := apply("pe", []line.Line{line.NewRaw(1, "peco", false), line.NewRaw(2, "fzf", false)})
+	if assert.NoError(t, err, "f.Apply should succeed") {
+		assert.Len(t, got, 1, "one line should match")
+	}
+}
+
+// TestExternalCmdHelper is not a real test. It is invoked as the custom
+// filter command by TestExternalCmd. It prints the lines that contain
+// the query, which is passed as the last argument, and like grep, exits
+// with status 1 if none of them do. The queries "fail" and "hang" can be
+// used to simulate broken commands
+func TestExternalCmdHelper(t *testing.T) {
+	if os.Getenv("PECO_TEST_EXTERNAL") != "1" {
+		return
+	}
+
+	query := os.Args[len(os.Args)-1]
+	switch query {
+	case "fail":
+		fmt.Fprintln(os.Stderr, "something went wrong")
+		os.Exit(2)
+	case "hang":
+		time.Sleep(time.Minute)
+	}
+
+	matched := false
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if strings.Contains(scanner.Text(), query) {
+			fmt.Println(scanner.Text())
+			matched = true
+		}
+	}
+	if !matched {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func TestExternalCmd(t *testing.T) {
+	os.Setenv("PECO_TEST_EXTERNAL", "1")
+	defer os.Unsetenv("PECO_TEST_EXTERNAL")
+
+	lines := []line.Line{
+		line.NewRaw(1, "foo\000first", true),
+		line.NewRaw(2, "bar\000second", true),
+		line.NewRaw(3, "foo\000third", true),
+	}
+
+	for _, lineID := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lineID=%t", lineID), func(t *testing.T) {
+			f := NewExternalCmd("External", os.Args[0], []string{"-test.run=^TestExternalCmdHelper$", "--", "$QUERY"}, 0, nil, true, false, lineID, 0)
+
+			ctx, cancel := context.WithTimeout(f.NewContext(context.Background(), "foo"), 10*time.Second)
+			defer cancel()
+
+			ch := make(chan interface{}, len(lines))
+			if !assert.NoError(t, f.Apply(ctx, lines, pipeline.ChanOutput(ch)), "f.Apply should succeed") {
+				return
+			}
+			close(ch)
+
+			var got []line.Line
+			for v := range ch {
+				got = append(got, v.(line.Line))
+			}
+			if !assert.Len(t, got, 2, "two lines should match") {
+				return
+			}
+			assert.Equal(t, uint64(1), got[0].ID(), "matched lines should be the original lines")
+			assert.Equal(t, "first", got[0].Output(), "output should come from the original line")
+			assert.Equal(t, uint64(3), got[1].ID(), "duplicate lines should be resolved in order")
+			assert.Equal(t, "third", got[1].Output(), "output should come from the original line")
+		})
+	}
+}
+
+func TestExternalCmdErrors(t *testing.T) {
+	os.Setenv("PECO_TEST_EXTERNAL", "1")
+	defer os.Unsetenv("PECO_TEST_EXTERNAL")
+
+	lines := []line.Line{line.NewRaw(1, "foo", false)}
+	args := []string{"-test.run=^TestExternalCmdHelper$", "--", "$QUERY"}
+
+	testValues := []struct {
+		name     string
+		cmd      string
+		query    string
+		expected string
+	}{
+		{"exit status", os.Args[0], "fail", "exit status 2"},
+		{"stderr", os.Args[0], "fail", "something went wrong"},
+		{"timeout", os.Args[0], "hang", "timed out"},
+		{"missing command", "peco-no-such-command", "foo", "failed to start command"},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			f := NewExternalCmd("External", v.cmd, args, 0, nil, false, false, false, 500*time.Millisecond)
+
+			ctx, cancel := context.WithTimeout(f.NewContext(context.Background(), v.query), 10*time.Second)
+			defer cancel()
+
+			ch := make(chan interface{}, len(lines))
+			err := f.Apply(ctx, lines, pipeline.ChanOutput(ch))
+			if !assert.Error(t, err, "f.Apply should fail") {
+				return
+			}
+			assert.Contains(t, err.Error(), v.expected, "error should be descriptive")
+		})
+	}
+
+	t.Run("no matches", func(t *testing.T) {
+		f := NewExternalCmd("External", os.Args[0], args, 0, nil, false, false, false, 0)
+
+		ctx, cancel := context.WithTimeout(f.NewContext(context.Background(), "zzz"), 10*time.Second)
+		defer cancel()
+
+		ch := make(chan interface{}, len(lines))
+		assert.NoError(t, f.Apply(ctx, lines, pipeline.ChanOutput(ch)), "exit status 1 without a message should mean that nothing matched")
+		assert.Len(t, ch, 0, "no lines should match")
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		f := NewExternalCmd("External", os.Args[0], args, 0, nil, false, false, false, 0)
+
+		ctx, cancel := context.WithTimeout(f.NewContext(context.Background(), "hang"), 500*time.Millisecond)
+		defer cancel()
+
+		ch := make(chan interface{}, len(lines))
+		assert.NoError(t, f.Apply(ctx, lines, pipeline.ChanOutput(ch)), "canceling the query should not be an error")
+	})
+}
+
+func TestNarrows(t *testing.T) {
+	testValues := []struct {
+		filter   Narrower
+		prev     string
+		query    string
+		expected bool
+	}{
+		{NewIgnoreCase(), "foo", "foob", true},
+		{NewIgnoreCase(), "foo", "foo bar", true},
+		{NewIgnoreCase(), "foo bar", "bar foo", true},
+		{NewIgnoreCase(), "foo bar", "xfoox bar", true},
+		{NewIgnoreCase(), "foo", "fo", false},
+		{NewIgnoreCase(), "foo bar", "foo", false},
+		{NewSmartCase(), "foo", "fooB", true},
+		{NewSmartCase(), "foo", "Foo", false},
+		{NewRegexp(), "foo", "foo bar", true},
+		{NewRegexp(), "a", "a?", false},
+		{NewRegexp(), "a", "a|b", false},
+		{NewFuzzy(), "fb", "fbr", true},
+		{NewFuzzy(), "fbr", "fb", false},
+		{NewFuzzy(), "fb", "xfb", false},
+		{NewScoredFuzzy(), "cf", "cfg", true},
+		{NewLiteralIgnoreCase(), "foo", "foob bar", true},
+		{NewLiteralIgnoreCase(), "foo bar", "foo", false},
+	}
+
+	for _, v := range testValues {
+		assert.Equal(t, v.expected, v.filter.Narrows(v.prev, v.query), "%s: %q -> %q", v.filter.(Filter).String(), v.prev, v.query)
+	}
+}
+
+func TestLiteral(t *testing.T) {
+	testValues := []struct {
+		filter   *Literal
+		input    string
+		query    string
+		selected bool
+		expected [][]int
+	}{
+		{NewLiteralIgnoreCase(), "this is a test", "test", true, [][]int{{10, 14}}},
+		{NewLiteralIgnoreCase(), "this is a test", "TEST this", true, [][]int{{0, 4}, {10, 14}}},
+		{NewLiteralIgnoreCase(), "this is a test", "test that", false, nil},
+		{NewLiteralIgnoreCase(), "test test", "test", true, [][]int{{0, 4}, {5, 9}}},
+		{NewLiteralIgnoreCase(), "ababab", "aba bab", true, [][]int{{0, 6}}},
+		{NewLiteralIgnoreCase(), "she sells", "he she sel", true, [][]int{{0, 3}, {4, 7}}},
+		{NewLiteralIgnoreCase(), "日本語は難しいです", "難しい 日本", true, [][]int{{0, 6}, {12, 21}}},
+		{NewLiteralIgnoreCase(), "Kſ", "ks", true, [][]int{{0, 5}}}, // Kelvin sign, long s
+		{NewLiteralIgnoreCase(), "Straße", "STRASSE", false, nil},
+		{NewLiteralCaseSensitive(), "this is a Test", "Test", true, [][]int{{10, 14}}},
+		{NewLiteralCaseSensitive(), "this is a Test", "test", false, nil},
+	}
+
+	for i, v := range testValues {
+		t.Run(fmt.Sprintf(`%s: "%s" against "%s", expect "%t"`, v.filter, v.input, v.query, v.selected), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(v.filter.NewContext(context.Background(), v.query), 10*time.Second)
+			defer cancel()
+
+			ch := make(chan interface{}, 1)
+			l := line.NewRaw(uint64(i), v.input, false)
+			if !assert.NoError(t, v.filter.Apply(ctx, []line.Line{l}, pipeline.ChanOutput(ch)), `f.Apply should succeed`) {
+				return
+			}
+			close(ch)
+
+			got, ok := <-ch
+			if !assert.Equal(t, v.selected, ok, "line selection should match") {
+				return
+			}
+			if !ok {
+				return
+			}
+			assert.Equal(t, v.expected, got.(indexer).Indices(), "indices should match")
+		})
+	}
+
+	t.Run("Same results as IgnoreCase", func(t *testing.T) {
+		inputs := []string{
+			"src/peco/filter.go",
+			"src/peco/filter/literal.go",
+			"lib/FILTER/Literal_test.go",
+			"README.md",
+			"literally literal literals",
+		}
+		var lines []line.Line
+		for i, s := range inputs {
+			lines = append(lines, line.NewRaw(uint64(i), s, false))
+		}
+
+		collect := func(f Filter, query string) map[uint64][][]int {
+			ctx, cancel := context.WithTimeout(f.NewContext(context.Background(), query), 10*time.Second)
+			defer cancel()
+
+			ch := make(chan interface{}, len(lines))
+			f.Apply(ctx, lines, pipeline.ChanOutput(ch))
+			close(ch)
+
+			ret := make(map[uint64][][]int)
+			for v := range ch {
+				ret[v.(line.Line).ID()] = v.(indexer).Indices()
+			}
+			return ret
+		}
+
+		for _, query := range []string{"filter", "lit go", "LITERAL", "peco filter .go", "s", "nothing"} {
+			assert.Equal(t, collect(NewIgnoreCase(), query), collect(NewLiteralIgnoreCase(), query), "results for %q should match", query)
+		}
+	})
+}
@@ -29,6 +29,18 @@ func (ff Fuzzy) String() string {
 	return "Fuzzy"
 }
 
+// ConcurrentApply returns true, as this filter keeps no state between
+// calls to Apply
+func (ff Fuzzy) ConcurrentApply() bool {
+	return true
+}
+
+// Narrows returns true if `query` is an extension of `prev`, as
+// any line that contains `query` as a subsequence also contains `prev`
+func (ff Fuzzy) Narrows(prev, query string) bool {
+	return strings.HasPrefix(query, prev)
+}
+
 func (ff *Fuzzy) Apply(ctx context.Context, lines []line.Line, out pipeline.ChanOutput) error {
 	originalQuery := ctx.Value(queryKey).(string)
 	hasUpper := util.ContainsUpper(originalQuery)
@@ -37,7 +49,7 @@ OUTER:
 	for _, l := range lines {
 		base := 0
 		matches := [][]int{}
-		txt := l.DisplayString()
+		txt := l.MatchString()
 		query := originalQuery
 		for len(query) > 0 {
 			r, n := utf8.DecodeRuneInString(query)
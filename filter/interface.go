@@ -2,10 +2,14 @@ package filter
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"io"
+	"os/exec"
 	"regexp"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/peco/peco/line"
 	"github.com/peco/peco/pipeline"
@@ -50,6 +54,15 @@ type regexpQuery struct {
 type Fuzzy struct {
 }
 
+type ScoredFuzzy struct {
+}
+
+type Extended struct {
+	mutex sync.Mutex
+	query string
+	terms [][]extendedTerm
+}
+
 type Regexp struct {
 	factory   *regexpQueryFactory
 	flags     regexpFlags
@@ -63,11 +76,113 @@ type Regexp struct {
 type ExternalCmd struct {
 	args            []string
 	cmd             string
+	coproc          *coprocess // only used in persistent mode
+	coprocMutex     sync.Mutex
 	enableSep       bool
 	idgen           line.IDGenerator
+	lineID          bool
 	outCh           pipeline.ChanOutput
 	name            string
+	persistent      bool
 	thresholdBufsiz int
+	timeout         time.Duration
+}
+
+// stderrBufferSize is the number of bytes of stderr output that we
+// keep from external commands
+const stderrBufferSize = 4096
+
+// stderrBuffer keeps the last stderrBufferSize bytes that a command
+// wrote to its stderr, so that they can be reported when it fails
+type stderrBuffer struct {
+	buf   []byte
+	mutex sync.Mutex
+}
+
+// externalLineMap maps the output of an external command back to
+// the lines that were sent to it
+type externalLineMap struct {
+	byID   map[uint64]line.Line
+	byText map[string][]line.Line
+	lineID bool
+}
+
+// Literal is a filter that matches multiple literal terms using a
+// single Aho-Corasick automaton, so that each line is scanned only once
+type Literal struct {
+	caseFold bool
+	matcher  *acMatcher
+	mutex    sync.Mutex
+	name     string
+	query    string
+}
+
+// acMatcher is an Aho-Corasick automaton that matches runes. Transitions
+// for ASCII characters are pre-computed for all nodes, so that the
+// common case only requires a single table lookup per byte
+type acMatcher struct {
+	caseFold bool
+	maxLen   int // length of the longest term, in runes
+	nodes    []acNode
+	nterms   int
+}
+
+type acNode struct {
+	ascii    [utf8.RuneSelf]int32
+	children map[rune]int32 // non-ASCII transitions
+	fail     int32
+	out      []acOutput // terms that end at this node
+}
+
+// acOutput describes a term that is matched upon reaching a node
+type acOutput struct {
+	term   int
+	length int // in runes
+}
+
+// coprocess is a long-lived external command used by ExternalCmd in
+// persistent mode. Requests and responses are multiplexed over the
+// command's stdin/stdout, and are matched using request IDs
+type coprocess struct {
+	cmd        *exec.Cmd
+	stderr     *stderrBuffer
+	done       chan struct{} // closed when the command exits
+	enc        *json.Encoder
+	mutex      sync.Mutex
+	nextID     uint64
+	pending    map[uint64]*coprocessRequest
+	stdin      io.WriteCloser
+	writeMutex sync.Mutex
+}
+
+// coprocessRequest tracks a request that has been sent to a coprocess,
+// but has not been completed yet
+type coprocessRequest struct {
+	canceled chan struct{}
+	ch       chan coprocessResponse
+	err      error // set before ch is closed, if the command died
+}
+
+// coprocessMessage is sent from peco to the coprocess
+type coprocessMessage struct {
+	Type    string          `json:"type"` // "query" or "cancel"
+	Request uint64          `json:"request"`
+	Query   string          `json:"query,omitempty"`
+	Lines   []coprocessLine `json:"lines,omitempty"`
+}
+
+type coprocessLine struct {
+	ID   uint64 `json:"id"`
+	Text string `json:"text"`
+}
+
+// coprocessResponse is sent from the coprocess to peco. Each response
+// either reports a matching line, or marks the end of a request
+type coprocessResponse struct {
+	Request uint64  `json:"request"`
+	ID      uint64  `json:"id"`
+	Ranges  [][]int `json:"ranges"`
+	Done    bool    `json:"done"`
 }
 
 type Filter interface {
@@ -76,3 +191,27 @@ type Filter interface {
 	NewContext(context.Context, string) context.Context
 	String() string
 }
+
+// Ranker is an optional interface that filters may implement if
+// their results should be ordered by score (see line.Scorer) rather
+// than by the order in which the lines were read
+type Ranker interface {
+	RankResults() bool
+}
+
+// ConcurrentApplier is an optional interface that filters may implement
+// if Apply may be called for multiple batches of lines at the same time.
+// Such filters must not rely on the order in which batches are applied
+type ConcurrentApplier interface {
+	ConcurrentApply() bool
+}
+
+// Narrower is an optional interface that filters may implement if they
+// can tell that the results for a query are a subset of the results
+// for a previous query. This allows peco to filter the previous results
+// instead of the whole input when the user extends the query
+type Narrower interface {
+	// Narrows returns true if every line that matches `query` is
+	// guaranteed to also match `prev`
+	Narrows(prev, query string) bool
+}
@@ -0,0 +1,289 @@
+package filter
+
+import (
+	"context"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/peco/peco/line"
+	"github.com/peco/peco/pipeline"
+)
+
+// NewLiteralIgnoreCase creates a filter that matches lines containing
+// all of the white space separated terms in the query, ignoring case.
+// It matches the same lines as IgnoreCase, but scans each line only
+// once regardless of the number of terms
+func NewLiteralIgnoreCase() *Literal {
+	return &Literal{
+		caseFold: true,
+		name:     "LiteralIgnoreCase",
+	}
+}
+
+// NewLiteralCaseSensitive creates a filter that matches lines
+// containing all of the white space separated terms in the query.
+// It matches the same lines as CaseSensitive, but scans each line
+// only once regardless of the number of terms
+func NewLiteralCaseSensitive() *Literal {
+	return &Literal{
+		name: "LiteralCaseSensitive",
+	}
+}
+
+func (lf *Literal) BufSize() int {
+	return 0
+}
+
+func (lf *Literal) NewContext(ctx context.Context, query string) context.Context {
+	return newContext(ctx, query)
+}
+
+func (lf *Literal) String() string {
+	return lf.name
+}
+
+// ConcurrentApply returns true, as the automaton is shared safely between
+// calls to Apply
+func (lf *Literal) ConcurrentApply() bool {
+	return true
+}
+
+// Narrows returns true if each term in `prev` is a part of a term
+// in `query`
+func (lf *Literal) Narrows(prev, query string) bool {
+	return termsNarrow(strings.Fields(prev), strings.Fields(query), true)
+}
+
+// compile builds the automaton for the query. The automaton for the
+// last query is cached, as Apply is called many times for the same query
+func (lf *Literal) compile(query string) *acMatcher {
+	lf.mutex.Lock()
+	defer lf.mutex.Unlock()
+
+	if lf.matcher != nil && lf.query == query {
+		return lf.matcher
+	}
+
+	lf.query = query
+	lf.matcher = newACMatcher(strings.Fields(query), lf.caseFold)
+	return lf.matcher
+}
+
+func (lf *Literal) Apply(ctx context.Context, lines []line.Line, out pipeline.ChanOutput) error {
+	m := lf.compile(ctx.Value(queryKey).(string))
+
+	// scratch space, reused for every line
+	found := make([]bool, m.nterms)
+	ring := make([]int, m.maxLen)
+
+	for _, l := range lines {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		matches, ok := m.match(l.MatchString(), found, ring)
+		if !ok {
+			continue
+		}
+		if len(matches) > 0 {
+			matches = dedupeMatches(matches)
+		}
+		out.Send(line.NewMatched(l, matches))
+	}
+	return nil
+}
+
+// foldRune maps all runes that are equivalent under simple case
+// folding to the same rune
+func foldRune(r rune) rune {
+	if r < utf8.RuneSelf {
+		if 'a' <= r && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		return r
+	}
+
+	// Use the smallest rune in the orbit, which is the upper case
+	// letter for ASCII, consistent with the above
+	min := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < min {
+			min = f
+		}
+	}
+	return min
+}
+
+// newACMatcher builds an automaton that matches all of the terms.
+// Duplicate terms are ignored.
+//
+// This does not use the Aho-Corasick matcher in internal/keyseq, which
+// works on key sequences: it reports matches from a goroutine over a
+// channel, and looks up each key in a ternary trie, which is too slow
+// to run against every line
+func newACMatcher(terms []string, caseFold bool) *acMatcher {
+	m := &acMatcher{
+		caseFold: caseFold,
+		nodes:    []acNode{{}},
+	}
+
+	seen := make(map[string]struct{})
+	for _, term := range terms {
+		runes := []rune(term)
+		if caseFold {
+			for i, r := range runes {
+				runes[i] = foldRune(r)
+			}
+		}
+		if _, ok := seen[string(runes)]; ok {
+			continue
+		}
+		seen[string(runes)] = struct{}{}
+
+		var n int32
+		for _, r := range runes {
+			next, ok := m.child(n, r)
+			if !ok {
+				m.nodes = append(m.nodes, acNode{})
+				next = int32(len(m.nodes) - 1)
+				m.setChild(n, r, next)
+			}
+			n = next
+		}
+		m.nodes[n].out = append(m.nodes[n].out, acOutput{term: m.nterms, length: len(runes)})
+		m.nterms++
+		if len(runes) > m.maxLen {
+			m.maxLen = len(runes)
+		}
+	}
+
+	// Compute failure links in breadth first order, so that the nodes
+	// that they point to are always complete
+	order := []int32{0}
+	for i := 0; i < len(order); i++ {
+		n := order[i]
+		m.eachChild(n, func(r rune, c int32) {
+			order = append(order, c)
+			if n == 0 {
+				return
+			}
+
+			f := m.nodes[n].fail
+			for {
+				if next, ok := m.child(f, r); ok {
+					m.nodes[c].fail = next
+					break
+				}
+				if f == 0 {
+					break
+				}
+				f = m.nodes[f].fail
+			}
+			m.nodes[c].out = append(m.nodes[c].out, m.nodes[m.nodes[c].fail].out...)
+		})
+	}
+
+	// Now fill in the missing ASCII transitions, so that we never have
+	// to follow failure links for them
+	for _, n := range order[1:] {
+		node := &m.nodes[n]
+		for r, next := range node.ascii {
+			if next == 0 {
+				node.ascii[r] = m.nodes[node.fail].ascii[r]
+			}
+		}
+	}
+	return m
+}
+
+// child returns the child of node n for rune r. This only looks at
+// actual children, and must not be used once the ASCII transitions
+// have been filled in
+func (m *acMatcher) child(n int32, r rune) (int32, bool) {
+	if r < utf8.RuneSelf {
+		next := m.nodes[n].ascii[r]
+		return next, next != 0
+	}
+	next, ok := m.nodes[n].children[r]
+	return next, ok
+}
+
+func (m *acMatcher) setChild(n int32, r rune, next int32) {
+	if r < utf8.RuneSelf {
+		m.nodes[n].ascii[r] = next
+		return
+	}
+	if m.nodes[n].children == nil {
+		m.nodes[n].children = make(map[rune]int32)
+	}
+	m.nodes[n].children[r] = next
+}
+
+func (m *acMatcher) eachChild(n int32, f func(rune, int32)) {
+	for r, next := range m.nodes[n].ascii {
+		if next != 0 {
+			f(rune(r), next)
+		}
+	}
+	for r, next := range m.nodes[n].children {
+		f(r, next)
+	}
+}
+
+// next returns the node to transition to from node n upon reading r
+func (m *acMatcher) next(n int32, r rune) int32 {
+	if r < utf8.RuneSelf {
+		return m.nodes[n].ascii[r]
+	}
+	for {
+		if next, ok := m.nodes[n].children[r]; ok {
+			return next
+		}
+		if n == 0 {
+			return 0
+		}
+		n = m.nodes[n].fail
+	}
+}
+
+// match scans txt once, and returns the byte ranges of every occurrence
+// of every term, along with a boolean indicating if all of the terms
+// were found. `found` and `ring` are scratch buffers, which must have
+// room for m.nterms and m.maxLen elements respectively
+func (m *acMatcher) match(txt string, found []bool, ring []int) ([][]int, bool) {
+	if m.nterms == 0 {
+		return nil, true
+	}
+
+	for i := range found {
+		found[i] = false
+	}
+
+	var matches [][]int
+	var n int32
+	nfound := 0
+	for i, off := 0, 0; off < len(txt); i++ {
+		r, size := utf8.DecodeRuneInString(txt[off:])
+		if m.caseFold {
+			r = foldRune(r)
+		}
+
+		// ring remembers where the last maxLen runes started, so that
+		// we can compute where each match starts
+		ring[i%m.maxLen] = off
+		off += size
+
+		n = m.next(n, r)
+		for _, o := range m.nodes[n].out {
+			if !found[o.term] {
+				found[o.term] = true
+				nfound++
+			}
+			matches = append(matches, []int{ring[(i-o.length+1)%m.maxLen], off})
+		}
+	}
+	return matches, nfound == m.nterms
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"regexp"
-	"sort"
 	"strings"
 	"time"
 
@@ -72,7 +71,7 @@ func NewRegexp() *Regexp {
 	}
 }
 
-func (rf Regexp) BufSize() int {
+func (rf *Regexp) BufSize() int {
 	return 0
 }
 
@@ -113,7 +112,7 @@ func (rf *Regexp) Apply(ctx context.Context, lines []line.Line, out pipeline.Cha
 	}
 
 	for _, l := range lines {
-		v := l.DisplayString()
+		v := l.MatchString()
 		allMatched := true
 		matches := [][]int{}
 	TryRegexps:
@@ -130,43 +129,30 @@ func (rf *Regexp) Apply(ctx context.Context, lines []line.Line, out pipeline.Cha
 			continue
 		}
 
-		sort.Sort(byMatchStart(matches))
-
-		// We need to "dedupe" the results. For example, if we matched the
-		// same region twice, we don't want that to be drawn
-
-		deduped := make([][]int, 0, len(matches))
-
-		for i, m := range matches {
-			// Always push the first one
-			if i == 0 {
-				deduped = append(deduped, m)
-				continue
-			}
-
-			prev := deduped[len(deduped)-1]
-			switch {
-			case matchContains(prev, m):
-				// If the previous match contains this one, then
-				// don't do anything
-				continue
-			case matchOverlaps(prev, m):
-				// If the previous match overlaps with this one,
-				// merge the results and make it a bigger one
-				deduped[len(deduped)-1] = mergeMatches(prev, m)
-			default:
-				deduped = append(deduped, m)
-			}
-		}
-		out.Send(line.NewMatched(l, deduped))
+		out.Send(line.NewMatched(l, dedupeMatches(matches)))
 	}
 	return nil
 }
 
-func (rf Regexp) String() string {
+func (rf *Regexp) String() string {
 	return rf.name
 }
 
+// ConcurrentApply returns true, as compiled queries are shared safely
+// between calls to Apply
+func (rf *Regexp) ConcurrentApply() bool {
+	return true
+}
+
+// Narrows returns true if each term in `prev` is also present in
+// `query`. For literal (non-regexp) filters, it is enough for each
+// term in `prev` to be a part of a term in `query`
+func (rf *Regexp) Narrows(prev, query string) bool {
+	prevTerms := strings.Split(strings.TrimSpace(prev), " ")
+	terms := strings.Split(strings.TrimSpace(query), " ")
+	return termsNarrow(prevTerms, terms, rf.quotemeta)
+}
+
 func NewIgnoreCase() *Regexp {
 	rf := NewRegexp()
 	rf.flags = ignoreCaseFlags
@@ -0,0 +1,380 @@
+package filter
+
+import (
+	"context"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/peco/peco/internal/util"
+	"github.com/peco/peco/line"
+	"github.com/peco/peco/pipeline"
+)
+
+// Scoring parameters for ScoredFuzzy. These are loosely modeled
+// after the scheme used by fzf: each matched character is worth
+// scoreMatch points, gaps between matched characters are penalized,
+// and characters that appear at "interesting" positions (beginning of
+// a word, after a path separator, camelCase humps) get a bonus.
+const (
+	scoreMatch        = 16
+	scoreGapStart     = -3
+	scoreGapExtension = -1
+
+	bonusBoundary          = scoreMatch / 2
+	bonusBoundaryWhite     = bonusBoundary + 2
+	bonusBoundaryDelimiter = bonusBoundary + 1
+	bonusNonWord           = scoreMatch / 2
+	bonusCamel123          = bonusBoundary - 1
+	bonusConsecutive       = -(scoreGapStart + scoreGapExtension)
+	bonusFirstCharFactor   = 2
+
+	// bonusBasename is given to each character matched after the last
+	// path separator, so that "cfg" prefers "config.go" over
+	// "src/c/foo/grammar.go"
+	bonusBasename = scoreMatch / 2
+
+	// maxScoredFuzzyCells limits the size of the scoring matrix.
+	// Lines that would require more cells than this are matched
+	// using the greedy algorithm instead
+	maxScoredFuzzyCells = 1 << 20
+)
+
+type charClass int
+
+const (
+	charWhite charClass = iota
+	charNonWord
+	charDelimiter
+	charLower
+	charUpper
+	charLetter
+	charNumber
+)
+
+func classOf(r rune) charClass {
+	switch {
+	case r >= 'a' && r <= 'z':
+		return charLower
+	case r >= 'A' && r <= 'Z':
+		return charUpper
+	case r >= '0' && r <= '9':
+		return charNumber
+	case r == '/' || r == '\\' || r == ',' || r == ':' || r == ';' || r == '|':
+		return charDelimiter
+	case unicode.IsSpace(r):
+		return charWhite
+	case unicode.IsLower(r):
+		return charLower
+	case unicode.IsUpper(r):
+		return charUpper
+	case unicode.IsNumber(r):
+		return charNumber
+	case unicode.IsLetter(r):
+		return charLetter
+	}
+	return charNonWord
+}
+
+// bonusFor computes the bonus for a character of class `cur` that
+// follows a character of class `prev`
+func bonusFor(prev, cur charClass) int {
+	if cur > charDelimiter {
+		switch prev {
+		case charWhite:
+			return bonusBoundaryWhite
+		case charDelimiter:
+			return bonusBoundaryDelimiter
+		case charNonWord:
+			return bonusBoundary
+		}
+	}
+
+	if prev == charLower && cur == charUpper ||
+		prev != charNumber && cur == charNumber {
+		return bonusCamel123
+	}
+
+	switch cur {
+	case charNonWord, charDelimiter:
+		return bonusNonWord
+	case charWhite:
+		return bonusBoundaryWhite
+	}
+	return 0
+}
+
+// NewScoredFuzzy builds a fuzzy-finder type of filter that, unlike
+// Fuzzy, looks for the best alignment of the query against each line
+// and ranks the results by the score of that alignment.
+// Like Fuzzy, this uses smart case matching.
+func NewScoredFuzzy() *ScoredFuzzy {
+	return &ScoredFuzzy{}
+}
+
+func (sf ScoredFuzzy) BufSize() int {
+	return 0
+}
+
+func (sf *ScoredFuzzy) NewContext(ctx context.Context, query string) context.Context {
+	return newContext(ctx, query)
+}
+
+func (sf ScoredFuzzy) String() string {
+	return "ScoredFuzzy"
+}
+
+// ConcurrentApply returns true, as this filter keeps no state between
+// calls to Apply
+func (sf ScoredFuzzy) ConcurrentApply() bool {
+	return true
+}
+
+// Narrows returns true if `query` is an extension of `prev`, as
+// any line that contains `query` as a subsequence also contains `prev`
+func (sf ScoredFuzzy) Narrows(prev, query string) bool {
+	return strings.HasPrefix(query, prev)
+}
+
+// RankResults returns true, as results from this filter should be
+// ordered by their score
+func (sf ScoredFuzzy) RankResults() bool {
+	return true
+}
+
+func (sf *ScoredFuzzy) Apply(ctx context.Context, lines []line.Line, out pipeline.ChanOutput) error {
+	query := []rune(ctx.Value(queryKey).(string))
+	if len(query) == 0 {
+		return nil
+	}
+	caseSensitive := util.ContainsUpper(string(query))
+	if !caseSensitive {
+		for i, r := range query {
+			query[i] = unicode.ToLower(r)
+		}
+	}
+
+	for _, l := range lines {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		score, matches, ok := scoreFuzzy(l.MatchString(), query, caseSensitive)
+		if !ok {
+			continue
+		}
+		out.Send(line.NewScoredMatched(l, matches, score))
+	}
+	return nil
+}
+
+// scoreFuzzy finds the best scoring alignment of `query` in `txt`.
+// It returns the score, the byte indices of the matched portions of
+// `txt`, and a boolean indicating if `txt` matched at all.
+// If caseSensitive is false, `query` must already be in lower case
+func scoreFuzzy(txt string, query []rune, caseSensitive bool) (int, [][]int, bool) {
+	text := make([]rune, 0, len(txt))
+	offsets := make([]int, 0, len(txt)+1)
+	for i, r := range txt {
+		if r == utf8.RuneError {
+			r = '?'
+		}
+		text = append(text, r)
+		offsets = append(offsets, i)
+	}
+	offsets = append(offsets, len(txt))
+
+	eq := func(t, q rune) bool {
+		if caseSensitive {
+			return t == q
+		}
+		return unicode.ToLower(t) == q
+	}
+
+	// Quickly reject lines that do not contain the query as a
+	// subsequence. While doing so, compute the range of text where
+	// a match can possibly occur, so we can keep the matrix small
+	m := len(query)
+	first := -1
+	qi := 0
+	for ti, r := range text {
+		if eq(r, query[qi]) {
+			if qi == 0 {
+				first = ti
+			}
+			qi++
+			if qi == m {
+				break
+			}
+		}
+	}
+	if qi < m {
+		return 0, nil, false
+	}
+
+	last := -1
+	qi = m - 1
+	for ti := len(text) - 1; ti >= first; ti-- {
+		if eq(text[ti], query[qi]) {
+			if qi == m-1 {
+				last = ti
+			}
+			qi--
+			if qi < 0 {
+				break
+			}
+		}
+	}
+
+	n := last - first + 1
+	if n*m > maxScoredFuzzyCells {
+		return greedyFuzzy(text, offsets, query, eq)
+	}
+
+	// Pre-compute the bonus for each position in range, as well as
+	// the score for matching a character at that position
+	basename := 0
+	for ti := len(text) - 1; ti >= 0; ti-- {
+		if r := text[ti]; r == '/' || r == '\\' {
+			basename = ti + 1
+			break
+		}
+	}
+
+	bonus := make([]int, n)
+	match := make([]int, n)
+	prev := charWhite
+	if first > 0 {
+		prev = classOf(text[first-1])
+	}
+	for j := 0; j < n; j++ {
+		cur := classOf(text[first+j])
+		bonus[j] = bonusFor(prev, cur)
+		match[j] = scoreMatch
+		if first+j >= basename {
+			match[j] += bonusBasename
+		}
+		prev = cur
+	}
+
+	const unset = -1 << 30
+
+	// score[i][j] is the best score for query[:i+1] where query[i] is
+	// matched against text[first+j]. from[i][j] records where query[i-1]
+	// was matched for that alignment, and chain[i][j] records the
+	// bonus for the consecutive run of matches ending at (i, j)
+	score := make([][]int, m)
+	from := make([][]int, m)
+	chain := make([][]int, m)
+	for i := 0; i < m; i++ {
+		score[i] = make([]int, n)
+		from[i] = make([]int, n)
+		chain[i] = make([]int, n)
+		for j := 0; j < n; j++ {
+			score[i][j] = unset
+		}
+	}
+
+	for j := 0; j < n; j++ {
+		if eq(text[first+j], query[0]) {
+			score[0][j] = match[j] + bonus[j]*bonusFirstCharFactor
+			chain[0][j] = bonus[j]
+			from[0][j] = -1
+		}
+	}
+
+	for i := 1; i < m; i++ {
+		// gap holds the best score for query[:i] matched at some
+		// position k <= j-2, including the gap penalty up to j
+		gap, gapFrom := unset, -1
+		for j := 1; j < n; j++ {
+			if j >= 2 {
+				if gap != unset {
+					gap += scoreGapExtension
+				}
+				if s := score[i-1][j-2]; s != unset && s+scoreGapStart > gap {
+					gap, gapFrom = s+scoreGapStart, j-2
+				}
+			}
+
+			if !eq(text[first+j], query[i]) {
+				continue
+			}
+
+			best, bestFrom, bestChain := unset, -1, 0
+			if gap != unset {
+				best, bestFrom, bestChain = gap+match[j]+bonus[j], gapFrom, bonus[j]
+			}
+
+			if s := score[i-1][j-1]; s != unset {
+				b := bonus[j]
+				if c := chain[i-1][j-1]; c > b {
+					b = c
+				}
+				if b < bonusConsecutive {
+					b = bonusConsecutive
+				}
+				if s+match[j]+b >= best {
+					best, bestFrom, bestChain = s+match[j]+b, j-1, b
+				}
+			}
+
+			score[i][j] = best
+			from[i][j] = bestFrom
+			chain[i][j] = bestChain
+		}
+	}
+
+	best, bestAt := unset, -1
+	for j := 0; j < n; j++ {
+		if s := score[m-1][j]; s != unset && s > best {
+			best, bestAt = s, j
+		}
+	}
+	if bestAt < 0 {
+		return 0, nil, false
+	}
+
+	positions := make([]int, m)
+	for i, j := m-1, bestAt; i >= 0; i-- {
+		positions[i] = first + j
+		j = from[i][j]
+	}
+
+	return best, positionsToMatches(positions, offsets), true
+}
+
+// greedyFuzzy is the fallback used when the line is too long to be
+// scored. It matches each rune of the query at the first possible
+// position, and assigns a score based solely on the number of matches
+func greedyFuzzy(text []rune, offsets []int, query []rune, eq func(rune, rune) bool) (int, [][]int, bool) {
+	positions := make([]int, 0, len(query))
+	qi := 0
+	for ti, r := range text {
+		if qi < len(query) && eq(r, query[qi]) {
+			positions = append(positions, ti)
+			qi++
+		}
+	}
+	if qi < len(query) {
+		return 0, nil, false
+	}
+	return len(query) * scoreMatch, positionsToMatches(positions, offsets), true
+}
+
+// positionsToMatches converts a list of (sorted) rune positions into
+// byte ranges, merging adjacent positions into a single range
+func positionsToMatches(positions []int, offsets []int) [][]int {
+	matches := [][]int{}
+	for _, p := range positions {
+		start, end := offsets[p], offsets[p+1]
+		if l := len(matches); l > 0 && matches[l-1][1] == start {
+			matches[l-1][1] = end
+			continue
+		}
+		matches = append(matches, []int{start, end})
+	}
+	return matches
+}
@@ -1,6 +1,8 @@
 package filter
 
 import (
+	"io"
+
 	pdebug "github.com/lestrrat-go/pdebug"
 )
 
@@ -58,3 +60,16 @@ func (fs *Set) Current() Filter {
 	defer fs.mutex.Unlock()
 	return fs.filters[fs.current]
 }
+
+// Close releases resources held by filters, such as external commands
+// running in the background
+func (fs *Set) Close() error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	for _, f := range fs.filters {
+		if c, ok := f.(io.Closer); ok {
+			c.Close()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package peco
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/peco/peco/filter"
+	"github.com/peco/peco/line"
+	"github.com/peco/peco/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestMemoryBuffer(lines ...line.Line) *MemoryBuffer {
+	mb := NewMemoryBuffer()
+	mb.lines = lines
+	return mb
+}
+
+func TestBufferSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Results that were ranked, and wrapped multiple times
+	mb := newTestMemoryBuffer(
+		line.NewMatched(line.NewMatched(line.NewRaw(2, "foo", false), nil), [][]int{{0, 1}}),
+		line.NewMatched(line.NewRaw(0, "bar", false), [][]int{{0, 1}}),
+		line.NewRaw(1, "baz", false),
+	)
+
+	out := make(chan interface{}, 4)
+	newBufferSource(mb).Start(ctx, pipeline.ChanOutput(out))
+
+	for i := 0; i < 3; i++ {
+		v := <-out
+		l, ok := v.(*line.Raw)
+		if !assert.True(t, ok, "lines should be unwrapped, got %T", v) {
+			return
+		}
+		if !assert.Equal(t, uint64(i), l.ID(), "lines should be sent in the order they were read") {
+			return
+		}
+	}
+	v := <-out
+	if !assert.Implements(t, (*error)(nil), v, "end mark should be sent") {
+		return
+	}
+	assert.True(t, pipeline.IsEndMark(v.(error)), "end mark should be sent")
+}
+
+func TestResultCache(t *testing.T) {
+	src := &bufferSource{}
+	f := filter.NewIgnoreCase()
+	rc := &resultCache{}
+
+	foo := newTestMemoryBuffer(line.NewRaw(0, "foo", false), line.NewRaw(1, "foobar", false))
+	foob := newTestMemoryBuffer(line.NewRaw(1, "foobar", false))
+	rc.Add(src, f, "foo", foo)
+	rc.Add(src, f, "foob", foob)
+
+	buf, ok := rc.Get(src, f, "foo")
+	if assert.True(t, ok, "exact match should be found") {
+		assert.True(t, buf == foo, "exact match should return the cached buffer")
+	}
+
+	_, ok = rc.Get(src, filter.NewCaseSensitive(), "foo")
+	assert.False(t, ok, "results for other filters should not be used")
+	_, ok = rc.Get(&bufferSource{}, f, "foo")
+	assert.False(t, ok, "results for other sources should not be used")
+
+	assert.True(t, rc.Narrowest(src, f, "fooba") == foob, "smallest matching result should be used")
+	assert.True(t, rc.Narrowest(src, f, "foo x") == foo, "only results that contain all matches should be used")
+	assert.Nil(t, rc.Narrowest(src, f, "fo"), "wider queries should not be narrowed")
+	assert.Nil(t, rc.Narrowest(src, filter.NewExtended(), "foob"), "filters that can't narrow should not be narrowed")
+
+	for i := 0; i < resultCacheSize; i++ {
+		rc.Add(src, f, fmt.Sprintf("query%d", i), NewMemoryBuffer())
+	}
+	_, ok = rc.Get(src, f, "foo")
+	assert.False(t, ok, "oldest results should be evicted")
+
+	other := &bufferSource{}
+	rc.Add(other, f, "foo", foo)
+	rc.Remove(src)
+	_, ok = rc.Get(src, f, "query7")
+	assert.False(t, ok, "results for removed sources should be dropped")
+	_, ok = rc.Get(other, f, "foo")
+	assert.True(t, ok, "results for other sources should be kept")
+}
+
+// slowFilter passes lines through, taking longer for earlier batches so
+// that batches finish out of order when filtered in parallel
+type slowFilter struct{}
+
+func (slowFilter) BufSize() int { return 0 }
+
+func (slowFilter) NewContext(ctx context.Context, _ string) context.Context { return ctx }
+
+func (slowFilter) String() string { return "Slow" }
+
+func (slowFilter) Apply(ctx context.Context, lines []line.Line, out pipeline.ChanOutput) error {
+	if len(lines) > 0 {
+		time.Sleep(time.Duration(10-lines[0].ID()%10) * time.Millisecond)
+	}
+	for _, l := range lines {
+		out.Send(l)
+	}
+	return nil
+}
+
+func TestParallelFlusher(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	const batches = 20
+	const batchSize = 5
+
+	incoming := make(chan []line.Line)
+	done := make(chan struct{})
+	out := make(chan interface{}, batches*batchSize+1)
+	go parallelFlusher(ctx, slowFilter{}, incoming, done, pipeline.ChanOutput(out), func(error) {}, 4)
+
+	var id uint64
+	for i := 0; i < batches; i++ {
+		buf := make([]line.Line, 0, batchSize)
+		for j := 0; j < batchSize; j++ {
+			buf = append(buf, line.NewRaw(id, fmt.Sprintf("line %d", id), false))
+			id++
+		}
+		incoming <- buf
+	}
+	close(incoming)
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		assert.Fail(t, "flusher should finish")
+		return
+	}
+
+	for i := uint64(0); i < id; i++ {
+		l, ok := (<-out).(line.Line)
+		if !assert.True(t, ok, "line %d should be sent", i) {
+			return
+		}
+		if !assert.Equal(t, i, l.ID(), "lines should be sent in the order they were received") {
+			return
+		}
+	}
+	v := <-out
+	if !assert.Implements(t, (*error)(nil), v, "end mark should be sent") {
+		return
+	}
+	assert.True(t, pipeline.IsEndMark(v.(error)), "end mark should be sent")
+}
@@ -0,0 +1,147 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/lestrrat-go/pdebug"
+	"github.com/peco/peco/filter"
+	"github.com/peco/peco/pipeline"
+	"github.com/pkg/errors"
+)
+
+// exitStatusNoMatch is the exit status used in filter mode when none
+// of the lines matched the query. This is different from the status
+// used for errors, so that scripts can tell them apart
+const exitStatusNoMatch = 2
+
+// runFilterMode filters the input using the current filter, and prints
+// the matching lines without ever touching the screen. This is used to
+// run peco from scripts, where there is no terminal available
+func (p *Peco) runFilterMode(ctx context.Context, query string) (err error) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Peco.runFilterMode (query=%s)", query).BindError(&err)
+		defer g.End()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go p.idgen.Run(ctx)
+	go drainHub(ctx, p.Hub())
+	defer p.filters.Close()
+
+	src, err := p.SetupSource(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to setup input source")
+	}
+	p.mutex.Lock()
+	p.source = src
+	p.mutex.Unlock()
+
+	if p.dynamicSource != "" {
+		// The source command has been given its part of the query
+		_, query = splitDynamicQuery(query)
+	}
+
+	var buf Buffer = src
+	if query != "" {
+		selectedFilter := p.Filters().Current()
+
+		pl := pipeline.New()
+		pl.SetSource(src)
+		fp := newFilterProcessor(selectedFilter, query)
+		pl.Add(fp)
+
+		mb := NewMemoryBuffer()
+		if r, ok := selectedFilter.(filter.Ranker); ok {
+			mb.rankByScore = r.RankResults()
+		}
+		pl.SetDestination(mb)
+
+		if err := pl.Run(selectedFilter.NewContext(ctx, query)); err != nil {
+			return errors.Wrap(err, "failed to run filter")
+		}
+		if err := fp.Err(); err != nil {
+			return errors.Wrapf(err, "filter %s failed", selectedFilter)
+		}
+		buf = mb
+	} else {
+		// Everything matches the empty query
+		<-src.SetupDone()
+	}
+
+	if err := p.printFilterResults(buf); err != nil {
+		return errors.Wrap(err, "failed to print results")
+	}
+
+	if buf.Size() == 0 {
+		return setExitStatus(makeIgnorable(errors.New("no lines matched")), exitStatusNoMatch)
+	}
+	return nil
+}
+
+// printFilterResults prints all of the lines in buf. If requested,
+// each line is followed by a tab and the matched regions of the line,
+// as a JSON array of [start, end) byte offsets into the displayed line
+func (p *Peco) printFilterResults(buf Buffer) error {
+	var out bytes.Buffer
+	if p.printQuery && !p.outputJSON {
+		out.WriteString(p.filterQuery)
+		out.WriteByte('\n')
+	}
+
+	if p.outputFormat != nil || p.outputJSON {
+		lines := buf.linesInRange(0, buf.Size())
+		if _, err := p.formatResults(&out, lines, p.filterQuery); err != nil {
+			return errors.Wrap(err, "failed to format results")
+		}
+		_, err := p.Stdout.Write(out.Bytes())
+		return err
+	}
+
+	for i := 0; i < buf.Size(); i++ {
+		l, err := buf.LineAt(i)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get line %d", i)
+		}
+		out.WriteString(p.outputString(l))
+
+		if p.printIndices {
+			indices := [][]int{}
+			if m, ok := l.(MatchIndexer); ok && m.Indices() != nil {
+				indices = m.Indices()
+			}
+			b, err := json.Marshal(indices)
+			if err != nil {
+				return errors.Wrap(err, "failed to encode indices")
+			}
+			out.WriteByte('\t')
+			out.Write(b)
+		}
+		out.WriteByte('\n')
+	}
+
+	_, err := p.Stdout.Write(out.Bytes())
+	return err
+}
+
+// drainHub discards all messages sent to the hub. In filter mode
+// nobody is drawing anything, but the source still sends messages
+func drainHub(ctx context.Context, h MessageHub) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case r := <-h.DrawCh():
+			r.Done()
+		case r := <-h.StatusMsgCh():
+			r.Done()
+		case r := <-h.PagingCh():
+			r.Done()
+		case r := <-h.QueryCh():
+			r.Done()
+		}
+	}
+}
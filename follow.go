@@ -0,0 +1,119 @@
+package peco
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/lestrrat-go/pdebug"
+	"github.com/pkg/errors"
+)
+
+// followInterval is how often files are checked for more data with
+// --follow
+const followInterval = 250 * time.Millisecond
+
+// newFollowFile creates a followFile that reads f, which was opened
+// from path. Reading stops once ctx is canceled
+func newFollowFile(ctx context.Context, path string, f *os.File) *followFile {
+	return &followFile{
+		ctx:      ctx,
+		interval: followInterval,
+		path:     path,
+		file:     f,
+	}
+}
+
+// Read reads from the file, waiting for more data to be written at the
+// end of the file. If the file is truncated, it is read again from the
+// start. If the path now refers to another file, as happens when logs
+// are rotated, the new file is read instead. io.EOF is returned once
+// the context is canceled
+func (f *followFile) Read(b []byte) (int, error) {
+	for {
+		n, err := f.read(b)
+		if n > 0 || (err != nil && err != io.EOF) {
+			return n, err
+		}
+
+		changed, err := f.checkFile()
+		if err != nil {
+			return 0, err
+		}
+		if changed {
+			continue
+		}
+
+		select {
+		case <-f.ctx.Done():
+			return 0, io.EOF
+		case <-time.After(f.interval):
+		}
+	}
+}
+
+func (f *followFile) read(b []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	n, err := f.file.Read(b)
+	f.offset += int64(n)
+	return n, err
+}
+
+// checkFile looks for the file being truncated or replaced, once we
+// have read all of it. Returns true if there may be more to read
+func (f *followFile) checkFile() (bool, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		// The file may be in the middle of being rotated. Keep waiting
+		// for the old one until a new one appears
+		return false, nil
+	}
+
+	cur, err := f.file.Stat()
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to stat '%s'", f.path)
+	}
+
+	if !os.SameFile(fi, cur) {
+		// Finish reading what was written to the old file first
+		if cur.Size() > f.offset {
+			return true, nil
+		}
+		if pdebug.Enabled {
+			pdebug.Printf("followFile: %s was replaced, reopening", f.path)
+		}
+		newFile, err := os.Open(f.path)
+		if err != nil {
+			return false, nil
+		}
+		f.file.Close()
+		f.file = newFile
+		f.offset = 0
+		return true, nil
+	}
+
+	if fi.Size() < f.offset {
+		if pdebug.Enabled {
+			pdebug.Printf("followFile: %s was truncated, reading from the start", f.path)
+		}
+		if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+			return false, errors.Wrapf(err, "failed to seek '%s'", f.path)
+		}
+		f.offset = 0
+		return true, nil
+	}
+	return false, nil
+}
+
+// Close closes the file that is being read
+func (f *followFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.file.Close()
+}
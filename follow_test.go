@@ -0,0 +1,100 @@
+package peco
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFollowFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peco-follow-")
+	if !assert.NoError(t, err, "ioutil.TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	if !assert.NoError(t, ioutil.WriteFile(path, []byte("foo\n"), 0644), "ioutil.WriteFile should succeed") {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	f, err := os.Open(path)
+	if !assert.NoError(t, err, "os.Open should succeed") {
+		return
+	}
+	ff := newFollowFile(ctx, path, f)
+	ff.interval = 10 * time.Millisecond
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(ff)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	receive := func(expected string) bool {
+		select {
+		case l := <-lines:
+			return assert.Equal(t, expected, l, "line should match")
+		case <-time.After(5 * time.Second):
+			return assert.Fail(t, "timed out waiting for line "+expected)
+		}
+	}
+	appendFile := func(s string) {
+		out, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if !assert.NoError(t, err, "os.OpenFile should succeed") {
+			return
+		}
+		out.WriteString(s)
+		out.Close()
+	}
+
+	if !receive("foo") {
+		return
+	}
+
+	appendFile("bar\n")
+	if !receive("bar") {
+		return
+	}
+
+	// Truncated files are read again from the start
+	if !assert.NoError(t, ioutil.WriteFile(path, []byte("baz\n"), 0644), "truncating the file should succeed") {
+		return
+	}
+	if !receive("baz") {
+		return
+	}
+
+	// Files that are open cannot be renamed on Windows
+	if runtime.GOOS != "windows" {
+		if !assert.NoError(t, os.Rename(path, path+".1"), "os.Rename should succeed") {
+			return
+		}
+		appendFile("qux\n")
+		if !receive("qux") {
+			return
+		}
+	}
+
+	cancel()
+	select {
+	case _, ok := <-lines:
+		assert.False(t, ok, "reading should stop once the context is canceled")
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "timed out waiting for reading to stop")
+	}
+	assert.NoError(t, ff.Close(), "Close should succeed")
+}
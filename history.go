@@ -0,0 +1,249 @@
+package peco
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// NewHistory creates a new History stored in the file at `path`.
+// Queries are kept separately for each `context`, and at most `size`
+// queries are kept for each of them
+func NewHistory(path, context string, size int) *History {
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+	return &History{
+		path:    path,
+		context: context,
+		size:    size,
+	}
+}
+
+// defaultHistoryPath returns the location of the history file, following
+// the XDG base directory specification
+func defaultHistoryPath() (string, error) {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "peco", "history"), nil
+	}
+
+	home, err := homedirFunc()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to find home directory")
+	}
+	return filepath.Join(home, ".local", "share", "peco", "history"), nil
+}
+
+// Load reads the queries for our context from the history file. A
+// missing file is not an error
+func (h *History) Load() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	all, err := readHistory(h.path)
+	if err != nil {
+		return err
+	}
+	h.setEntries(all)
+	return nil
+}
+
+// Add records a finished query. Any previous occurrence of the query is
+// removed, so that each query appears once, as the most recent entry
+func (h *History) Add(query string) error {
+	if query == "" {
+		return nil
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	// Read the file again, in case other instances of peco updated it
+	all, err := readHistory(h.path)
+	if err != nil {
+		return err
+	}
+
+	kept := make([]historyEntry, 0, len(all)+1)
+	for _, e := range all {
+		if e.Context == h.context && e.Query == query {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	kept = append(kept, historyEntry{Context: h.context, Query: query})
+
+	// Drop the oldest entries for our context that don't fit
+	var count int
+	for i := len(kept) - 1; i >= 0; i-- {
+		if kept[i].Context != h.context {
+			continue
+		}
+		count++
+		if count > h.size {
+			kept = append(kept[:i], kept[i+1:]...)
+		}
+	}
+
+	if err := writeHistory(h.path, kept); err != nil {
+		return err
+	}
+	h.setEntries(kept)
+	return nil
+}
+
+// setEntries picks the queries for our context out of all, and resets
+// the navigation. Must be called while holding the lock
+func (h *History) setEntries(all []historyEntry) {
+	h.entries = h.entries[:0]
+	for _, e := range all {
+		if e.Context == h.context {
+			h.entries = append(h.entries, e.Query)
+		}
+	}
+	h.index = len(h.entries)
+	h.loaded = ""
+	h.navigating = false
+}
+
+// Entries returns the queries for our context, oldest first
+func (h *History) Entries() []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	entries := make([]string, len(h.entries))
+	copy(entries, h.entries)
+	return entries
+}
+
+// begin starts navigating the history, unless `current` is the query
+// that we last loaded, in which case we continue from where we were.
+// Must be called while holding the lock
+func (h *History) begin(current string) {
+	if h.navigating && current == h.loaded {
+		return
+	}
+	h.navigating = true
+	h.index = len(h.entries)
+	h.saved = current
+}
+
+// load makes the i-th entry the current one, and returns it. Moving
+// past the most recent entry returns the query from before we started
+// navigating. Must be called while holding the lock
+func (h *History) load(i int) string {
+	h.index = i
+	if i == len(h.entries) {
+		h.loaded = h.saved
+	} else {
+		h.loaded = h.entries[i]
+	}
+	return h.loaded
+}
+
+// Previous returns the entry before the current one. `current` is
+// the query as it is now. Returns false if there are no older entries
+func (h *History) Previous(current string) (string, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.begin(current)
+	if h.index <= 0 {
+		return "", false
+	}
+	return h.load(h.index - 1), true
+}
+
+// Next returns the entry after the current one. `current` is the query
+// as it is now. Returns false if we are not navigating the history
+func (h *History) Next(current string) (string, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.begin(current)
+	if h.index >= len(h.entries) {
+		return "", false
+	}
+	return h.load(h.index + 1), true
+}
+
+// Search returns the entry before the current one that contains the
+// query as it was when we started navigating, so that repeated calls
+// search further back. Returns false if there are no such entries
+func (h *History) Search(current string) (string, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.begin(current)
+	for i := h.index - 1; i >= 0; i-- {
+		if e := h.entries[i]; e != current && strings.Contains(e, h.saved) {
+			return h.load(i), true
+		}
+	}
+	return "", false
+}
+
+// readHistory reads all of the entries in the history file. Entries
+// that can't be parsed are skipped
+func readHistory(path string) ([]historyEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to open history file")
+	}
+	defer f.Close()
+
+	var entries []historyEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e historyEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Query == "" {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read history file")
+	}
+	return entries, nil
+}
+
+// writeHistory replaces the contents of the history file with entries.
+// The file is replaced atomically, so that other instances of peco
+// never see a partially written file
+func writeHistory(path string, entries []historyEntry) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Wrap(err, "failed to create history directory")
+	}
+
+	f, err := ioutil.TempFile(dir, ".history")
+	if err != nil {
+		return errors.Wrap(err, "failed to create history file")
+	}
+	defer os.Remove(f.Name())
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			f.Close()
+			return errors.Wrap(err, "failed to encode history entry")
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to write history file")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "failed to write history file")
+	}
+	return errors.Wrap(os.Rename(f.Name(), path), "failed to replace history file")
+}
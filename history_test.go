@@ -0,0 +1,153 @@
+package peco
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"context"
+
+	"github.com/nsf/termbox-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestHistory(t *testing.T, context string, size int) (*History, func()) {
+	dir, err := ioutil.TempDir("", "peco-history-")
+	if !assert.NoError(t, err, "creating a temporary directory should succeed") {
+		t.FailNow()
+	}
+	h := NewHistory(filepath.Join(dir, "peco", "history"), context, size)
+	return h, func() { os.RemoveAll(dir) }
+}
+
+func TestHistoryAdd(t *testing.T) {
+	h, cleanup := newTestHistory(t, "", 3)
+	defer cleanup()
+
+	for _, q := range []string{"foo", "bar", "", "foo", "baz", "qux"} {
+		if !assert.NoError(t, h.Add(q), "Add(%q) should succeed", q) {
+			return
+		}
+	}
+	assert.Equal(t, []string{"foo", "baz", "qux"}, h.Entries(), "duplicates should be removed, and the oldest entries dropped")
+
+	// Other contexts share the file, but not the entries
+	other := NewHistory(h.path, "other", 3)
+	if !assert.NoError(t, other.Load(), "Load should succeed") {
+		return
+	}
+	assert.Empty(t, other.Entries(), "entries should be kept per context")
+	if !assert.NoError(t, other.Add("foo"), "Add should succeed") {
+		return
+	}
+
+	reloaded := NewHistory(h.path, "", 3)
+	if !assert.NoError(t, reloaded.Load(), "Load should succeed") {
+		return
+	}
+	assert.Equal(t, []string{"foo", "baz", "qux"}, reloaded.Entries(), "entries should be read back from the file")
+
+	missing := NewHistory(filepath.Join(filepath.Dir(h.path), "missing"), "", 3)
+	assert.NoError(t, missing.Load(), "a missing file should not be an error")
+}
+
+func TestHistoryNavigation(t *testing.T) {
+	h, cleanup := newTestHistory(t, "", 0)
+	defer cleanup()
+
+	for _, q := range []string{"foo", "bar", "foobar"} {
+		if !assert.NoError(t, h.Add(q), "Add(%q) should succeed", q) {
+			return
+		}
+	}
+
+	_, ok := h.Next("typed")
+	assert.False(t, ok, "Next should fail before navigating")
+
+	current := "typed"
+	for _, expect := range []string{"foobar", "bar", "foo"} {
+		s, ok := h.Previous(current)
+		if !assert.True(t, ok, "Previous should succeed") || !assert.Equal(t, expect, s, "Previous should return older entries") {
+			return
+		}
+		current = s
+	}
+	_, ok = h.Previous(current)
+	assert.False(t, ok, "Previous should fail at the oldest entry")
+
+	for _, expect := range []string{"bar", "foobar", "typed"} {
+		s, ok := h.Next(current)
+		if !assert.True(t, ok, "Next should succeed") || !assert.Equal(t, expect, s, "Next should return newer entries, then the original query") {
+			return
+		}
+		current = s
+	}
+
+	// Editing the query starts over
+	s, ok := h.Previous("edited")
+	assert.True(t, ok, "Previous should succeed")
+	assert.Equal(t, "foobar", s, "Previous should start from the newest entry")
+
+	current = "foo"
+	for _, expect := range []string{"foobar", "foo"} {
+		s, ok := h.Search(current)
+		if !assert.True(t, ok, "Search should succeed") || !assert.Equal(t, expect, s, "Search should return older entries that match") {
+			return
+		}
+		current = s
+	}
+	_, ok = h.Search(current)
+	assert.False(t, ok, "Search should fail when there are no more matches")
+}
+
+func TestDoHistoryPrevious(t *testing.T) {
+	state := newPeco()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go state.Run(ctx)
+
+	<-state.Ready()
+
+	h, cleanup := newTestHistory(t, "", 0)
+	defer cleanup()
+	if !assert.NoError(t, h.Add("hello"), "Add should succeed") {
+		return
+	}
+	state.history = h
+
+	doHistoryPrevious(ctx, state, termbox.Event{})
+	expectQueryString(t, state.Query(), "hello")
+	expectCaretPos(t, state.Caret(), 5)
+
+	doHistoryNext(ctx, state, termbox.Event{})
+	expectQueryString(t, state.Query(), "")
+	expectCaretPos(t, state.Caret(), 0)
+}
+
+func TestHistoryLoadError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peco-history-")
+	if !assert.NoError(t, err, "creating a temporary directory should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	// Lines longer than the scanner's buffer can not be read
+	path := filepath.Join(dir, "history")
+	if !assert.NoError(t, ioutil.WriteFile(path, []byte(strings.Repeat("x", 100*1024)+"\n"), 0600), "ioutil.WriteFile should succeed") {
+		return
+	}
+
+	state := newPeco()
+	state.Argv = []string{"peco", "--history", path, state.Argv[1]}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go state.Run(ctx)
+
+	<-state.Ready()
+
+	assert.NoError(t, state.Err(), "peco should start")
+	assert.Nil(t, state.history, "history should be disabled")
+	assert.Error(t, state.historyErr, "the error should be kept to be shown")
+}
@@ -0,0 +1,227 @@
+package peco
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nsf/termbox-go"
+	"github.com/pkg/errors"
+)
+
+// reCursorPosition matches the response of the terminal to a request
+// for the position of the cursor
+var reCursorPosition = regexp.MustCompile("\x1b\\[(\\d+);(\\d+)R")
+
+// minInlineHeight is the smallest region that can fit the prompt, a
+// single line, and the status bar
+const minInlineHeight = 3
+
+// parseHeight parses the argument to --height, which is either a
+// number of lines, or a percentage of the terminal height
+func parseHeight(s string) (int, bool, error) {
+	percent := strings.HasSuffix(s, "%")
+	n, err := strconv.Atoi(strings.TrimSuffix(s, "%"))
+	if err != nil || n <= 0 || (percent && n > 100) {
+		return 0, false, errors.Errorf("invalid height: '%s'", s)
+	}
+	return n, percent, nil
+}
+
+// inlineHeight computes the number of lines to use for a terminal with
+// termHeight lines
+func inlineHeight(n int, percent bool, termHeight int) int {
+	if percent {
+		n = termHeight * n / 100
+	}
+	if n > termHeight {
+		n = termHeight
+	}
+	if n < minInlineHeight {
+		n = minInlineHeight
+	}
+	return n
+}
+
+// inlineKeys maps the escape sequences sent by terminals for special
+// keys to termbox keys. Modifiers (e.g. "\x1b[1;5A") are ignored
+var inlineKeys = map[string]termbox.Key{
+	"A":   termbox.KeyArrowUp,
+	"B":   termbox.KeyArrowDown,
+	"C":   termbox.KeyArrowRight,
+	"D":   termbox.KeyArrowLeft,
+	"H":   termbox.KeyHome,
+	"F":   termbox.KeyEnd,
+	"P":   termbox.KeyF1,
+	"Q":   termbox.KeyF2,
+	"R":   termbox.KeyF3,
+	"S":   termbox.KeyF4,
+	"1~":  termbox.KeyHome,
+	"2~":  termbox.KeyInsert,
+	"3~":  termbox.KeyDelete,
+	"4~":  termbox.KeyEnd,
+	"5~":  termbox.KeyPgup,
+	"6~":  termbox.KeyPgdn,
+	"7~":  termbox.KeyHome,
+	"8~":  termbox.KeyEnd,
+	"11~": termbox.KeyF1,
+	"12~": termbox.KeyF2,
+	"13~": termbox.KeyF3,
+	"14~": termbox.KeyF4,
+	"15~": termbox.KeyF5,
+	"17~": termbox.KeyF6,
+	"18~": termbox.KeyF7,
+	"19~": termbox.KeyF8,
+	"20~": termbox.KeyF9,
+	"21~": termbox.KeyF10,
+	"23~": termbox.KeyF11,
+	"24~": termbox.KeyF12,
+}
+
+// parseInlineInput converts the bytes read from the terminal into
+// events, in the same way termbox does. Returns the events, and the
+// bytes of an incomplete sequence at the end of buf, if any. If no
+// more input is expected soon, `flush` should be true, so that the
+// bytes of an incomplete sequence are treated as separate keys
+func parseInlineInput(buf []byte, flush bool) ([]termbox.Event, []byte) {
+	var events []termbox.Event
+	for len(buf) > 0 {
+		ev := termbox.Event{Type: termbox.EventKey}
+		switch c := buf[0]; {
+		case c == '\x1b' && len(buf) > 1 && (buf[1] == '[' || buf[1] == 'O'):
+			// CSI or SS3: parameters, then a final byte
+			i := 2
+			for i < len(buf) && (buf[i] < 0x40 || buf[i] > 0x7e) {
+				i++
+			}
+			if i >= len(buf) {
+				if !flush {
+					return events, buf
+				}
+				ev.Key = termbox.KeyEsc
+				buf = buf[1:]
+				break
+			}
+			seq := string(buf[2 : i+1])
+			buf = buf[i+1:]
+			if strings.HasPrefix(seq, "<") {
+				var ok bool
+				if ev, ok = parseInlineMouse(seq); ok {
+					events = append(events, ev)
+				}
+				continue
+			}
+			key, ok := inlineKeys[inlineKeySequence(seq)]
+			if !ok {
+				continue
+			}
+			ev.Key = key
+		case c <= ' ' || c == 0x7f:
+			// Control characters (including Esc), space and backspace
+			// map directly onto termbox keys. Alt is detected by Input,
+			// from an Esc that is immediately followed by another key
+			ev.Key = termbox.Key(c)
+			buf = buf[1:]
+		default:
+			if !utf8.FullRune(buf) && !flush {
+				return events, buf
+			}
+			r, n := utf8.DecodeRune(buf)
+			ev.Ch = r
+			buf = buf[n:]
+		}
+		events = append(events, ev)
+	}
+	return events, nil
+}
+
+// inlineKeySequence removes the modifiers from the parameters of a key
+// sequence, e.g. "1;5A" becomes "A", and "3;2~" becomes "3~"
+func inlineKeySequence(s string) string {
+	if i := strings.IndexByte(s, ';'); i >= 0 {
+		final := s[len(s)-1:]
+		if s[:i] == "1" && final != "~" {
+			return final
+		}
+		return s[:i] + final
+	}
+	return s
+}
+
+// parseInlineMouse parses the parameters of a mouse event reported
+// using the SGR extended mode (1006), e.g. "<0;10;5M"
+func parseInlineMouse(seq string) (termbox.Event, bool) {
+	var ev termbox.Event
+	params := strings.Split(seq[1:len(seq)-1], ";")
+	if len(params) != 3 {
+		return ev, false
+	}
+	var n [3]int
+	for i, p := range params {
+		v, err := strconv.Atoi(p)
+		if err != nil {
+			return ev, false
+		}
+		n[i] = v
+	}
+
+	b := n[0]
+	switch {
+	case seq[len(seq)-1] == 'm':
+		ev.Key = termbox.MouseRelease
+	case b&64 != 0 && b&3 == 0:
+		ev.Key = termbox.MouseWheelUp
+	case b&64 != 0 && b&3 == 1:
+		ev.Key = termbox.MouseWheelDown
+	case b&3 == 0:
+		ev.Key = termbox.MouseLeft
+	case b&3 == 1:
+		ev.Key = termbox.MouseMiddle
+	case b&3 == 2:
+		ev.Key = termbox.MouseRight
+	default:
+		ev.Key = termbox.MouseRelease
+	}
+	if b&4 != 0 {
+		ev.Mod |= modMouseShift
+	}
+	if b&32 != 0 {
+		ev.Mod |= termbox.ModMotion
+	}
+
+	// The coordinates are 1 based
+	ev.Type = termbox.EventMouse
+	ev.MouseX = n[1] - 1
+	ev.MouseY = n[2] - 1
+	return ev, true
+}
+
+// writeInlineSGR writes the escape sequence that sets the terminal to
+// display text using fg and bg
+func writeInlineSGR(out *bytes.Buffer, fg, bg termbox.Attribute, use256Color bool) {
+	out.WriteString("\x1b[0")
+	if fg&termbox.AttrBold != 0 {
+		out.WriteString(";1")
+	}
+	if fg&termbox.AttrUnderline != 0 {
+		out.WriteString(";4")
+	}
+	if fg&termbox.AttrReverse != 0 || bg&termbox.AttrReverse != 0 {
+		out.WriteString(";7")
+	}
+	writeInlineColor(out, "3", fg&0x1FF, use256Color)
+	writeInlineColor(out, "4", bg&0x1FF, use256Color)
+	out.WriteByte('m')
+}
+
+func writeInlineColor(out *bytes.Buffer, prefix string, c termbox.Attribute, use256Color bool) {
+	switch {
+	case c == termbox.ColorDefault:
+	case c <= termbox.ColorWhite:
+		out.WriteString(";" + prefix + strconv.Itoa(int(c-1)))
+	case use256Color:
+		out.WriteString(";" + prefix + "8;5;" + strconv.Itoa(int(c-1)))
+	}
+}
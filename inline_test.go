@@ -0,0 +1,86 @@
+package peco
+
+import (
+	"testing"
+
+	"github.com/nsf/termbox-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHeight(t *testing.T) {
+	testValues := []struct {
+		input   string
+		lines   int
+		percent bool
+		err     bool
+	}{
+		{"10", 10, false, false},
+		{"40%", 40, true, false},
+		{"100%", 100, true, false},
+		{"0", 0, false, true},
+		{"-1", 0, false, true},
+		{"101%", 0, false, true},
+		{"%", 0, false, true},
+		{"ten", 0, false, true},
+	}
+
+	for _, v := range testValues {
+		lines, percent, err := parseHeight(v.input)
+		if v.err {
+			assert.Error(t, err, "parseHeight(%q) should fail", v.input)
+			continue
+		}
+		if !assert.NoError(t, err, "parseHeight(%q) should succeed", v.input) {
+			continue
+		}
+		assert.Equal(t, v.lines, lines, "parseHeight(%q) lines", v.input)
+		assert.Equal(t, v.percent, percent, "parseHeight(%q) percent", v.input)
+	}
+
+	assert.Equal(t, 10, inlineHeight(10, false, 24), "height should be used as is")
+	assert.Equal(t, 24, inlineHeight(30, false, 24), "height should be limited to the terminal")
+	assert.Equal(t, 12, inlineHeight(50, true, 24), "percentage of the terminal")
+	assert.Equal(t, minInlineHeight, inlineHeight(1, false, 24), "height should fit the prompt, a line, and the status bar")
+}
+
+func TestParseInlineInput(t *testing.T) {
+	key := func(k termbox.Key) termbox.Event {
+		return termbox.Event{Type: termbox.EventKey, Key: k}
+	}
+	ch := func(r rune) termbox.Event {
+		return termbox.Event{Type: termbox.EventKey, Ch: r}
+	}
+
+	testValues := []struct {
+		name    string
+		input   string
+		flush   bool
+		events  []termbox.Event
+		pending string
+	}{
+		{"Characters", "aé日", false, []termbox.Event{ch('a'), ch('é'), ch('日')}, ""},
+		{"Control keys", "\x01 \r\x7f", false, []termbox.Event{key(termbox.KeyCtrlA), key(termbox.KeySpace), key(termbox.KeyEnter), key(termbox.KeyBackspace2)}, ""},
+		{"Arrows", "\x1b[A\x1bOB\x1b[1;5C", false, []termbox.Event{key(termbox.KeyArrowUp), key(termbox.KeyArrowDown), key(termbox.KeyArrowRight)}, ""},
+		{"Tilde sequences", "\x1b[3~\x1b[5;2~\x1b[15~", false, []termbox.Event{key(termbox.KeyDelete), key(termbox.KeyPgup), key(termbox.KeyF5)}, ""},
+		{"Unknown sequences are dropped", "\x1b[99~x", false, []termbox.Event{ch('x')}, ""},
+		{"Esc and Alt", "\x1bx\x1b", false, []termbox.Event{key(termbox.KeyEsc), ch('x'), key(termbox.KeyEsc)}, ""},
+		{"Incomplete sequence", "a\x1b[1;", false, []termbox.Event{ch('a')}, "\x1b[1;"},
+		{"Incomplete rune", "a\xe6\x97", false, []termbox.Event{ch('a')}, "\xe6\x97"},
+		{"Flushed sequence", "\x1b[", true, []termbox.Event{key(termbox.KeyEsc), ch('[')}, ""},
+		{"Mouse", "\x1b[<0;3;5M\x1b[<0;3;5m\x1b[<6;1;1M\x1b[<65;10;2M\x1b[<32;4;4M", false, []termbox.Event{
+			{Type: termbox.EventMouse, Key: termbox.MouseLeft, MouseX: 2, MouseY: 4},
+			{Type: termbox.EventMouse, Key: termbox.MouseRelease, MouseX: 2, MouseY: 4},
+			{Type: termbox.EventMouse, Key: termbox.MouseRight, Mod: modMouseShift},
+			{Type: termbox.EventMouse, Key: termbox.MouseWheelDown, MouseX: 9, MouseY: 1},
+			{Type: termbox.EventMouse, Key: termbox.MouseLeft, Mod: termbox.ModMotion, MouseX: 3, MouseY: 3},
+		}, ""},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			events, pending := parseInlineInput([]byte(v.input), v.flush)
+			assert.Equal(t, v.events, events, "events should match")
+			assert.Equal(t, v.pending, string(pending), "pending input should match")
+		})
+	}
+}
@@ -6,14 +6,16 @@ import (
 	"context"
 
 	"github.com/lestrrat-go/pdebug"
+	"github.com/mattn/go-runewidth"
 	"github.com/nsf/termbox-go"
 )
 
-func NewInput(state *Peco, am ActionMap, src chan termbox.Event) *Input {
+func NewInput(state *Peco, am ActionMap, src chan termbox.Event, view *View) *Input {
 	return &Input{
 		actions: am,
 		evsrc:   src,
 		state:   state,
+		view:    view,
 	}
 }
 
@@ -44,6 +46,9 @@ func (i *Input) handleInputEvent(ctx context.Context, ev termbox.Event) error {
 	case termbox.EventResize:
 		i.state.Hub().SendDraw(ctx, nil)
 		return nil
+	case termbox.EventMouse:
+		i.handleMouseEvent(ctx, ev)
+		return nil
 	case termbox.EventKey:
 		// ModAlt is a sequence of letters with a leading \x1b (=Esc).
 		// It would be nice if termbox differentiated this for us, but
@@ -85,3 +90,90 @@ func (i *Input) handleInputEvent(ctx context.Context, ev termbox.Event) error {
 
 	return nil
 }
+
+// handleMouseEvent handles clicks and the mouse wheel, depending on
+// what is displayed where the event happened
+func (i *Input) handleMouseEvent(ctx context.Context, ev termbox.Event) {
+	if ev.Mod&termbox.ModMotion != 0 {
+		// Dragging is not supported
+		return
+	}
+
+	state := i.state
+	target, n := i.view.layout.mouseTarget(ev.MouseX, ev.MouseY)
+	switch ev.Key {
+	case termbox.MouseWheelUp, termbox.MouseWheelDown:
+		up := ev.Key == termbox.MouseWheelUp
+		var req PagingRequestType
+		switch {
+		case target == mouseTargetPreview && up:
+			req = ToScrollPreviewUp
+		case target == mouseTargetPreview:
+			req = ToScrollPreviewDown
+		case up:
+			req = ToScrollPageUp
+		default:
+			req = ToScrollPageDown
+		}
+		state.Hub().SendPaging(ctx, req)
+	case termbox.MouseLeft, termbox.MouseRight:
+		switch target {
+		case mouseTargetPrompt:
+			state.Caret().SetPos(caretPosAt(state.Query().String(), n))
+			state.Hub().SendDrawPrompt(ctx)
+		case mouseTargetList:
+			i.clickLine(ctx, n, ev)
+		}
+	}
+}
+
+// clickLine moves the cursor to the n-th line of the current page.
+// Shift-click (or right click, as many terminals do not report
+// Shift-click) toggles the selection of the line, and double click
+// finishes
+func (i *Input) clickLine(ctx context.Context, n int, ev termbox.Event) {
+	state := i.state
+	lineno := state.Location().Offset() + n
+	if lineno >= state.CurrentLineBuffer().Size() {
+		return
+	}
+
+	toggle := ev.Key == termbox.MouseRight || ev.Mod&modMouseShift != 0
+
+	i.mutex.Lock()
+	now := time.Now()
+	doubleClick := !toggle && i.lastClickLine == lineno && now.Sub(i.lastClick) < doubleClickInterval
+	if doubleClick || toggle {
+		i.lastClick = time.Time{}
+	} else {
+		i.lastClick = now
+		i.lastClickLine = lineno
+	}
+	i.mutex.Unlock()
+
+	state.Hub().Batch(ctx, func(ctx context.Context) {
+		state.Hub().SendPaging(ctx, JumpToLineRequest(n))
+		switch {
+		case toggle:
+			doToggleSelection(ctx, state, ev)
+			state.Hub().SendDraw(ctx, &DrawOptions{DisableCache: true})
+		case doubleClick:
+			doFinish(ctx, state, ev)
+		}
+	}, true)
+}
+
+// caretPosAt returns the position of the caret in the query q that is
+// displayed at column x, relative to the start of the query
+func caretPosAt(q string, x int) int {
+	var pos, col int
+	for _, r := range q {
+		w := runewidth.RuneWidth(r)
+		if col+w > x {
+			break
+		}
+		col += w
+		pos++
+	}
+	return pos
+}
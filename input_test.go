@@ -0,0 +1,49 @@
+package peco
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/filter"
+	"github.com/peco/peco/hub"
+	"github.com/peco/peco/line"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMouseWheel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	state := newPeco()
+	state.hub = hub.New(5)
+	state.styles.Init()
+	state.Filters().Add(filter.NewIgnoreCase())
+
+	var lines []line.Line
+	for i := 0; i < 20; i++ {
+		lines = append(lines, line.NewRaw(uint64(i), fmt.Sprintf("line %d", i), false))
+	}
+	state.SetCurrentLineBuffer(newTestMemoryBuffer(lines...))
+
+	// The loops are not running, so the paging requests are handled
+	// here, the way that the view does
+	view := NewView(state)
+	input := NewInput(state, nil, nil, view)
+	scroll := func(key termbox.Key) {
+		input.handleMouseEvent(ctx, termbox.Event{Type: termbox.EventMouse, Key: key, MouseX: 0, MouseY: 1})
+		r := <-state.Hub().PagingCh()
+		view.movePage(r, r.Data().(PagingRequest))
+	}
+
+	// 10 lines high, less the prompt and the status bar
+	const perPage = 8
+
+	// The wheel scrolls by a page, like peco.ScrollPageDown
+	scroll(termbox.MouseWheelDown)
+	assert.Equal(t, perPage, state.Location().LineNumber(), "the wheel should scroll down a page")
+
+	scroll(termbox.MouseWheelUp)
+	assert.Equal(t, 0, state.Location().LineNumber(), "the wheel should scroll up a page")
+}
@@ -1,8 +1,11 @@
 package peco
 
 import (
+	"bytes"
 	"io"
+	"os"
 	"sync"
+	"text/template"
 	"time"
 
 	"context"
@@ -22,15 +25,19 @@ const (
 )
 
 const (
-	ToLineAbove       PagingRequestType = iota // ToLineAbove moves the selection to the line above
-	ToScrollPageDown                           // ToScrollPageDown moves the selection to the next page
-	ToLineBelow                                // ToLineBelow moves the selection to the line below
-	ToScrollPageUp                             // ToScrollPageUp moves the selection to the previous page
-	ToScrollLeft                               // ToScrollLeft scrolls screen to the left
-	ToScrollRight                              // ToScrollRight scrolls screen to the right
-	ToLineInPage                               // ToLineInPage jumps to a particular line on the page
-	ToScrollFirstItem                          // ToScrollFirstItem
-	ToScrollLastItem                           // ToScrollLastItem
+	ToLineAbove             PagingRequestType = iota // ToLineAbove moves the selection to the line above
+	ToScrollPageDown                                 // ToScrollPageDown moves the selection to the next page
+	ToLineBelow                                      // ToLineBelow moves the selection to the line below
+	ToScrollPageUp                                   // ToScrollPageUp moves the selection to the previous page
+	ToScrollLeft                                     // ToScrollLeft scrolls screen to the left
+	ToScrollRight                                    // ToScrollRight scrolls screen to the right
+	ToLineInPage                                     // ToLineInPage jumps to a particular line on the page
+	ToScrollFirstItem                                // ToScrollFirstItem
+	ToScrollLastItem                                 // ToScrollLastItem
+	ToScrollPreviewUp                                // ToScrollPreviewUp scrolls the preview pane up by a line
+	ToScrollPreviewDown                              // ToScrollPreviewDown scrolls the preview pane down by a line
+	ToScrollPreviewPageUp                            // ToScrollPreviewPageUp scrolls the preview pane up by half a page
+	ToScrollPreviewPageDown                          // ToScrollPreviewPageDown scrolls the preview pane down by half a page
 )
 
 const (
@@ -39,6 +46,26 @@ const (
 	LayoutTypeBottomUp = "bottom-up"
 )
 
+const (
+	PreviewPositionRight  = "right"  // PreviewPositionRight places the preview pane to the right of the list
+	PreviewPositionBottom = "bottom" // PreviewPositionBottom places the preview pane below the list
+	DefaultPreviewSize    = 50       // DefaultPreviewSize is the percentage of the screen used by the preview pane
+)
+
+const (
+	OutputText = "text" // OutputText prints the selected lines
+	OutputJSON = "json" // OutputJSON prints the results as a JSON document
+)
+
+const (
+	// previewMaxLines is the maximum number of lines of preview output
+	// that are kept. The command is stopped once this is reached
+	previewMaxLines = 1000
+	// previewDrawInterval is how often the screen is redrawn while
+	// preview output is being read
+	previewDrawInterval = 50 * time.Millisecond
+)
+
 const (
 	AnchorTop    VerticalAnchor = iota + 1 // AnchorTop anchors elements towards the top of the screen
 	AnchorBottom                           // AnchorBottom anchors elements towards the bottom of the screen
@@ -65,6 +92,7 @@ type Peco struct {
 	Stderr io.Writer
 	hub    MessageHub
 
+	ansi       bool // True if colors in the input should be displayed
 	args       []string
 	bufferSize int
 	caret      Caret
@@ -73,17 +101,25 @@ type Peco struct {
 	currentLineBuffer       Buffer
 	enableSep               bool // Enable parsing on separators
 	execOnFinish            string
+	fields                  *line.Fields // nil unless field restrictions were specified
+	filterMode              bool         // True if --filter is specified
+	filterQuery             string       // query used in filter mode
 	filters                 filter.Set
+	history                 *History // nil if the history is disabled
+	historyErr              error    // set if the history could not be loaded
 	idgen                   *idgen
 	initialFilter           string
 	initialQuery            string   // populated if --query is specified
 	inputseq                Inputseq // current key sequence (just the names)
+	json                    *line.JSONFormat
 	keymap                  Keymap
 	layoutType              string
 	location                Location
 	maxScanBufferSize       int
 	mutex                   sync.Mutex
 	onCancel                string
+	preview                 *Preview // nil unless a preview command was specified
+	printIndices            bool
 	printQuery              bool
 	prompt                  string
 	query                   Query
@@ -104,17 +140,85 @@ type Peco struct {
 	skipReadConfig          bool
 	styles                  StyleSet
 	use256Color             bool
+	vim                     *Vim // nil unless vim-style editing is enabled
 
 	// Source is where we buffer input. It gets reused when a new query is
 	// executed.
 	source *Source
 
+	// resultCache holds the results of recent queries against source.
+	// The results for a source are dropped when it is replaced
+	resultCache resultCache
+
+	// sourceCommand is the command that the input is read from, which
+	// is run again to reload the lines
+	sourceCommand  string
+	reloadInterval time.Duration // zero unless the source is reloaded periodically
+	reloadMutex    sync.Mutex
+	reloadCancel   func() // cancels the reload that is running
+
+	// dynamicSource is the command that the input is read from, which
+	// is run again with the query whenever the query changes
+	dynamicSource string
+	dynamicQuery  string // the part of the query that the command was run with
+
+	// outputFormat and outputJSON change how the results are printed.
+	// finishKey and finishAction record how peco was finished, so that
+	// they can be printed along with the results
+	outputFormat *template.Template // nil unless --output-format is specified
+	outputJSON   bool
+	finishKey    string
+	finishAction string
+
+	// expect holds the names of the keys that finish peco in addition
+	// to peco.Finish. The name of the key is printed before the results
+	expect []string
+
+	// showOrigin and printOrigin add the file and line number that each
+	// line was read from to what is displayed, and to what is printed
+	showOrigin  bool
+	printOrigin bool
+
+	// follow keeps reading the input files as they grow
+	follow bool
+
 	// cancelFunc is called for Exit()
 	cancelFunc func()
 	// Errors are stored here
 	err error
 }
 
+// OutputLine is a line that is printed as peco finishes, as seen by
+// the --output-format template and --output json
+type OutputLine struct {
+	Index   int         `json:"index"`            // position of the line in the input, or -1
+	Line    string      `json:"line"`             // what is printed by default
+	Display string      `json:"display"`          // what was displayed
+	Matches [][]int     `json:"matches"`          // regions of Display that matched the query
+	Record  interface{} `json:"record,omitempty"` // the record, if the input is JSON Lines
+	Origin  string      `json:"origin,omitempty"` // "filename:lineno" that the line was read from
+}
+
+// OutputResults is what is printed as peco finishes, as seen by
+// --output json
+type OutputResults struct {
+	Query     string       `json:"query"`
+	Filter    string       `json:"filter"`
+	Key       string       `json:"key"`    // the key that finished peco
+	Action    string       `json:"action"` // the action that finished peco
+	Selection []OutputLine `json:"selection"`
+}
+
+// outputFormatData is what the --output-format template is executed
+// with for each line
+type outputFormatData struct {
+	OutputLine
+	Query  string
+	Filter string
+	Key    string
+	Action string
+}
+
 type MatchIndexer interface {
 	// Indices return the matched portion(s) of a string after filtering.
 	// Note that while Indices may return nil, that just means that there are
@@ -171,6 +275,39 @@ type Termbox struct {
 	suspendCh chan struct{}
 }
 
+// InlineScreen draws to a region of the terminal below the cursor,
+// instead of taking over the whole terminal like Termbox does. It
+// writes escape sequences to the terminal directly
+type InlineScreen struct {
+	mutex       sync.Mutex
+	lines       int  // requested height of the region
+	percent     bool // true if lines is a percentage of the terminal height
+	use256Color bool
+	mouse       bool
+	top         int    // row of the terminal where the region starts, or -1 if unknown
+	pending     []byte // input read while waiting for the position of the cursor
+	tty         *os.File
+	restore     func() error // restores the terminal to its original state
+	width       int
+	height      int
+	row         int // row of the region that the terminal's cursor is on
+	cursorX     int
+	cursorY     int
+	back        []inlineCell // what should be displayed
+	front       []inlineCell // what is being displayed
+	out         bytes.Buffer
+	resizeCh    chan os.Signal
+	suspendCh   chan chan struct{}
+	resumeCh    chan chan struct{}
+	done        chan struct{} // closed when we stop reading from the terminal
+}
+
+type inlineCell struct {
+	ch rune
+	fg termbox.Attribute
+	bg termbox.Attribute
+}
+
 // View handles the drawing/updating the screen
 type View struct {
 	layout Layout
@@ -198,6 +335,7 @@ type Layout interface {
 	DrawScreen(*Peco, *DrawOptions)
 	MovePage(*Peco, PagingRequest) (moved bool)
 	PurgeDisplayCache()
+	mouseTarget(x, y int) (mouseTarget, int)
 }
 
 // AnchorSettings groups items that are required to control
@@ -232,6 +370,58 @@ type ListArea struct {
 	displayCache []line.Line
 	dirty        bool
 	styles       *StyleSet
+	width        int // 0 means the entire width of the screen
+}
+
+// Preview runs a command for the current line, and keeps its output
+// so that it can be displayed by PreviewArea
+type Preview struct {
+	cancel      func() // cancels the running command
+	changed     bool   // true if output was added since the last redraw
+	command     string
+	generation  uint64 // incremented whenever the output is discarded
+	lines       [][]ansiSegment
+	mutex       sync.Mutex
+	offset      int // scroll position
+	position    string
+	size        int
+	styles      *StyleSet
+	target      uint64 // ID of the line that the output is for
+	use256Color bool
+	visible     bool
+}
+
+// PreviewArea displays the output of the preview command
+type PreviewArea struct {
+	preview *Preview
+	screen  Screen
+	styles  *StyleSet
+}
+
+// previewRect describes the part of the screen where the preview
+// output is displayed
+type previewRect struct {
+	x      int
+	y      int
+	width  int
+	height int
+}
+
+// ansiState keeps track of the attributes set by ANSI escape sequences
+type ansiState struct {
+	defaultFg   termbox.Attribute
+	defaultBg   termbox.Attribute
+	fg          termbox.Attribute
+	bg          termbox.Attribute
+	use256Color bool
+}
+
+// ansiSegment is a piece of text that is displayed using the same
+// attributes
+type ansiSegment struct {
+	text string
+	fg   termbox.Attribute
+	bg   termbox.Attribute
 }
 
 // BasicLayout is... the basic layout :) At this point this is the
@@ -240,22 +430,53 @@ type ListArea struct {
 // that are used are set and static
 type BasicLayout struct {
 	*StatusBar
-	prompt *UserPrompt
-	list   *ListArea
+	prompt      *UserPrompt
+	list        *ListArea
+	preview     *PreviewArea // nil unless a preview command was specified
+	lastBuffer  Buffer       // buffer that was displayed last
+	lastTrimmed int          // lines that had been removed from lastBuffer
 }
 
 // Keymap holds all the key sequence to action map
 type Keymap struct {
 	Config map[string]string
-	Action map[string][]string // custom actions
+	Action map[string][]string          // custom actions
+	Modes  map[string]map[string]string // custom key bindings for each mode
 	seq    Keyseq
+	modes  map[string]Keyseq
 }
 
 // Filter is responsible for the actual "grep" part of peco
 type Filter struct {
+	cache *resultCache
 	state *Peco
 }
 
+// resultCacheSize is the number of query results that are kept
+// around for reuse
+const resultCacheSize = 8
+
+// resultCache remembers the results of recent queries, so that they
+// can be reused when the user narrows the query, or goes back to a
+// previous query (e.g. by deleting characters)
+type resultCache struct {
+	entries []resultCacheEntry // oldest first
+	mutex   sync.Mutex
+}
+
+type resultCacheEntry struct {
+	buf    *MemoryBuffer
+	filter filter.Filter
+	query  string
+	source pipeline.Source
+}
+
+// bufferSource is a pipeline.Source that replays a fixed set of lines,
+// such as the results of a previous query
+type bufferSource struct {
+	lines []line.Line
+}
+
 // Action describes an action that can be executed upon receiving user
 // input. It's an interface so you can create any kind of Action you need,
 // but most everything is implemented in terms of ActionFunc, which is
@@ -293,6 +514,9 @@ type Config struct {
 	Prompt              string            `json:"Prompt"`
 	Layout              string            `json:"Layout"`
 	Use256Color         bool              `json:"Use256Color"`
+	ANSI                bool              `json:"ANSI"`
+	Mouse               bool              `json:"Mouse"`
+	Vim                 bool              `json:"Vim"`
 	OnCancel            string            `json:"OnCancel"`
 	CustomMatcher       map[string][]string
 	CustomFilter        map[string]CustomFilterConfig
@@ -306,6 +530,70 @@ type Config struct {
 
 	// Use this prefix to denote currently selected line
 	SelectionPrefix string `json:"SelectionPrefix"`
+
+	// Delimiter is the regular expression used to split lines into
+	// fields. By default lines are split on white space
+	Delimiter string `json:"Delimiter"`
+	// Nth specifies the fields to match against
+	Nth string `json:"Nth"`
+	// WithNth specifies the fields to display
+	WithNth string `json:"WithNth"`
+	// OutputNth specifies the fields to output
+	OutputNth string `json:"OutputNth"`
+
+	// Preview configures the preview pane
+	Preview PreviewConfig `json:"Preview"`
+
+	// History configures the query history
+	History HistoryConfig `json:"History"`
+
+	// JSON configures how JSON Lines input is handled, see --json
+	JSON JSONConfig `json:"JSON"`
+
+	// Expect lists keys that finish peco like peco.Finish, see --expect
+	Expect []string `json:"Expect"`
+
+	// ModeKeymap holds key bindings that only apply in a given mode,
+	// such as the modes of the vim-style editing
+	ModeKeymap map[string]map[string]string `json:"ModeKeymap"`
+}
+
+// JSONConfig is used to configure how JSON Lines input is displayed,
+// matched against, and output. Each of these is either a field path,
+// such as `.metadata.name`, or a template, such as `{{.name}} {{.status}}`
+type JSONConfig struct {
+	// Display is what is displayed. The default is the record itself
+	Display string `json:"Display"`
+	// Match is what filters match against. The default is what is
+	// displayed
+	Match string `json:"Match"`
+	// Output is what is output. The default is the record itself
+	Output string `json:"Output"`
+}
+
+// HistoryConfig is used to configure the query history
+type HistoryConfig struct {
+	// Path is the file that the history is stored in. The default is
+	// $XDG_DATA_HOME/peco/history
+	Path string `json:"Path"`
+	// Size is the number of queries kept for each context
+	Size int `json:"Size"`
+	// Disable stops peco from reading and recording the history
+	Disable bool `json:"Disable"`
+}
+
+// PreviewConfig is used to configure the preview pane
+type PreviewConfig struct {
+	// Command is run via the shell for the current line, and its output
+	// is displayed in the preview pane. {} is replaced with the line
+	Command string `json:"Command"`
+	// Position is either "right" (default) or "bottom"
+	Position string `json:"Position"`
+	// Size is the percentage of the screen used by the preview pane
+	Size int `json:"Size"`
+	// Hidden makes the preview pane hidden until peco.TogglePreview is
+	// invoked
+	Hidden bool `json:"Hidden"`
 }
 
 type SingleKeyJumpConfig struct {
@@ -329,6 +617,23 @@ type CustomFilterConfig struct {
 	// more often, but you pay the penalty of invoking that command
 	// more times.
 	BufferThreshold int
+
+	// Persistent makes peco start the command only once, and send it
+	// queries over its stdin, instead of invoking it for every query.
+	// See the README for the protocol
+	Persistent bool
+
+	// LineID makes peco prefix each line sent to the command with the
+	// line's ID and a tab. The command must then print the ID at the
+	// beginning of each matching line, optionally followed by a tab and
+	// anything else. Otherwise, peco maps the lines printed by the
+	// command back to the input by comparing the text
+	LineID bool
+
+	// Timeout is the number of milliseconds that the command may take
+	// to process a batch of lines. If it takes longer, it is considered
+	// to have failed. The default is to wait indefinitely
+	Timeout int
 }
 
 // StyleSet holds styles for various sections
@@ -338,6 +643,7 @@ type StyleSet struct {
 	Selected       Style `json:"Selected"`
 	Query          Style `json:"Query"`
 	Matched        Style `json:"Matched"`
+	Origin         Style `json:"Origin"`
 }
 
 // Style describes termbox styles
@@ -369,22 +675,67 @@ type Query struct {
 
 type FilterQuery Query
 
+// DefaultHistorySize is the number of queries kept in the history for
+// each context, unless specified otherwise
+const DefaultHistorySize = 1000
+
+// History keeps the queries that were finished in the past, so that
+// they can be loaded into the query again
+type History struct {
+	mutex      sync.Mutex
+	path       string
+	context    string
+	size       int
+	entries    []string // queries for our context, oldest first
+	index      int      // entry being navigated, len(entries) if none
+	navigating bool
+	saved      string // query from before we started navigating
+	loaded     string // query that we last loaded
+}
+
+// historyEntry is a single line in the history file
+type historyEntry struct {
+	Context string `json:"context,omitempty"`
+	Query   string `json:"query"`
+}
+
 // Source implements pipeline.Source, and is the buffer for the input
 type Source struct {
 	pipeline.ChanOutput
 
 	capacity   int
 	enableSep  bool
+	fields     *line.Fields
 	idgen      line.IDGenerator
-	in         io.Reader
+	inputs     []sourceInput
 	inClosed   bool
 	isInfinite bool
+	json       *line.JSONFormat // nil unless the input is JSON Lines
 	lines      []line.Line
 	name       string
 	mutex      sync.RWMutex
 	ready      chan struct{}
 	setupDone  chan struct{}
 	setupOnce  sync.Once
+	trimmed    int // number of lines removed to stay within capacity
+}
+
+// followFile reads a file like `tail -F`. Instead of stopping at the
+// end of the file, it waits for more data to be written
+type followFile struct {
+	ctx      context.Context
+	interval time.Duration // how often the file is checked for more data
+	mutex    sync.Mutex
+	path     string
+	file     *os.File
+	offset   int64
+}
+
+// sourceInput is one of the readers that a Source reads lines from.
+// The name is recorded as the origin of the lines
+type sourceInput struct {
+	name string
+	in   io.Reader
 }
 
 type State interface {
@@ -400,22 +751,49 @@ type State interface {
 }
 
 type CLIOptions struct {
-	OptHelp            bool   `short:"h" long:"help" description:"show this help message and exit"`
-	OptQuery           string `long:"query" description:"initial value for query"`
-	OptRcfile          string `long:"rcfile" description:"path to the settings file"`
-	OptVersion         bool   `long:"version" description:"print the version and exit"`
-	OptBufferSize      int    `long:"buffer-size" short:"b" description:"number of lines to keep in search buffer"`
-	OptEnableNullSep   bool   `long:"null" description:"expect NUL (\\0) as separator for target/output"`
-	OptInitialIndex    int    `long:"initial-index" description:"position of the initial index of the selection (0 base)"`
-	OptInitialMatcher  string `long:"initial-matcher" description:"specify the default matcher (deprecated)"`
-	OptInitialFilter   string `long:"initial-filter" description:"specify the default filter"`
-	OptPrompt          string `long:"prompt" description:"specify the prompt string"`
-	OptLayout          string `long:"layout" description:"layout to be used. 'top-down' or 'bottom-up'. default is 'top-down'"`
-	OptSelect1         bool   `long:"select-1" description:"select first item and immediately exit if the input contains only 1 item"`
-	OptOnCancel        string `long:"on-cancel" description:"specify action on user cancel. 'success' or 'error'.\ndefault is 'success'. This may change in future versions"`
-	OptSelectionPrefix string `long:"selection-prefix" description:"use a prefix instead of changing line color to indicate currently selected lines.\ndefault is to use colors. This option is experimental"`
-	OptExec            string `long:"exec" description:"execute command instead of finishing/terminating peco.\nPlease note that this command will receive selected line(s) from stdin,\nand will be executed via '/bin/sh -c' or 'cmd /c'"`
-	OptPrintQuery      bool   `long:"print-query" descritpion:"print out the current query as first line of output"`
+	OptHelp            bool    `short:"h" long:"help" description:"show this help message and exit"`
+	OptQuery           string  `long:"query" description:"initial value for query"`
+	OptRcfile          string  `long:"rcfile" description:"path to the settings file"`
+	OptVersion         bool    `long:"version" description:"print the version and exit"`
+	OptBufferSize      int     `long:"buffer-size" short:"b" description:"number of lines to keep in search buffer"`
+	OptEnableNullSep   bool    `long:"null" description:"expect NUL (\\0) as separator for target/output"`
+	OptInitialIndex    int     `long:"initial-index" description:"position of the initial index of the selection (0 base)"`
+	OptInitialMatcher  string  `long:"initial-matcher" description:"specify the default matcher (deprecated)"`
+	OptInitialFilter   string  `long:"initial-filter" description:"specify the default filter"`
+	OptPrompt          string  `long:"prompt" description:"specify the prompt string"`
+	OptLayout          string  `long:"layout" description:"layout to be used. 'top-down' or 'bottom-up'. default is 'top-down'"`
+	OptSelect1         bool    `long:"select-1" description:"select first item and immediately exit if the input contains only 1 item"`
+	OptOnCancel        string  `long:"on-cancel" description:"specify action on user cancel. 'success' or 'error'.\ndefault is 'success'. This may change in future versions"`
+	OptSelectionPrefix string  `long:"selection-prefix" description:"use a prefix instead of changing line color to indicate currently selected lines.\ndefault is to use colors. This option is experimental"`
+	OptExec            string  `long:"exec" description:"execute command instead of finishing/terminating peco.\nPlease note that this command will receive selected line(s) from stdin,\nand will be executed via '/bin/sh -c' or 'cmd /c'"`
+	OptPrintQuery      bool    `long:"print-query" descritpion:"print out the current query as first line of output"`
+	OptDelimiter       string  `long:"delimiter" description:"regular expression used to split lines into fields.\ndefault is to split on white space"`
+	OptNth             string  `long:"nth" description:"comma separated list of fields to match against (e.g. '1', '2..', '-1', '1..3')"`
+	OptWithNth         string  `long:"with-nth" description:"comma separated list of fields to display. Uses the same syntax as --nth"`
+	OptOutputNth       string  `long:"output-nth" description:"comma separated list of fields to output. Uses the same syntax as --nth"`
+	OptFilter          *string `long:"filter" description:"print the lines matching the query using the initial filter, and exit without\nstarting the interactive interface. Exits with status 2 if no lines matched"`
+	OptPrintIndices    bool    `long:"print-indices" description:"with --filter, print the matched regions of each line after a tab"`
+	OptPreview         string  `long:"preview" description:"command to preview the current line with. {} is replaced with the line"`
+	OptPreviewPosition string  `long:"preview-position" description:"position of the preview pane. 'right' or 'bottom'. default is 'right'"`
+	OptANSI            bool    `long:"ansi" description:"display the colors specified by ANSI escape sequences in the input"`
+	OptHistory         string  `long:"history" description:"file to keep the history of queries in. default is $XDG_DATA_HOME/peco/history"`
+	OptHistoryContext  string  `long:"history-context" description:"name used to keep the history of queries separate from other uses of peco"`
+	OptMouse           bool    `long:"mouse" description:"enable mouse support"`
+	OptVim             bool    `long:"vim" description:"edit the query using vim-style insert and normal modes"`
+	OptHeight          string  `long:"height" description:"display peco below the cursor using N lines, or N% of the terminal,\ninstead of using the whole screen (e.g. '20', '40%')"`
+	OptSourceCommand   string  `long:"source-command" description:"command to read the input from. The command is run again to reload the input"`
+	OptDynamicSource   string  `long:"dynamic-source" description:"command to read the input from, which is run again whenever the query changes.\n{q} is replaced with the query"`
+	OptJSON            bool    `long:"json" description:"read the input as JSON Lines, one JSON record per line"`
+	OptJSONDisplay     string  `long:"json-display" description:"with --json, field path or template to display (e.g. '.name', '{{.name}} {{.status}}')"`
+	OptJSONMatch       string  `long:"json-match" description:"with --json, field path or template to match against. default is what is displayed"`
+	OptJSONOutput      string  `long:"json-output" description:"with --json, field path or template to output. default is the record"`
+	OptOutput          string  `long:"output" description:"format of the output. 'text' or 'json'. default is 'text'"`
+	OptOutputFormat    string  `long:"output-format" description:"template used to print each selected line (e.g. '{{.Index}} {{.Line}}')"`
+	OptShowOrigin      bool    `long:"show-origin" description:"display the file and line number that each line was read from"`
+	OptPrintOrigin     bool    `long:"print-origin" description:"print the file and line number that each selected line was read from,\nas 'filename:lineno:line'"`
+	OptFollow          bool    `long:"follow" description:"keep reading the input files as they grow, like 'tail -F'"`
+	OptExpect          string  `long:"expect" description:"comma separated list of keys that also finish peco (e.g. 'C-o,C-x').\nThe key that finished peco is printed as the first line of output"`
+	OptReloadInterval  string  `long:"reload-interval" description:"with --source-command, reload the input periodically (e.g. '5s', '1m')"`
 }
 
 type CLI struct {
@@ -432,6 +810,7 @@ type Buffer interface {
 	linesInRange(int, int) []line.Line
 	LineAt(int) (line.Line, error)
 	Size() int
+	Trimmed() int // number of lines removed to stay within the capacity
 }
 
 // MemoryBuffer is an implementation of Buffer
@@ -440,6 +819,14 @@ type MemoryBuffer struct {
 	lines        []line.Line
 	mutex        sync.RWMutex
 	PeriodicFunc func()
+	rankByScore  bool // sort lines by line.Scorer once all lines are received
+	capacity     int  // number of lines to keep, or 0 to keep all lines
+	trimmed      int  // number of lines removed to stay within capacity
+	received     int  // number of lines received, including removed ones
+
+	// rankIncrementally keeps the lines sorted as they are received,
+	// for inputs that never end
+	rankIncrementally bool
 }
 
 type ActionMap interface {
@@ -447,11 +834,55 @@ type ActionMap interface {
 }
 
 type Input struct {
-	actions ActionMap
-	evsrc   chan termbox.Event
-	mod     *time.Timer
-	mutex   sync.Mutex
-	state   *Peco
+	actions       ActionMap
+	evsrc         chan termbox.Event
+	lastClick     time.Time // when the last click on a line happened
+	lastClickLine int       // line that was clicked last
+	mod           *time.Timer
+	mutex         sync.Mutex
+	state         *Peco
+	view          *View // used to find what is displayed where the mouse is
+}
+
+// mouseTarget describes what is displayed at the position of a mouse
+// event
+type mouseTarget int
+
+const (
+	mouseTargetNone mouseTarget = iota
+	mouseTargetPrompt
+	mouseTargetList
+	mouseTargetPreview
+)
+
+// doubleClickInterval is the maximum time between two clicks on the same
+// line for them to be treated as a double click
+const doubleClickInterval = 400 * time.Millisecond
+
+// modMouseShift is set on mouse events when Shift was held down. termbox
+// does not report this, but InlineScreen does when the terminal does
+const modMouseShift termbox.Modifier = 1 << 7
+
+// executeMode describes what peco does with a command run by one of the
+// peco.Execute actions
+type executeMode int
+
+const (
+	executeInteractive executeMode = iota // run with the terminal, while the screen is suspended
+	executeSilent                         // run in the background, discarding the output
+	executeReload                         // replace the input with the output
+	executeStatus                         // display the output in the status bar
+)
+
+// Vim holds the state of the vim-style modal editing of the query
+type Vim struct {
+	mutex    sync.Mutex
+	mode     string
+	count    int    // count typed so far, 0 if none
+	operator rune   // pending operator ('d', 'c', or 'y'), 0 if none
+	opCount  int    // count typed before the operator, 0 if none
+	finding  bool   // true if 'f' is waiting for a character
+	register []rune // text that was last deleted or yanked
 }
 
 // MessageHub is the interface that must be satisfied by the
@@ -472,6 +903,8 @@ type MessageHub interface {
 }
 
 type filterProcessor struct {
+	err    error // first error returned by the filter
 	filter filter.Filter
+	mutex  sync.Mutex
 	query  string
 }
@@ -2,7 +2,10 @@
 
 package util
 
-import "os/exec"
+import (
+	"os/exec"
+	"strings"
+)
 
 func Shell(cmd ...string) *exec.Cmd {
 	const shellpath = `/bin/sh`
@@ -16,3 +19,8 @@ func Shell(cmd ...string) *exec.Cmd {
 	
 	return exec.Command(shellpath, args...)
 }
+
+// ShellQuote quotes s so that /bin/sh treats it as a single word
+func ShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
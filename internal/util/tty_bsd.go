@@ -7,6 +7,11 @@ import (
 	"unsafe"
 )
 
+const (
+	ioctlGetTermios = syscall.TIOCGETA
+	ioctlSetTermios = syscall.TIOCSETA
+)
+
 // IsTty checks if the given fd is a tty
 func IsTty(arg interface{}) bool {
 	fdsrc, ok := arg.(fder)
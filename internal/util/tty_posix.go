@@ -7,6 +7,11 @@ import (
 	"unsafe"
 )
 
+const (
+	ioctlGetTermios = syscall.TCGETS
+	ioctlSetTermios = syscall.TCSETS
+)
+
 // IsTty checks if the given fd is a tty
 func IsTty(arg interface{}) bool {
 	fdsrc, ok := arg.(fder)
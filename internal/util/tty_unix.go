@@ -0,0 +1,67 @@
+// +build !windows
+
+package util
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// MakeRaw puts the terminal referred to by fd into raw mode, so that
+// input is available as soon as it is typed, without being echoed.
+// Reads return after a tenth of a second even if there is no input.
+// The returned function restores the previous state of the terminal
+func MakeRaw(fd uintptr) (func() error, error) {
+	var orig syscall.Termios
+	if err := ioctl(fd, ioctlGetTermios, unsafe.Pointer(&orig)); err != nil {
+		return nil, errors.Wrap(err, "failed to get terminal attributes")
+	}
+
+	raw := orig
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 0
+	raw.Cc[syscall.VTIME] = 1
+	if err := ioctl(fd, ioctlSetTermios, unsafe.Pointer(&raw)); err != nil {
+		return nil, errors.Wrap(err, "failed to set terminal attributes")
+	}
+
+	return func() error {
+		return errors.Wrap(ioctl(fd, ioctlSetTermios, unsafe.Pointer(&orig)), "failed to restore terminal attributes")
+	}, nil
+}
+
+// TtySize returns the number of columns and rows of the terminal
+// referred to by fd
+func TtySize(fd uintptr) (int, int, error) {
+	var ws struct {
+		row, col, xpixel, ypixel uint16
+	}
+	if err := ioctl(fd, syscall.TIOCGWINSZ, unsafe.Pointer(&ws)); err != nil {
+		return 0, 0, errors.Wrap(err, "failed to get terminal size")
+	}
+	return int(ws.col), int(ws.row), nil
+}
+
+// OpenTty opens the terminal, so that commands can interact with the
+// user even if the standard input and output are redirected. The
+// returned files must be closed by the caller
+func OpenTty() (*os.File, *os.File, error) {
+	f, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to open /dev/tty")
+	}
+	return f, f, nil
+}
+
+func ioctl(fd, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
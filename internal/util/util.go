@@ -26,13 +26,19 @@ func ContainsUpper(query string) bool {
 }
 
 // Global var used to strips ansi sequences
-var reANSIEscapeChars = regexp.MustCompile("\x1B\\[(?:[0-9]{1,2}(?:;[0-9]{1,2})?)*[a-zA-Z]")
+var reANSIEscapeChars = regexp.MustCompile("\x1B\\[[0-9;:?]*[a-zA-Z]")
 
 // Function who strips ansi sequences
 func StripANSISequence(s string) string {
 	return reANSIEscapeChars.ReplaceAllString(s, "")
 }
 
+// ANSISequenceIndices returns the locations of the ansi sequences that
+// StripANSISequence removes from s
+func ANSISequenceIndices(s string) [][]int {
+	return reANSIEscapeChars.FindAllStringIndex(s, -1)
+}
+
 type causer interface {
 	Cause() error
 }
@@ -12,10 +12,11 @@ import (
 )
 
 // NewKeymap creates a new Keymap struct
-func NewKeymap(config map[string]string, actions map[string][]string) Keymap {
+func NewKeymap(config map[string]string, actions map[string][]string, modes map[string]map[string]string) Keymap {
 	return Keymap{
 		Config: config,
 		Action: actions,
+		Modes:  modes,
 		seq:    keyseq.New(),
 	}
 }
@@ -26,13 +27,30 @@ func (km Keymap) Sequence() Keyseq {
 
 const isTopLevelActionCall = "peco.isTopLevelActionCall"
 
+// These are the names of the modes that have their own key bindings,
+// in addition to the modes of the vim-style editing
+const (
+	keymapRangeMode         = "range"
+	keymapSingleKeyJumpMode = "single-key-jump"
+	keymapPreviewMode       = "preview"
+)
+
 func (km Keymap) ExecuteAction(ctx context.Context, state *Peco, ev termbox.Event) (err error) {
 	if pdebug.Enabled {
 		g := pdebug.Marker("Keymap.ExecuteAction %v", ev).BindError(&err)
 		defer g.End()
 	}
 
-	a := km.LookupAction(ev)
+	var a Action
+	if v := state.vim; v != nil && v.takeFind() {
+		// The key is the target of a pending 'f', not a command
+		a = ActionFunc(doVimFindTarget)
+	} else if state.isExpectedKey(eventToKey(ev).String()) {
+		// The expected keys finish peco, whatever they are bound to
+		a = ActionFunc(doFinish)
+	} else {
+		a = km.LookupModeAction(state.keymapModes(), ev)
+	}
 	if a == nil {
 		return errors.New("action not found")
 	}
@@ -42,19 +60,33 @@ func (km Keymap) ExecuteAction(ctx context.Context, state *Peco, ev termbox.Even
 	return nil
 }
 
-// LookupAction returns the appropriate action for the given termbox event
-func (km Keymap) LookupAction(ev termbox.Event) Action {
-	modifier := keyseq.ModNone
-	if (ev.Mod & termbox.ModAlt) != 0 {
-		modifier = keyseq.ModAlt
-	}
+// LookupModeAction returns the appropriate action for the given termbox
+// event while the given modes are active. The key bindings of each mode
+// are looked up in order, and then the global key bindings
+func (km Keymap) LookupModeAction(modes []string, ev termbox.Event) Action {
+	for _, mode := range modes {
+		seq, ok := km.modes[mode]
+		if !ok {
+			continue
+		}
 
-	key := keyseq.Key{
-		Modifier: modifier,
-		Key:      ev.Key,
-		Ch:       ev.Ch,
+		action, err := seq.AcceptKey(eventToKey(ev))
+		switch err {
+		case nil:
+			if pdebug.Enabled {
+				pdebug.Printf("Keymap.Handler: Fetched action for mode %s", mode)
+			}
+			return wrapClearSequence(action.(Action))
+		case keyseq.ErrInSequence:
+			return wrapRememberSequence(ActionFunc(doNothing))
+		}
 	}
-	action, err := km.seq.AcceptKey(key)
+	return km.LookupAction(ev)
+}
+
+// LookupAction returns the appropriate action for the given termbox event
+func (km Keymap) LookupAction(ev termbox.Event) Action {
+	action, err := km.seq.AcceptKey(eventToKey(ev))
 
 	switch err {
 	case nil:
@@ -76,6 +108,19 @@ func (km Keymap) LookupAction(ev termbox.Event) Action {
 	}
 }
 
+func eventToKey(ev termbox.Event) keyseq.Key {
+	modifier := keyseq.ModNone
+	if (ev.Mod & termbox.ModAlt) != 0 {
+		modifier = keyseq.ModAlt
+	}
+
+	return keyseq.Key{
+		Modifier: modifier,
+		Key:      ev.Key,
+		Ch:       ev.Ch,
+	}
+}
+
 func wrapRememberSequence(a Action) Action {
 	return ActionFunc(func(ctx context.Context, state *Peco, ev termbox.Event) {
 		if s, err := keyseq.EventToString(ev); err == nil {
@@ -133,23 +178,51 @@ func (km Keymap) resolveActionName(name string, depth int) (Action, error) {
 		return v, nil
 	}
 
+	// Can it be resolved as a command to execute?
+	if v, ok := parseExecuteAction(name); ok {
+		return v, nil
+	}
+
 	return nil, errors.Errorf("could not resolve %s: no such action", name)
 }
 
 // ApplyKeybinding applies all of the custom key bindings on top of
 // the default key bindings
 func (km *Keymap) ApplyKeybinding() error {
-	k := km.seq
+	if err := km.applyKeybinding(km.seq, defaultKeyBinding, km.Config); err != nil {
+		return err
+	}
+
+	for mode := range km.Modes {
+		if _, ok := defaultModeKeyBinding[mode]; !ok {
+			return errors.Errorf("unknown keymap mode %s", mode)
+		}
+	}
+
+	km.modes = map[string]Keyseq{}
+	for mode, defaults := range defaultModeKeyBinding {
+		k := keyseq.New()
+		if err := km.applyKeybinding(k, defaults, km.Modes[mode]); err != nil {
+			return errors.Wrapf(err, "failed to apply key bindings for mode %s", mode)
+		}
+		km.modes[mode] = k
+	}
+	return nil
+}
+
+// applyKeybinding compiles the custom key bindings in config on top of
+// the default key bindings into k
+func (km *Keymap) applyKeybinding(k Keyseq, defaults map[string]Action, config map[string]string) error {
 	k.Clear()
 
 	// Copy the map
 	kb := map[string]Action{}
-	for s, a := range defaultKeyBinding {
+	for s, a := range defaults {
 		kb[s] = a
 	}
 
 	// munge the map using config
-	for s, as := range km.Config {
+	for s, as := range config {
 		if as == "-" {
 			delete(kb, s)
 			continue
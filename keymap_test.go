@@ -0,0 +1,87 @@
+package peco
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nsf/termbox-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModeKeymap(t *testing.T) {
+	km := NewKeymap(nil, nil, map[string]map[string]string{
+		vimNormalMode: {"H": "peco.VimBeginningOfLine", "x": "-"},
+	})
+	if !assert.NoError(t, km.ApplyKeybinding(), "ApplyKeybinding should succeed") {
+		return
+	}
+
+	lookup := func(mode string, r rune) (interface{}, error) {
+		return km.modes[mode].AcceptKey(eventToKey(termbox.Event{Ch: r}))
+	}
+	_, err := lookup(vimNormalMode, 'H')
+	assert.NoError(t, err, "H should be bound in normal mode")
+	_, err = lookup(vimNormalMode, 'x')
+	assert.Error(t, err, "x should be unbound in normal mode")
+	_, err = lookup(vimNormalMode, 'w')
+	assert.NoError(t, err, "w should still be bound in normal mode")
+	_, err = lookup(vimInsertMode, 'H')
+	assert.Error(t, err, "H should not be bound in insert mode")
+
+	km = NewKeymap(nil, nil, map[string]map[string]string{"no-such-mode": {}})
+	assert.Error(t, km.ApplyKeybinding(), "unknown modes should be rejected")
+}
+
+func TestKeymapModes(t *testing.T) {
+	state := newPeco()
+	state.Argv = []string{"peco", "--vim", "--preview", "echo {}", state.Argv[1]}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go state.Run(ctx)
+
+	<-state.Ready()
+
+	assert.Equal(t, []string{vimInsertMode, keymapPreviewMode}, state.keymapModes(), "vim and preview modes should be active")
+
+	state.SelectionRangeStart().SetValue(0)
+	state.SetSingleKeyJumpMode(true)
+	assert.Equal(t, []string{keymapSingleKeyJumpMode, keymapRangeMode, vimInsertMode, keymapPreviewMode}, state.keymapModes(), "modes should be in order of precedence")
+	state.SetSingleKeyJumpMode(false)
+
+	// Esc cancels range mode, instead of switching to normal mode
+	esc := termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEsc}
+	state.Keymap().ExecuteAction(ctx, state, esc)
+	assert.False(t, state.SelectionRangeStart().Valid(), "range mode should be canceled")
+	assert.Equal(t, vimInsertMode, state.vim.Mode(), "mode should not change")
+
+	state.Keymap().ExecuteAction(ctx, state, esc)
+	assert.Equal(t, vimNormalMode, state.vim.Mode(), "Esc should switch to normal mode")
+	assert.NoError(t, state.Err(), "peco should not exit")
+}
+
+func TestCancelRangeMode(t *testing.T) {
+	state := newPeco()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go state.Run(ctx)
+
+	<-state.Ready()
+
+	// Cancelling range mode is an ordinary binding, which can be moved
+	state.config.ModeKeymap = map[string]map[string]string{
+		keymapRangeMode: {"Esc": "-", "C-g": "peco.CancelRangeMode"},
+	}
+	if !assert.NoError(t, state.populateKeymap(), "populateKeymap expected to succeed") {
+		return
+	}
+
+	state.SelectionRangeStart().SetValue(0)
+	state.Keymap().ExecuteAction(ctx, state, termbox.Event{Type: termbox.EventKey, Key: termbox.KeyCtrlG})
+	assert.False(t, state.SelectionRangeStart().Valid(), "range mode should be canceled")
+	assert.NoError(t, state.Err(), "peco should not exit")
+
+	// Without the binding, Esc runs peco.Cancel even in range mode
+	state.SelectionRangeStart().SetValue(0)
+	state.Keymap().ExecuteAction(ctx, state, termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEsc})
+	assert.Error(t, state.Err(), "peco should exit")
+}
@@ -2,6 +2,7 @@ package peco
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -80,6 +81,16 @@ func NewUserPrompt(screen Screen, anchor VerticalAnchor, anchorOffset int, promp
 	}
 }
 
+// newUserPrompt creates the UserPrompt for the layouts, making room for
+// the mode indicator if vim-style editing is enabled
+func newUserPrompt(state *Peco, anchor VerticalAnchor, anchorOffset int) *UserPrompt {
+	u := NewUserPrompt(state.Screen(), anchor, anchorOffset, state.Prompt(), state.Styles())
+	if state.vim != nil {
+		u.promptLen += vimIndicatorWidth
+	}
+	return u
+}
+
 // Draw draws the query prompt
 func (u UserPrompt) Draw(state *Peco) {
 	if pdebug.Enabled {
@@ -89,8 +100,19 @@ func (u UserPrompt) Draw(state *Peco) {
 
 	location := u.AnchorPosition()
 
-	// print "QUERY>"
+	// print "QUERY>", after the mode if vim-style editing is enabled
+	var promptX int
+	if v := state.vim; v != nil {
+		u.screen.Print(PrintArgs{
+			Y:   location,
+			Fg:  u.styles.Basic.fg | termbox.AttrBold,
+			Bg:  u.styles.Basic.bg,
+			Msg: v.Indicator(),
+		})
+		promptX = vimIndicatorWidth
+	}
 	u.screen.Print(PrintArgs{
+		X:   promptX,
 		Y:   location,
 		Fg:  u.styles.Basic.fg,
 		Bg:  u.styles.Basic.bg,
@@ -310,6 +332,24 @@ func (l *ListArea) SetDirty(dirty bool) {
 	l.dirty = dirty
 }
 
+// Width returns the number of columns that the list area may use
+func (l *ListArea) Width() int {
+	if l.width > 0 {
+		return l.width
+	}
+	width, _ := l.screen.Size()
+	return width
+}
+
+// SetWidth sets the number of columns that the list area may use.
+// Zero means the entire width of the screen
+func (l *ListArea) SetWidth(width int) {
+	if l.width != width {
+		l.width = width
+		l.SetDirty(true)
+	}
+}
+
 func selectionContains(state *Peco, n int) bool {
 	if l, err := state.CurrentLineBuffer().LineAt(n); err == nil {
 		return state.Selection().Has(l)
@@ -376,7 +416,7 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 	// The max column size is calculated by buf. we check against where the
 	// loc variable thinks we should be scrolling to, and make sure that this
 	// falls in range with what we got
-	width, _ := state.screen.Size()
+	width := l.Width()
 	if max := maxOf(buf.MaxColumn()-width, 0); loc.Column() > max {
 		loc.SetColumn(max)
 	}
@@ -412,6 +452,7 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 			Fg:   l.styles.Basic.fg,
 			Bg:   l.styles.Basic.bg,
 			Fill: true,
+			MaxX: width,
 		})
 	}
 
@@ -487,6 +528,7 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 				X:       x,
 				Y:       y,
 				XOffset: xOffset,
+				MaxX:    width,
 				Fg:      fgAttr,
 				Bg:      bgAttr,
 				Msg:     prefix,
@@ -500,6 +542,7 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 					X:       x,
 					Y:       y,
 					XOffset: xOffset,
+					MaxX:    width,
 					Fg:      fgAttr | termbox.AttrBold | termbox.AttrReverse,
 					Bg:      bgAttr,
 					Msg:     string(prefixes[n]),
@@ -508,6 +551,7 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 					X:       x + 1,
 					Y:       y,
 					XOffset: xOffset,
+					MaxX:    width,
 					Fg:      fgAttr,
 					Bg:      bgAttr,
 					Msg:     " ",
@@ -517,6 +561,7 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 					X:       x,
 					Y:       y,
 					XOffset: xOffset,
+					MaxX:    width,
 					Fg:      fgAttr,
 					Bg:      bgAttr,
 					Msg:     "  ",
@@ -525,13 +570,41 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 
 			x += 2
 		}
+		if state.showOrigin {
+			if origin := originPrefix(target); origin != "" {
+				x += l.screen.Print(PrintArgs{
+					X:       x,
+					Y:       y,
+					XOffset: xOffset,
+					MaxX:    width,
+					Fg:      l.styles.Origin.fg,
+					Bg:      mergeAttribute(bgAttr, l.styles.Origin.bg),
+					Msg:     origin,
+				})
+			}
+		}
 
-		ix, ok := target.(MatchIndexer)
-		if !ok {
+		var matches [][]int
+		if ix, ok := target.(MatchIndexer); ok {
+			matches = ix.Indices()
+		}
+
+		if state.ansi {
+			if runs := ansiRuns(target); len(runs) > 0 {
+				highlighted := len(selectionPrefix) == 0 && (n+loc.Offset() == loc.LineNumber() || selectionContains(state, n+loc.Offset()))
+				l.drawANSILine(x, y, xOffset, width, line, runs, matches, fgAttr, bgAttr, highlighted, state.Use256Color())
+				continue
+			}
+		}
+
+		// Lines may be matched without having anything to highlight,
+		// e.g. when the query only consists of inverse terms
+		if len(matches) == 0 {
 			l.screen.Print(PrintArgs{
 				X:       x,
 				Y:       y,
 				XOffset: xOffset,
+				MaxX:    width,
 				Fg:      fgAttr,
 				Bg:      bgAttr,
 				Msg:     line,
@@ -540,7 +613,6 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 			continue
 		}
 
-		matches := ix.Indices()
 		prev := x
 		index := 0
 
@@ -551,6 +623,7 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 					X:       prev,
 					Y:       y,
 					XOffset: xOffset,
+					MaxX:    width,
 					Fg:      fgAttr,
 					Bg:      bgAttr,
 					Msg:     c,
@@ -564,6 +637,7 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 				X:       prev,
 				Y:       y,
 				XOffset: xOffset,
+				MaxX:    width,
 				Fg:      l.styles.Matched.fg,
 				Bg:      mergeAttribute(bgAttr, l.styles.Matched.bg),
 				Msg:     c,
@@ -579,6 +653,7 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 				X:       prev,
 				Y:       y,
 				XOffset: xOffset,
+				MaxX:    width,
 				Fg:      l.styles.Query.fg,
 				Bg:      mergeAttribute(bgAttr, l.styles.Query.bg),
 				Msg:     line[m[0]:m[1]],
@@ -589,6 +664,7 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 				X:       prev,
 				Y:       y,
 				XOffset: xOffset,
+				MaxX:    width,
 				Fg:      fgAttr,
 				Bg:      bgAttr,
 				Msg:     line[m[1]:len(line)],
@@ -602,6 +678,93 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 	}
 }
 
+// originPrefix returns the prefix that shows where l was read from, or
+// an empty string if that is not known
+func originPrefix(l line.Line) string {
+	if origin := line.OriginOf(l).String(); origin != "" {
+		return origin + ":"
+	}
+	return ""
+}
+
+// ansiRuns returns the regions of l that were styled by escape
+// sequences in the input
+func ansiRuns(l line.Line) []line.ANSIRun {
+	if s, ok := l.(line.ANSIStyler); ok {
+		return s.ANSIRuns()
+	}
+	return nil
+}
+
+// drawANSILine draws a line using the colors specified by the escape
+// sequences in the input. Matched regions are highlighted on top of
+// these colors. If the line is highlighted as selected, the background
+// of the selection is used throughout, so that it remains visible
+func (l *ListArea) drawANSILine(x, y, xOffset, width int, text string, runs []line.ANSIRun, matches [][]int, fgAttr, bgAttr termbox.Attribute, highlighted, use256Color bool) {
+	// Split the line at every point where the attributes may change
+	bounds := []int{0, len(text)}
+	for _, r := range runs {
+		bounds = append(bounds, r.Start, r.End)
+	}
+	for _, m := range matches {
+		bounds = append(bounds, m[0], m[1])
+	}
+	sort.Ints(bounds)
+
+	prev := x
+	var ri, mi int
+	for i := 1; i < len(bounds); i++ {
+		start, end := bounds[i-1], bounds[i]
+		if start >= end || end > len(text) {
+			continue
+		}
+
+		fg, bg := fgAttr, bgAttr
+		for ri < len(runs) && runs[ri].End <= start {
+			ri++
+		}
+		if ri < len(runs) && runs[ri].Start <= start {
+			st := newANSIState(fgAttr, bgAttr, use256Color)
+			for _, c := range runs[ri].Codes {
+				st.apply(c)
+			}
+			fg, bg = st.fg, st.bg
+			if highlighted {
+				fg |= fgAttr & ansiAttrMask
+				bg = bgAttr
+			}
+		}
+
+		for mi < len(matches) && matches[mi][1] <= start {
+			mi++
+		}
+		if mi < len(matches) && matches[mi][0] <= start {
+			fg = l.styles.Matched.fg
+			bg = mergeAttribute(bg, l.styles.Matched.bg)
+		}
+
+		prev += l.screen.Print(PrintArgs{
+			X:       prev,
+			Y:       y,
+			XOffset: xOffset,
+			MaxX:    width,
+			Fg:      fg,
+			Bg:      bg,
+			Msg:     text[start:end],
+		})
+	}
+
+	l.screen.Print(PrintArgs{
+		X:       prev,
+		Y:       y,
+		XOffset: xOffset,
+		MaxX:    width,
+		Fg:      fgAttr,
+		Bg:      bgAttr,
+		Fill:    true,
+	})
+}
+
 func maxOf(a, b int) int {
 	if a > b {
 		return a
@@ -609,12 +772,197 @@ func maxOf(a, b int) int {
 	return b
 }
 
+// NewPreviewArea creates a new PreviewArea that displays the output
+// of `preview`
+func NewPreviewArea(screen Screen, preview *Preview, styles *StyleSet) *PreviewArea {
+	return &PreviewArea{
+		preview: preview,
+		screen:  screen,
+		styles:  styles,
+	}
+}
+
+// Draw displays the preview for the current line in the given area,
+// along with a border separating it from the list
+func (pa *PreviewArea) Draw(state *Peco, r previewRect) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("PreviewArea.Draw %#v", r)
+		defer g.End()
+	}
+
+	var current line.Line
+	if l, err := state.CurrentLineBuffer().LineAt(state.Location().LineNumber()); err == nil {
+		current = l
+	}
+	pa.preview.Update(state, current)
+
+	fg := pa.styles.Basic.fg
+	bg := pa.styles.Basic.bg
+	switch pa.preview.Position() {
+	case PreviewPositionBottom:
+		y := r.y - 1
+		if r.y == 0 {
+			y = r.y + r.height
+		}
+		for x := r.x; x < r.x+r.width; x++ {
+			pa.screen.SetCell(x, y, '─', fg, bg)
+		}
+	default:
+		for y := r.y; y < r.y+r.height; y++ {
+			pa.screen.SetCell(r.x-1, y, '│', fg, bg)
+		}
+	}
+
+	lines := pa.preview.Lines()
+	for i := 0; i < r.height; i++ {
+		var segments []ansiSegment
+		if i < len(lines) {
+			segments = lines[i]
+		}
+		pa.drawLine(r.x, r.y+i, r.width, segments)
+	}
+}
+
+// drawLine draws the segments, clipped to the width of the pane. The
+// rest of the line is cleared
+func (pa *PreviewArea) drawLine(x0, y, width int, segments []ansiSegment) {
+	x := x0
+OUTER:
+	for _, seg := range segments {
+		for _, c := range seg.text {
+			if c == '\t' {
+				for n := 4 - (x-x0)%4; n > 0 && x < x0+width; n-- {
+					pa.screen.SetCell(x, y, ' ', seg.fg, seg.bg)
+					x++
+				}
+				continue
+			}
+
+			w := runewidth.RuneWidth(c)
+			if w == 0 {
+				continue
+			}
+			if x+w > x0+width {
+				break OUTER
+			}
+			pa.screen.SetCell(x, y, c, seg.fg, seg.bg)
+			x += w
+		}
+	}
+
+	for ; x < x0+width; x++ {
+		pa.screen.SetCell(x, y, ' ', pa.styles.Basic.fg, pa.styles.Basic.bg)
+	}
+}
+
+// previewRect returns the area to draw the preview pane in, excluding
+// the border. Returns false if the pane should not be displayed
+func (l *BasicLayout) previewRect() (previewRect, bool) {
+	if l.preview == nil || !l.preview.preview.Visible() {
+		return previewRect{}, false
+	}
+
+	width, height := l.screen.Size()
+	available := height - 2 - extraOffset
+	pv := l.preview.preview
+
+	var r previewRect
+	switch pv.Position() {
+	case PreviewPositionBottom:
+		// The border takes up one line, and the list needs at least one
+		paneHeight := available * pv.Size() / 100
+		if paneHeight > available-1 {
+			paneHeight = available - 1
+		}
+		if paneHeight < 2 {
+			return previewRect{}, false
+		}
+		r.x = 0
+		r.width = width
+		r.height = paneHeight - 1
+		if l.list.sortTopDown {
+			r.y = 1 + available - r.height
+		} else {
+			r.y = 0
+		}
+	default:
+		paneWidth := width * pv.Size() / 100
+		if paneWidth < 2 || width-paneWidth < 1 {
+			return previewRect{}, false
+		}
+		r.x = width - paneWidth + 1
+		r.width = paneWidth - 1
+		r.height = available
+		if l.list.sortTopDown {
+			r.y = 1
+		} else {
+			r.y = 0
+		}
+	}
+	return r, true
+}
+
+// mouseTarget returns what is displayed at (x, y). For the prompt, the
+// column relative to the start of the query is also returned, and for
+// the list, the index of the line in the current page
+func (l *BasicLayout) mouseTarget(x, y int) (mouseTarget, int) {
+	if y == l.prompt.AnchorPosition() {
+		return mouseTargetPrompt, x - l.prompt.promptLen - 1
+	}
+
+	if r, ok := l.previewRect(); ok {
+		// Include the border, as it is part of the pane
+		if l.preview.preview.Position() == PreviewPositionRight && x >= r.x-1 && y >= r.y && y < r.y+r.height {
+			return mouseTargetPreview, 0
+		}
+		if l.preview.preview.Position() == PreviewPositionBottom && y >= r.y-1 && y <= r.y+r.height {
+			return mouseTargetPreview, 0
+		}
+	}
+
+	n := y - l.list.AnchorPosition()
+	if !l.list.sortTopDown {
+		n = -n
+	}
+	if n < 0 || n >= l.linesPerPage() {
+		return mouseTargetNone, 0
+	}
+	return mouseTargetList, n
+}
+
+// scrollPreview scrolls the preview pane
+func scrollPreview(l *BasicLayout, p PagingRequest) bool {
+	r, ok := l.previewRect()
+	if !ok {
+		return false
+	}
+
+	page := r.height / 2
+	if page < 1 {
+		page = 1
+	}
+
+	var n int
+	switch p.Type() {
+	case ToScrollPreviewUp:
+		n = -1
+	case ToScrollPreviewDown:
+		n = 1
+	case ToScrollPreviewPageUp:
+		n = -page
+	case ToScrollPreviewPageDown:
+		n = page
+	}
+	return l.preview.preview.Scroll(n)
+}
+
 // NewDefaultLayout creates a new Layout in the default format (top-down)
 func NewDefaultLayout(state *Peco) *BasicLayout {
 	return &BasicLayout{
+		preview:   newPreviewArea(state),
 		StatusBar: NewStatusBar(state.Screen(), AnchorBottom, 0+extraOffset, state.Styles()),
 		// The prompt is at the top
-		prompt: NewUserPrompt(state.Screen(), AnchorTop, 0, state.Prompt(), state.Styles()),
+		prompt: newUserPrompt(state, AnchorTop, 0),
 		// The list area is at the top, after the prompt
 		// It's also displayed top-to-bottom order
 		list: NewListArea(state.Screen(), AnchorTop, 1, true, state.Styles()),
@@ -624,25 +972,63 @@ func NewDefaultLayout(state *Peco) *BasicLayout {
 // NewBottomUpLayout creates a new Layout in bottom-up format
 func NewBottomUpLayout(state *Peco) *BasicLayout {
 	return &BasicLayout{
+		preview:   newPreviewArea(state),
 		StatusBar: NewStatusBar(state.Screen(), AnchorBottom, 0+extraOffset, state.Styles()),
 		// The prompt is at the bottom, above the status bar
-		prompt: NewUserPrompt(state.Screen(), AnchorBottom, 1+extraOffset, state.Prompt(), state.Styles()),
+		prompt: newUserPrompt(state, AnchorBottom, 1+extraOffset),
 		// The list area is at the bottom, above the prompt
 		// It's displayed in bottom-to-top order
 		list: NewListArea(state.Screen(), AnchorBottom, 2+extraOffset, false, state.Styles()),
 	}
 }
 
+// newPreviewArea creates the preview pane, if a preview command was
+// specified
+func newPreviewArea(state *Peco) *PreviewArea {
+	if state.preview == nil {
+		return nil
+	}
+	return NewPreviewArea(state.Screen(), state.preview, state.Styles())
+}
+
 func (l *BasicLayout) PurgeDisplayCache() {
 	l.list.purgeDisplayCache()
 }
 
+// followTrimmedLines moves the cursor up by the number of lines that
+// were removed from the start of the buffer since it was last displayed,
+// so that it stays on the same line
+func (l *BasicLayout) followTrimmedLines(state *Peco) {
+	buf := state.CurrentLineBuffer()
+	trimmed := buf.Trimmed()
+	if n := trimmed - l.lastTrimmed; buf == l.lastBuffer && n > 0 {
+		loc := state.Location()
+		if lineno := loc.LineNumber() - n; lineno > 0 {
+			loc.SetLineNumber(lineno)
+		} else {
+			loc.SetLineNumber(0)
+		}
+
+		if r := state.SelectionRangeStart(); r.Valid() {
+			if v := r.Value() - n; v > 0 {
+				r.SetValue(v)
+			} else {
+				r.SetValue(0)
+			}
+		}
+	}
+	l.lastBuffer = buf
+	l.lastTrimmed = trimmed
+}
+
 // CalculatePage calculates which page we're displaying
 func (l *BasicLayout) CalculatePage(state *Peco, perPage int) error {
 	if pdebug.Enabled {
 		g := pdebug.Marker("BasicLayout.Calculate %d", perPage)
 		defer g.End()
 	}
+	l.followTrimmedLines(state)
+
 	buf := state.CurrentLineBuffer()
 	loc := state.Location()
 	loc.SetPage((loc.LineNumber() / perPage) + 1)
@@ -679,6 +1065,13 @@ func (l *BasicLayout) DrawScreen(state *Peco, options *DrawOptions) {
 		defer g.End()
 	}
 
+	r, showPreview := l.previewRect()
+	if showPreview && l.preview.preview.Position() == PreviewPositionRight {
+		l.list.SetWidth(r.x - 1)
+	} else {
+		l.list.SetWidth(0)
+	}
+
 	perPage := l.linesPerPage()
 
 	if err := l.CalculatePage(state, perPage); err != nil {
@@ -687,6 +1080,9 @@ func (l *BasicLayout) DrawScreen(state *Peco, options *DrawOptions) {
 
 	l.DrawPrompt(state)
 	l.list.Draw(state, l, perPage, options)
+	if showPreview {
+		l.preview.Draw(state, r)
+	}
 
 	if err := l.screen.Flush(); err != nil {
 		return
@@ -698,6 +1094,10 @@ func (l *BasicLayout) linesPerPage() int {
 
 	// list area is always the display area - 2 lines for prompt and status
 	reservedLines := 2 + extraOffset
+	if r, ok := l.previewRect(); ok && l.preview.preview.Position() == PreviewPositionBottom {
+		// the preview pane and its border
+		reservedLines += r.height + 1
+	}
 	pp := height - reservedLines
 	if pp < 1 {
 		// This is an error condition, and while we probably should handle this
@@ -721,6 +1121,8 @@ func (l *BasicLayout) MovePage(state *Peco, p PagingRequest) (moved bool) {
 	switch p.Type() {
 	case ToScrollLeft, ToScrollRight:
 		moved = horizontalScroll(state, l, p)
+	case ToScrollPreviewUp, ToScrollPreviewDown, ToScrollPreviewPageUp, ToScrollPreviewPageDown:
+		moved = scrollPreview(l, p)
 	default:
 		moved = verticalScroll(state, l, p)
 	}
@@ -729,6 +1131,8 @@ func (l *BasicLayout) MovePage(state *Peco, p PagingRequest) (moved bool) {
 
 // verticalScroll moves the cursor position vertically
 func verticalScroll(state *Peco, l *BasicLayout, p PagingRequest) bool {
+	l.followTrimmedLines(state)
+
 	// Before we move, on which line were we located?
 	loc := state.Location()
 	lineBefore := loc.LineNumber()
@@ -783,7 +1187,7 @@ func verticalScroll(state *Peco, l *BasicLayout, p PagingRequest) bool {
 		case ToScrollPageUp:
 			lineno += lpp
 		case ToLineInPage:
-			lineno = loc.PerPage()*(loc.Page()-1) - p.(JumpToLineRequest).Line()
+			lineno = loc.PerPage()*(loc.Page()-1) + p.(JumpToLineRequest).Line()
 		}
 	}
 
@@ -859,7 +1263,7 @@ func verticalScroll(state *Peco, l *BasicLayout, p PagingRequest) bool {
 
 // horizontalScroll scrolls screen horizontal
 func horizontalScroll(state *Peco, l *BasicLayout, p PagingRequest) bool {
-	width, _ := state.screen.Size()
+	width := l.list.Width()
 	loc := state.Location()
 	if p.Type() == ToScrollRight {
 		loc.SetColumn(loc.Column() + width/2)
@@ -1,11 +1,16 @@
 package peco
 
 import (
+	"fmt"
 	"testing"
 	"unicode/utf8"
 
 	"github.com/mattn/go-runewidth"
 	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/filter"
+	"github.com/peco/peco/hub"
+	"github.com/peco/peco/line"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestLayoutType(t *testing.T) {
@@ -127,3 +132,196 @@ func TestMergeAttribute(t *testing.T) {
 	}
 
 }
+
+func TestListAreaANSI(t *testing.T) {
+	state := newPeco()
+	state.hub = hub.New(5)
+	state.ansi = true
+	state.styles.Init()
+	state.Filters().Add(filter.NewIgnoreCase())
+	state.SetCurrentLineBuffer(newTestMemoryBuffer(
+		line.NewRaw(0, "\x1b[32mgreen", false),
+		line.NewMatched(line.NewRaw(1, "\x1b[31mred\x1b[0m plain", false), [][]int{{4, 7}}),
+	))
+
+	screen := state.screen.(*dummyScreen)
+	l := NewDefaultLayout(state)
+	l.DrawScreen(state, nil)
+
+	cells := make(map[[2]int]interceptorArgs)
+	for _, ev := range screen.interceptor.events["SetCell"] {
+		cells[[2]int{ev[0].(int), ev[1].(int)}] = ev
+	}
+
+	styles := state.Styles()
+	testValues := []struct {
+		x, y int
+		ch   rune
+		fg   termbox.Attribute
+		bg   termbox.Attribute
+	}{
+		// The current line keeps its colors, on top of the selection
+		{0, 1, 'g', termbox.ColorGreen | termbox.AttrUnderline, styles.Selected.bg},
+		{0, 2, 'r', termbox.ColorRed, styles.Basic.bg},
+		{3, 2, ' ', styles.Basic.fg, styles.Basic.bg},
+		// Matches are highlighted over the colors from the input
+		{4, 2, 'p', styles.Matched.fg, styles.Matched.bg},
+		{7, 2, 'i', styles.Basic.fg, styles.Basic.bg},
+	}
+	for _, v := range testValues {
+		ev, ok := cells[[2]int{v.x, v.y}]
+		if !assert.True(t, ok, "cell (%d, %d) should be drawn", v.x, v.y) {
+			continue
+		}
+		assert.Equal(t, interceptorArgs{v.x, v.y, v.ch, v.fg, v.bg}, ev, "cell (%d, %d) should match", v.x, v.y)
+	}
+}
+
+func TestListAreaOrigin(t *testing.T) {
+	state := newPeco()
+	state.hub = hub.New(5)
+	state.showOrigin = true
+	state.styles.Init()
+	state.Filters().Add(filter.NewIgnoreCase())
+	rl := line.NewRaw(0, "foo", false)
+	rl.SetOrigin(line.Origin{Filename: "a.txt", Lineno: 7})
+	state.SetCurrentLineBuffer(newTestMemoryBuffer(
+		line.NewRaw(1, "bar", false),
+		line.NewMatched(rl, [][]int{{0, 1}}),
+	))
+
+	screen := state.screen.(*dummyScreen)
+	l := NewDefaultLayout(state)
+	l.DrawScreen(state, nil)
+
+	cells := make(map[[2]int]interceptorArgs)
+	for _, ev := range screen.interceptor.events["SetCell"] {
+		cells[[2]int{ev[0].(int), ev[1].(int)}] = ev
+	}
+
+	styles := state.Styles()
+	testValues := []struct {
+		x, y int
+		ch   rune
+		fg   termbox.Attribute
+		bg   termbox.Attribute
+	}{
+		// Lines without an origin are drawn as usual
+		{0, 1, 'b', styles.Selected.fg, styles.Selected.bg},
+		// The origin is drawn before the line, and the matches follow it
+		{0, 2, 'a', styles.Origin.fg, styles.Basic.bg},
+		{7, 2, ':', styles.Origin.fg, styles.Basic.bg},
+		{8, 2, 'f', styles.Matched.fg, styles.Matched.bg},
+		{9, 2, 'o', styles.Basic.fg, styles.Basic.bg},
+	}
+	for _, v := range testValues {
+		ev, ok := cells[[2]int{v.x, v.y}]
+		if !assert.True(t, ok, "cell (%d, %d) should be drawn", v.x, v.y) {
+			continue
+		}
+		assert.Equal(t, interceptorArgs{v.x, v.y, v.ch, v.fg, v.bg}, ev, "cell (%d, %d) should match", v.x, v.y)
+	}
+}
+
+func TestMouseTarget(t *testing.T) {
+	testValues := []struct {
+		name     string
+		layout   string
+		preview  string
+		x, y     int
+		target   mouseTarget
+		position int
+	}{
+		{"Prompt", LayoutTypeTopDown, "", 10, 0, mouseTargetPrompt, 3},
+		{"First line", LayoutTypeTopDown, "", 0, 1, mouseTargetList, 0},
+		{"Last line", LayoutTypeTopDown, "", 0, 8, mouseTargetList, 7},
+		{"Status bar", LayoutTypeTopDown, "", 0, 9, mouseTargetNone, 0},
+		{"Prompt (bottom-up)", LayoutTypeBottomUp, "", 10, 8, mouseTargetPrompt, 3},
+		{"First line (bottom-up)", LayoutTypeBottomUp, "", 0, 7, mouseTargetList, 0},
+		{"Last line (bottom-up)", LayoutTypeBottomUp, "", 0, 0, mouseTargetList, 7},
+		{"Line next to preview", LayoutTypeTopDown, PreviewPositionRight, 39, 1, mouseTargetList, 0},
+		{"Preview", LayoutTypeTopDown, PreviewPositionRight, 40, 1, mouseTargetPreview, 0},
+		{"Preview (bottom)", LayoutTypeTopDown, PreviewPositionBottom, 0, 6, mouseTargetPreview, 0},
+		{"Line above preview", LayoutTypeTopDown, PreviewPositionBottom, 0, 4, mouseTargetList, 3},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			state := newPeco()
+			state.layoutType = v.layout
+			if v.preview != "" {
+				state.preview = NewPreview("true", v.preview, 50, false, state.Styles(), false)
+			}
+
+			target, position := newLayout(state).mouseTarget(v.x, v.y)
+			assert.Equal(t, v.target, target, "target should match")
+			assert.Equal(t, v.position, position, "position should match")
+		})
+	}
+}
+
+func TestJumpToLineInPage(t *testing.T) {
+	for _, layoutType := range []string{LayoutTypeTopDown, LayoutTypeBottomUp} {
+		t.Run(layoutType, func(t *testing.T) {
+			state := newPeco()
+			state.hub = hub.New(5)
+			state.layoutType = layoutType
+
+			var lines []line.Line
+			for i := 0; i < 20; i++ {
+				lines = append(lines, line.NewRaw(uint64(i), fmt.Sprintf("line %d", i), false))
+			}
+			state.SetCurrentLineBuffer(newTestMemoryBuffer(lines...))
+
+			// The second page, with 8 lines per page
+			loc := state.Location()
+			loc.SetPerPage(8)
+			loc.SetPage(2)
+			loc.SetLineNumber(8)
+
+			// Lines in the page are counted from the prompt, whichever
+			// way the list is drawn
+			verticalScroll(state, newLayout(state), JumpToLineRequest(3))
+			assert.Equal(t, 11, loc.LineNumber(), "line 3 of the second page should be selected")
+		})
+	}
+}
+
+func TestFollowTrimmedLines(t *testing.T) {
+	state := newPeco()
+	state.hub = hub.New(5)
+
+	buf := NewMemoryBuffer()
+	buf.capacity = 10
+	for i := 0; i < 10; i++ {
+		buf.lines, _ = appendWithCapacity(buf.lines, line.NewRaw(uint64(i), fmt.Sprintf("line %d", i), false), buf.capacity)
+	}
+	state.SetCurrentLineBuffer(buf)
+
+	l := newLayout(state)
+	state.Location().SetLineNumber(5)
+	state.SelectionRangeStart().SetValue(1)
+	l.CalculatePage(state, 8)
+
+	// Three new lines push the three oldest lines out
+	for i := 10; i < 13; i++ {
+		var removed int
+		buf.lines, removed = appendWithCapacity(buf.lines, line.NewRaw(uint64(i), fmt.Sprintf("line %d", i), false), buf.capacity)
+		buf.trimmed += removed
+	}
+	l.CalculatePage(state, 8)
+	assert.Equal(t, 2, state.Location().LineNumber(), "the cursor should stay on the same line")
+	assert.Equal(t, 0, state.SelectionRangeStart().Value(), "the start of the range should stay within the buffer")
+
+	// Other buffers start from where the cursor is
+	state.SetCurrentLineBuffer(newTestMemoryBuffer(buf.lines...))
+	l.CalculatePage(state, 8)
+	assert.Equal(t, 2, state.Location().LineNumber(), "the cursor should not move")
+}
+
+func TestCaretPosAt(t *testing.T) {
+	assert.Equal(t, 0, caretPosAt("hello", -1), "clicks before the query move the caret to the start")
+	assert.Equal(t, 2, caretPosAt("hello", 2), "caret should be before the clicked rune")
+	assert.Equal(t, 5, caretPosAt("hello", 10), "clicks after the query move the caret to the end")
+	assert.Equal(t, 1, caretPosAt("日本語", 3), "wide runes take up two columns")
+}
@@ -0,0 +1,91 @@
+package line
+
+import (
+	"strings"
+
+	"github.com/peco/peco/internal/util"
+)
+
+// ANSIRuns returns the regions of the display string that are styled
+// by SGR escape sequences in the input, along with the parameters of
+// the sequences that apply to each region
+func (rl Raw) ANSIRuns() []ANSIRun {
+	base := rl.buf
+	if i := rl.sepLoc; i > -1 {
+		base = rl.buf[:i]
+	}
+
+	codes := sgrCodes(base)
+	if len(codes) == 0 {
+		return nil
+	}
+
+	segments := rl.displaySegments
+	if segments == nil {
+		segments = []segment{{pos: 0, orig: 0, length: len(rl.DisplayString())}}
+	}
+
+	var runs []ANSIRun
+	for _, seg := range segments {
+		var active []string
+		start := seg.orig
+		end := seg.orig + seg.length
+		for _, c := range codes {
+			if c.pos > start && c.pos < end {
+				runs = appendANSIRun(runs, seg.pos+start-seg.orig, seg.pos+c.pos-seg.orig, active)
+				start = c.pos
+			}
+			if c.pos >= end {
+				break
+			}
+			if c.params == "" || c.params == "0" {
+				// A reset makes the previous codes irrelevant
+				active = nil
+				continue
+			}
+			active = append(active[:len(active):len(active)], c.params)
+		}
+		runs = appendANSIRun(runs, seg.pos+start-seg.orig, seg.pos+seg.length, active)
+	}
+	return runs
+}
+
+// ANSIRuns implements ANSIStyler by delegating to the underlying line
+func (ml Matched) ANSIRuns() []ANSIRun {
+	if s, ok := ml.Line.(ANSIStyler); ok {
+		return s.ANSIRuns()
+	}
+	return nil
+}
+
+// appendANSIRun appends a run to runs, unless it is empty or is not
+// styled at all
+func appendANSIRun(runs []ANSIRun, start, end int, codes []string) []ANSIRun {
+	if start >= end || len(codes) == 0 {
+		return runs
+	}
+	return append(runs, ANSIRun{Start: start, End: end, Codes: codes})
+}
+
+type sgrCode struct {
+	pos    int // offset into the stripped string
+	params string
+}
+
+// sgrCodes finds the SGR sequences in s, along with their positions in
+// the string with all of the escape sequences stripped
+func sgrCodes(s string) []sgrCode {
+	var codes []sgrCode
+	stripped := 0
+	prev := 0
+	for _, loc := range util.ANSISequenceIndices(s) {
+		stripped += loc[0] - prev
+		prev = loc[1]
+		seq := s[loc[0]:loc[1]]
+		if !strings.HasSuffix(seq, "m") {
+			continue
+		}
+		codes = append(codes, sgrCode{pos: stripped, params: seq[2 : len(seq)-1]})
+	}
+	return codes
+}
@@ -0,0 +1,41 @@
+package line
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestANSIRuns(t *testing.T) {
+	testValues := []struct {
+		input    string
+		withNth  string
+		display  string
+		expected []ANSIRun
+	}{
+		{"plain", "", "plain", nil},
+		{"\x1b[31mred\x1b[0m plain", "", "red plain", []ANSIRun{{0, 3, []string{"31"}}}},
+		{"\x1b[1m\x1b[38;5;208mbold\x1b[22m orange\x1b[m", "", "bold orange", []ANSIRun{
+			{0, 4, []string{"1", "38;5;208"}},
+			{4, 11, []string{"1", "38;5;208", "22"}},
+		}},
+		{"\x1b[2Kfoo\x1b[32mbar", "", "foobar", []ANSIRun{{3, 6, []string{"32"}}}},
+		{"a \x1b[31mb\x1b[0m c", "2..", "b c", []ANSIRun{{0, 1, []string{"31"}}}},
+		{"\x1b[31ma\x1b[0m b \x1b[34mc", "1,3", "a c", []ANSIRun{
+			{0, 1, []string{"31"}},
+			{2, 3, []string{"34"}},
+		}},
+	}
+
+	for _, v := range testValues {
+		fields, err := NewFields("", "", v.withNth, "")
+		if !assert.NoError(t, err, "NewFields should succeed") {
+			continue
+		}
+		l := NewRawWithFields(1, v.input, false, fields)
+		assert.Equal(t, v.display, l.DisplayString(), "DisplayString for %q", v.input)
+		assert.Equal(t, v.expected, l.ANSIRuns(), "ANSIRuns for %q", v.input)
+		assert.Equal(t, v.expected, NewMatched(l, nil).ANSIRuns(), "Matched should delegate ANSIRuns for %q", v.input)
+		assert.Equal(t, v.input, l.Output(), "Output should keep the escape sequences")
+	}
+}
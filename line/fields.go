@@ -0,0 +1,312 @@
+package line
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// FieldRange is a range of fields, as specified by the user.
+// Fields are 1-based, and negative values count from the last field
+// (-1 is the last field). A zero Start or End means that the range is
+// open on that end
+type FieldRange struct {
+	Start int
+	End   int
+}
+
+// FieldRanges is a list of FieldRange
+type FieldRanges []FieldRange
+
+// Fields describes how lines are split into fields, and which fields
+// are used for matching, display, and output. Empty FieldRanges mean
+// that the whole line is used.
+type Fields struct {
+	// Delimiter is the regular expression used to split lines. If nil,
+	// lines are split AWK-style on runs of white space
+	Delimiter *regexp.Regexp
+	Match     FieldRanges
+	Display   FieldRanges
+	Output    FieldRanges
+}
+
+// token describes a single field in a line. Its content lives in
+// [start, content), and [content, end) holds the trailing delimiter
+type token struct {
+	start   int
+	content int
+	end     int
+}
+
+// segment describes a contiguous portion of a string composed from
+// fields: `length` bytes starting at `pos` were copied from the
+// original string at `orig`
+type segment struct {
+	pos    int
+	orig   int
+	length int
+}
+
+// NewFields parses the delimiter and field range expressions, and
+// creates a new Fields. If none of the arguments were specified,
+// nil is returned
+func NewFields(delimiter, match, display, output string) (*Fields, error) {
+	if delimiter == "" && match == "" && display == "" && output == "" {
+		return nil, nil
+	}
+
+	var f Fields
+	if delimiter != "" {
+		rx, err := regexp.Compile(delimiter)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to compile delimiter '%s'", delimiter)
+		}
+		f.Delimiter = rx
+	}
+
+	for _, v := range []struct {
+		expr string
+		dst  *FieldRanges
+	}{
+		{match, &f.Match},
+		{display, &f.Display},
+		{output, &f.Output},
+	} {
+		ranges, err := ParseFieldRanges(v.expr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse field expression '%s'", v.expr)
+		}
+		*v.dst = ranges
+	}
+	return &f, nil
+}
+
+// ParseFieldRanges parses a comma separated list of field
+// expressions. Each expression may be a field index (`N`), or a range
+// of fields (`N..M`, `N..`, `..M`, or `..`). Indices may be negative,
+// in which case they count from the last field.
+func ParseFieldRanges(s string) (FieldRanges, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var ranges FieldRanges
+	for _, expr := range strings.Split(s, ",") {
+		expr = strings.TrimSpace(expr)
+		if expr == "" {
+			return nil, errors.New("empty field expression")
+		}
+
+		var r FieldRange
+		if i := strings.Index(expr, ".."); i >= 0 {
+			var err error
+			if r.Start, err = parseFieldIndex(expr[:i], true); err != nil {
+				return nil, err
+			}
+			if r.End, err = parseFieldIndex(expr[i+2:], true); err != nil {
+				return nil, err
+			}
+		} else {
+			n, err := parseFieldIndex(expr, false)
+			if err != nil {
+				return nil, err
+			}
+			r.Start, r.End = n, n
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+func parseFieldIndex(s string, allowEmpty bool) (int, error) {
+	if s == "" && allowEmpty {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid field index '%s'", s)
+	}
+	if n == 0 {
+		return 0, errors.New("field indices start at 1")
+	}
+	return n, nil
+}
+
+// resolve converts the range into 0-based indices, given the number of
+// available fields. Returns false if the range is empty
+func (r FieldRange) resolve(n int) (int, int, bool) {
+	start, end := r.Start, r.End
+	switch {
+	case start == 0:
+		start = 1
+	case start < 0:
+		start = n + start + 1
+	}
+	switch {
+	case end == 0:
+		end = n
+	case end < 0:
+		end = n + end + 1
+	}
+
+	if start < 1 {
+		start = 1
+	}
+	if end > n {
+		end = n
+	}
+	if start > end {
+		return 0, 0, false
+	}
+	return start - 1, end - 1, true
+}
+
+// split splits s into tokens, using the configured delimiter
+func (f *Fields) split(s string) []token {
+	var tokens []token
+	if f.Delimiter == nil {
+		// AWK-style. Leading and trailing white space is ignored
+		start, content := -1, 0
+		inSpace := false
+		for i, r := range s {
+			if unicode.IsSpace(r) {
+				if start >= 0 && !inSpace {
+					content = i
+					inSpace = true
+				}
+				continue
+			}
+			if start >= 0 && inSpace {
+				tokens = append(tokens, token{start: start, content: content, end: i})
+				start = -1
+			}
+			if start < 0 {
+				start = i
+				inSpace = false
+			}
+		}
+		if start >= 0 {
+			if !inSpace {
+				content = len(s)
+			}
+			tokens = append(tokens, token{start: start, content: content, end: len(s)})
+		}
+		return tokens
+	}
+
+	start := 0
+	for _, loc := range f.Delimiter.FindAllStringIndex(s, -1) {
+		if loc[1] == 0 {
+			continue
+		}
+		tokens = append(tokens, token{start: start, content: loc[0], end: loc[1]})
+		start = loc[1]
+	}
+	if start < len(s) {
+		tokens = append(tokens, token{start: start, content: len(s), end: len(s)})
+	}
+	return tokens
+}
+
+// compose builds a new string from the fields in s that are
+// specified by ranges. The trailing delimiter of the last field is
+// not included. If no ranges are specified, s is returned as is.
+func (f *Fields) compose(s string, tokens []token, ranges FieldRanges) (string, []segment) {
+	if len(ranges) == 0 {
+		return s, []segment{{pos: 0, orig: 0, length: len(s)}}
+	}
+
+	var selected []token
+	for _, r := range ranges {
+		start, end, ok := r.resolve(len(tokens))
+		if !ok {
+			continue
+		}
+		selected = append(selected, tokens[start:end+1]...)
+	}
+
+	var buf strings.Builder
+	var segments []segment
+	for i, t := range selected {
+		end := t.end
+		if i == len(selected)-1 {
+			end = t.content
+		}
+		if end <= t.start {
+			continue
+		}
+
+		pos := buf.Len()
+		if l := len(segments); l > 0 && segments[l-1].orig+segments[l-1].length == t.start && segments[l-1].pos+segments[l-1].length == pos {
+			segments[l-1].length += end - t.start
+		} else {
+			segments = append(segments, segment{pos: pos, orig: t.start, length: end - t.start})
+		}
+		buf.WriteString(s[t.start:end])
+	}
+	return buf.String(), segments
+}
+
+// mapIndices translates the byte ranges in `matches`, which are
+// relative to the string described by `from`, into byte ranges relative
+// to the string described by `to`. Both `from` and `to` must have
+// been composed from the same original string. Portions of the matches
+// that do not exist in `to` are dropped.
+func mapIndices(matches [][]int, from, to []segment) [][]int {
+	var ret [][]int
+	for _, m := range matches {
+		for _, fs := range from {
+			// portion of m that lives in this segment, in original offsets
+			start := maxInt(m[0], fs.pos)
+			end := minInt(m[1], fs.pos+fs.length)
+			if start >= end {
+				continue
+			}
+			ostart := fs.orig + start - fs.pos
+			oend := fs.orig + end - fs.pos
+
+			for _, ts := range to {
+				s := maxInt(ostart, ts.orig)
+				e := minInt(oend, ts.orig+ts.length)
+				if s >= e {
+					continue
+				}
+				ret = append(ret, []int{ts.pos + s - ts.orig, ts.pos + e - ts.orig})
+			}
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i][0] < ret[j][0] })
+
+	// merge overlapping/adjacent regions
+	merged := ret[:0]
+	for _, m := range ret {
+		if l := len(merged); l > 0 && m[0] <= merged[l-1][1] {
+			if m[1] > merged[l-1][1] {
+				merged[l-1][1] = m[1]
+			}
+			continue
+		}
+		merged = append(merged, m)
+	}
+	return merged
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,118 @@
+package line
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFieldRanges(t *testing.T) {
+	testValues := []struct {
+		expr     string
+		expected FieldRanges
+		err      bool
+	}{
+		{"1", FieldRanges{{1, 1}}, false},
+		{"-1", FieldRanges{{-1, -1}}, false},
+		{"2..", FieldRanges{{2, 0}}, false},
+		{"..3", FieldRanges{{0, 3}}, false},
+		{"..", FieldRanges{{0, 0}}, false},
+		{"1,3..-2", FieldRanges{{1, 1}, {3, -2}}, false},
+		{"0", nil, true},
+		{"a", nil, true},
+		{"1,", nil, true},
+	}
+
+	for _, v := range testValues {
+		ranges, err := ParseFieldRanges(v.expr)
+		if v.err {
+			assert.Error(t, err, "ParseFieldRanges(%q) should fail", v.expr)
+			continue
+		}
+		if !assert.NoError(t, err, "ParseFieldRanges(%q) should succeed", v.expr) {
+			continue
+		}
+		assert.Equal(t, v.expected, ranges, "ParseFieldRanges(%q)", v.expr)
+	}
+}
+
+func TestFields(t *testing.T) {
+	testValues := []struct {
+		input     string
+		delimiter string
+		nth       string
+		withNth   string
+		outputNth string
+		display   string
+		match     string
+		output    string
+	}{
+		{"  1234 pts/0  vim foo.go", "", "", "3..", "1", "vim foo.go", "vim foo.go", "1234"},
+		{"  1234 pts/0  vim foo.go", "", "-1", "", "", "  1234 pts/0  vim foo.go", "foo.go", "  1234 pts/0  vim foo.go"},
+		{"a:b:c:d", ":", "2,4", "1..3", "-1", "a:b:c", "b:d", "d"},
+		{"a:b:c:d", ":", "", "3,1", "5", "c:a", "c:a", ""},
+		{"a::b", ":", "2", "", "", "a::b", "", "a::b"},
+		{"a:b", ":", "", "5", "", "", "", "a:b"},
+	}
+
+	for _, v := range testValues {
+		fields, err := NewFields(v.delimiter, v.nth, v.withNth, v.outputNth)
+		if !assert.NoError(t, err, "NewFields should succeed") {
+			continue
+		}
+
+		l := NewRawWithFields(1, v.input, false, fields)
+		assert.Equal(t, v.display, l.DisplayString(), "DisplayString() for %q", v.input)
+		assert.Equal(t, v.match, l.MatchString(), "MatchString() for %q", v.input)
+		assert.Equal(t, v.output, l.Output(), "Output() for %q", v.input)
+	}
+
+	t.Run("No fields", func(t *testing.T) {
+		fields, err := NewFields("", "", "", "")
+		if !assert.NoError(t, err, "NewFields should succeed") {
+			return
+		}
+		assert.Nil(t, fields, "NewFields should return nil")
+	})
+
+	t.Run("Null separator", func(t *testing.T) {
+		fields, err := NewFields("", "2", "", "1")
+		if !assert.NoError(t, err, "NewFields should succeed") {
+			return
+		}
+		l := NewRawWithFields(1, "foo bar\000baz qux", true, fields)
+		assert.Equal(t, "foo bar", l.DisplayString(), "DisplayString() should not include output")
+		assert.Equal(t, "bar", l.MatchString(), "MatchString() should only include field 2")
+		assert.Equal(t, "baz", l.Output(), "Output() should select from the output part")
+	})
+
+	t.Run("Highlight translation", func(t *testing.T) {
+		fields, err := NewFields(":", "2,4", "2..", "")
+		if !assert.NoError(t, err, "NewFields should succeed") {
+			return
+		}
+		l := NewRawWithFields(1, "a:bb:c:dd", false, fields)
+		if !assert.Equal(t, "bb:dd", l.MatchString(), "MatchString()") {
+			return
+		}
+		if !assert.Equal(t, "bb:c:dd", l.DisplayString(), "DisplayString()") {
+			return
+		}
+
+		// "b:d" in the match string spans both fields
+		m := NewMatched(l, [][]int{{1, 4}})
+		assert.Equal(t, [][]int{{1, 3}, {5, 6}}, m.Indices(), "indices should point into the display string")
+
+		// wrapping a Matched should translate from the same match string
+		m = NewMatched(m, [][]int{{0, 2}})
+		assert.Equal(t, [][]int{{0, 2}}, m.Indices(), "indices should point into the display string")
+
+		fields, err = NewFields(":", "1", "2..", "")
+		if !assert.NoError(t, err, "NewFields should succeed") {
+			return
+		}
+		l = NewRawWithFields(1, "a:bb", false, fields)
+		m = NewMatched(l, [][]int{{0, 1}})
+		assert.Empty(t, m.Indices(), "matches in hidden fields should not be highlighted")
+	})
+}
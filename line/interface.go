@@ -23,6 +23,11 @@ type Line interface {
 	// in this string
 	DisplayString() string
 
+	// MatchString returns the string that filters should match against.
+	// This is the same as DisplayString, unless the fields to match
+	// against have been restricted
+	MatchString() string
+
 	// Output returns the string to be display as peco finishes up doing its
 	// thing. This means if you have null separator, the contents before the
 	// separator are not included in this string
@@ -42,12 +47,62 @@ type Raw struct {
 	sepLoc        int
 	displayString string
 	dirty         bool
+	origin        Origin
+
+	// These are only populated if fields is non-nil
+	fields          *Fields
+	matchString     string
+	matchSegments   []segment
+	displaySegments []segment
 }
 
 // Matched contains the indices to the matches
 type Matched struct {
 	Line
 	indices [][]int
+	score   int
+}
+
+// IndexTranslator is implemented by lines whose MatchString differs
+// from their DisplayString
+type IndexTranslator interface {
+	MatchToDisplayIndices([][]int) [][]int
+}
+
+// Scorer is implemented by lines that know how well they matched
+// against a query. Larger values mean better matches
+type Scorer interface {
+	Score() int
+}
+
+
+
+// ANSIRun is a region of the display string, [Start, End), that is
+// styled by the SGR escape sequences in Codes. Each element of Codes is
+// the parameters of one sequence, e.g. "1;31", in the order that they
+// appeared in the input
+type ANSIRun struct {
+	Start int
+	End   int
+	Codes []string
 }
 
+// ANSIStyler is implemented by lines that can report the styles that
+// escape sequences in the input applied to the display string
+type ANSIStyler interface {
+	ANSIRuns() []ANSIRun
+}
 
+// Origin is the file that a line was read from, and its line number in
+// that file. Line numbers start from 1. The zero value means that the
+// origin is not known
+type Origin struct {
+	Filename string
+	Lineno   int
+}
+
+// Originator is implemented by lines that remember where they were
+// read from
+type Originator interface {
+	Origin() Origin
+}
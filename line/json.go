@@ -0,0 +1,274 @@
+package line
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/google/btree"
+	"github.com/pkg/errors"
+)
+
+// JSONTemplate extracts a string from a JSON record, using either a
+// field path such as `.metadata.name`, or a text/template such as
+// `{{.name}} {{.status}}`
+type JSONTemplate struct {
+	path []string
+	tmpl *template.Template
+}
+
+// JSONFormat describes the strings used for display, matching, and
+// output of JSON records. A nil JSONTemplate means that the record
+// itself is used, except for Match, which defaults to what is displayed
+type JSONFormat struct {
+	Display *JSONTemplate
+	Match   *JSONTemplate
+	Output  *JSONTemplate
+}
+
+// JSON is an input line that holds a JSON record. The record is parsed
+// once, when the line is created
+type JSON struct {
+	id            uint64
+	buf           string
+	record        interface{}
+	displayString string
+	matchString   string
+	output        string
+	dirty         bool
+	origin        Origin
+}
+
+// NewJSONTemplate parses a field path or a template. If s is empty,
+// nil is returned
+func NewJSONTemplate(s string) (*JSONTemplate, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	if strings.Contains(s, "{{") {
+		tmpl, err := template.New("json").Funcs(jsonTemplateFuncs).Parse(s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse template '%s'", s)
+		}
+		for _, t := range tmpl.Templates() {
+			formatJSONValues(t.Tree.Root)
+		}
+		return &JSONTemplate{tmpl: tmpl}, nil
+	}
+
+	path := strings.Split(strings.TrimPrefix(s, "."), ".")
+	for _, key := range path {
+		if key == "" {
+			return nil, errors.Errorf("invalid field path '%s'", s)
+		}
+	}
+	return &JSONTemplate{path: path}, nil
+}
+
+// jsonValueFunc is the function that formatJSONValues adds to templates
+const jsonValueFunc = "pecoJSONValue"
+
+var jsonTemplateFuncs = template.FuncMap{jsonValueFunc: jsonValueString}
+
+// jsonValueCmd is the pipeline command that calls jsonValueFunc
+var jsonValueCmd = template.Must(template.New("").Funcs(jsonTemplateFuncs).Parse("{{" + jsonValueFunc + "}}")).Tree.Root.Nodes[0].(*parse.ActionNode).Pipe.Cmds[0]
+
+// formatJSONValues makes each value that the template prints go through
+// jsonValueString, so that values are formatted like field paths do.
+// Otherwise missing fields and nulls would be printed as "<no value>"
+func formatJSONValues(n parse.Node) {
+	switch x := n.(type) {
+	case *parse.ListNode:
+		if x == nil {
+			return
+		}
+		for _, n := range x.Nodes {
+			formatJSONValues(n)
+		}
+	case *parse.ActionNode:
+		// Variable declarations print nothing
+		if len(x.Pipe.Decl) == 0 {
+			x.Pipe.Cmds = append(x.Pipe.Cmds, jsonValueCmd)
+		}
+	case *parse.IfNode:
+		formatJSONValues(x.List)
+		formatJSONValues(x.ElseList)
+	case *parse.RangeNode:
+		formatJSONValues(x.List)
+		formatJSONValues(x.ElseList)
+	case *parse.WithNode:
+		formatJSONValues(x.List)
+		formatJSONValues(x.ElseList)
+	}
+}
+
+// NewJSONFormat parses the templates used for display, matching, and
+// output
+func NewJSONFormat(display, match, output string) (*JSONFormat, error) {
+	var f JSONFormat
+	for _, v := range []struct {
+		expr string
+		dst  **JSONTemplate
+	}{
+		{display, &f.Display},
+		{match, &f.Match},
+		{output, &f.Output},
+	} {
+		t, err := NewJSONTemplate(v.expr)
+		if err != nil {
+			return nil, err
+		}
+		*v.dst = t
+	}
+	return &f, nil
+}
+
+// Execute returns the string that the template extracts from record
+func (t *JSONTemplate) Execute(record interface{}) (string, error) {
+	if t.tmpl != nil {
+		var buf bytes.Buffer
+		if err := t.tmpl.Execute(&buf, record); err != nil {
+			return "", errors.Wrap(err, "failed to execute template")
+		}
+		return buf.String(), nil
+	}
+
+	v := record
+	for _, key := range t.path {
+		switch x := v.(type) {
+		case map[string]interface{}:
+			v = x[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(x) {
+				return "", nil
+			}
+			v = x[i]
+		default:
+			return "", nil
+		}
+	}
+	return jsonValueString(v), nil
+}
+
+// jsonValueString formats a value from a JSON record. Strings are used
+// as they are, and objects and arrays are formatted as JSON
+func jsonValueString(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case json.Number:
+		return x.String()
+	case bool:
+		return strconv.FormatBool(x)
+	default:
+		buf, err := json.Marshal(x)
+		if err != nil {
+			return ""
+		}
+		return string(buf)
+	}
+}
+
+// NewJSON parses v as a JSON record, and creates a new JSON line. An
+// error is returned if v is not a single valid JSON value
+func NewJSON(id uint64, v string, format *JSONFormat) (*JSON, error) {
+	dec := json.NewDecoder(strings.NewReader(v))
+	dec.UseNumber()
+
+	var record interface{}
+	if err := dec.Decode(&record); err != nil {
+		return nil, errors.Wrap(err, "failed to parse JSON")
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("failed to parse JSON: unexpected data after the record")
+	}
+
+	jl := &JSON{
+		id:     id,
+		buf:    v,
+		record: record,
+	}
+
+	var err error
+	if jl.displayString, err = jl.execute(format.Display, v); err != nil {
+		return nil, err
+	}
+	if jl.matchString, err = jl.execute(format.Match, jl.displayString); err != nil {
+		return nil, err
+	}
+	if jl.output, err = jl.execute(format.Output, v); err != nil {
+		return nil, err
+	}
+	return jl, nil
+}
+
+func (jl *JSON) execute(t *JSONTemplate, def string) (string, error) {
+	if t == nil {
+		return def, nil
+	}
+	return t.Execute(jl.record)
+}
+
+// Less implements the btree.Item interface
+func (jl *JSON) Less(b btree.Item) bool {
+	return jl.id < b.(Line).ID()
+}
+
+// ID returns the unique ID of this line
+func (jl *JSON) ID() uint64 {
+	return jl.id
+}
+
+// Record returns the parsed JSON record
+func (jl *JSON) Record() interface{} {
+	return jl.record
+}
+
+// Buffer returns the JSON record, as it was read
+func (jl *JSON) Buffer() string {
+	return jl.buf
+}
+
+// DisplayString returns the string to be displayed
+func (jl *JSON) DisplayString() string {
+	return jl.displayString
+}
+
+// MatchString returns the string that filters should match against
+func (jl *JSON) MatchString() string {
+	return jl.matchString
+}
+
+// MatchToDisplayIndices translates indices into the string returned by
+// MatchString into indices into the string returned by DisplayString.
+// Matches are only highlighted if what is displayed is what is matched
+// against, as there is no telling where else the matched string is
+func (jl *JSON) MatchToDisplayIndices(matches [][]int) [][]int {
+	if jl.matchString != jl.displayString {
+		return nil
+	}
+	return matches
+}
+
+// Output returns the string to be output when the line is selected
+func (jl *JSON) Output() string {
+	return jl.output
+}
+
+// IsDirty returns true if this line must be redrawn on the terminal
+func (jl *JSON) IsDirty() bool {
+	return jl.dirty
+}
+
+// SetDirty sets the dirty flag
+func (jl *JSON) SetDirty(b bool) {
+	jl.dirty = b
+}
@@ -0,0 +1,87 @@
+package line
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSON(t *testing.T) {
+	const record = `{"id": 12345678901234567890, "name": "web", "status": "Running", "tags": ["a", "b"], "meta": {"zone": "x"}, "null": null}`
+
+	testValues := []struct {
+		name    string
+		display string
+		match   string
+		output  string
+		expect  [3]string
+	}{
+		{"defaults", "", "", "", [3]string{record, record, record}},
+		{"field paths", ".name", "status", ".id", [3]string{"web", "Running", "12345678901234567890"}},
+		{"nested paths", "meta.zone", "tags.1", ".tags", [3]string{"x", "b", `["a","b"]`}},
+		{"missing fields", ".missing", "tags.5", ".name.x", [3]string{"", "", ""}},
+		{"template", "{{.name}} {{.status}}", "", "{{.id}}", [3]string{"web Running", "web Running", "12345678901234567890"}},
+		{"missing fields in templates", "{{.name}}:{{.missing}}", "{{.meta.none}}", "{{.missing}}{{with .null}}x{{end}}", [3]string{"web:", "", ""}},
+		{"values in templates", "{{.meta}} {{.tags}}", "{{$t := .tags}}{{range $t}}{{.}}{{end}}", "{{len .tags}} {{.null}}", [3]string{`{"zone":"x"} ["a","b"]`, "ab", "2 "}},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			f, err := NewJSONFormat(v.display, v.match, v.output)
+			if !assert.NoError(t, err, "NewJSONFormat should succeed") {
+				return
+			}
+			l, err := NewJSON(1, record, f)
+			if !assert.NoError(t, err, "NewJSON should succeed") {
+				return
+			}
+			assert.Equal(t, v.expect, [3]string{l.DisplayString(), l.MatchString(), l.Output()}, "display, match, and output strings should match")
+			assert.Equal(t, record, l.Buffer(), "buffer should be the record")
+		})
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	for _, expr := range []string{"{{.name", "a..b", "."} {
+		_, err := NewJSONTemplate(expr)
+		assert.Error(t, err, "NewJSONTemplate(%q) should fail", expr)
+	}
+
+	f, err := NewJSONFormat("", "", "")
+	if !assert.NoError(t, err, "NewJSONFormat should succeed") {
+		return
+	}
+	for _, record := range []string{"not json", `{"a": 1`, `{"a": 1} {"b": 2}`} {
+		_, err := NewJSON(1, record, f)
+		assert.Error(t, err, "NewJSON(%q) should fail", record)
+	}
+}
+
+func TestJSONMatchToDisplayIndices(t *testing.T) {
+	const record = `{"name": "web", "status": "Running"}`
+
+	testValues := []struct {
+		display  string
+		match    string
+		expected [][]int
+	}{
+		// "n" in "Running" would be highlighted if the name was looked for
+		{"{{.status}}: {{.name}}", ".name", nil},
+		{".status", ".name", nil},
+		{".name", "", [][]int{{0, 1}}},
+		{".name", ".name", [][]int{{0, 1}}},
+		{"{{.name}}", ".name", [][]int{{0, 1}}},
+	}
+
+	for _, v := range testValues {
+		f, err := NewJSONFormat(v.display, v.match, "")
+		if !assert.NoError(t, err, "NewJSONFormat should succeed") {
+			return
+		}
+		l, err := NewJSON(1, record, f)
+		if !assert.NoError(t, err, "NewJSON should succeed") {
+			return
+		}
+		assert.Equal(t, v.expected, l.MatchToDisplayIndices([][]int{{0, 1}}), "indices for display %q and match %q", v.display, v.match)
+	}
+}
@@ -1,12 +1,36 @@
 package line
 
-// NewMatched creates a new Matched
+// NewMatched creates a new Matched. The matches are expected to be
+// indices into the string returned by rl.MatchString()
 func NewMatched(rl Line, matches [][]int) *Matched {
-	return &Matched{rl, matches}
+	return &Matched{Line: rl, indices: displayIndices(rl, matches)}
 }
 
-// Indices returns the indices in the buffer that matched
+// NewScoredMatched creates a new Matched that also carries a score
+func NewScoredMatched(rl Line, matches [][]int, score int) *Matched {
+	return &Matched{Line: rl, indices: displayIndices(rl, matches), score: score}
+}
+
+func displayIndices(rl Line, matches [][]int) [][]int {
+	if t, ok := rl.(IndexTranslator); ok {
+		return t.MatchToDisplayIndices(matches)
+	}
+	return matches
+}
+
+// Indices returns the indices in the display string that matched
 func (ml Matched) Indices() [][]int {
 	return ml.indices
 }
 
+// Score returns the score assigned by the filter that produced
+// this match. Filters that do not rank their results leave this as 0
+func (ml Matched) Score() int {
+	return ml.score
+}
+
+// MatchToDisplayIndices implements IndexTranslator by delegating to
+// the underlying line
+func (ml Matched) MatchToDisplayIndices(matches [][]int) [][]int {
+	return displayIndices(ml.Line, matches)
+}
@@ -0,0 +1,49 @@
+package line
+
+import "strconv"
+
+// String returns the origin as "filename:lineno", the way that editors
+// and compilers refer to lines. An empty string is returned if the
+// origin is not known
+func (o Origin) String() string {
+	if o.Lineno == 0 {
+		return ""
+	}
+	return o.Filename + ":" + strconv.Itoa(o.Lineno)
+}
+
+// Origin returns where this line was read from
+func (rl Raw) Origin() Origin {
+	return rl.origin
+}
+
+// SetOrigin sets where this line was read from
+func (rl *Raw) SetOrigin(o Origin) {
+	rl.origin = o
+}
+
+// Origin returns where this line was read from
+func (jl *JSON) Origin() Origin {
+	return jl.origin
+}
+
+// SetOrigin sets where this line was read from
+func (jl *JSON) SetOrigin(o Origin) {
+	jl.origin = o
+}
+
+// Origin implements Originator by delegating to the underlying line
+func (ml Matched) Origin() Origin {
+	if o, ok := ml.Line.(Originator); ok {
+		return o.Origin()
+	}
+	return Origin{}
+}
+
+// OriginOf returns where l was read from, if it is known
+func OriginOf(l Line) Origin {
+	if o, ok := l.(Originator); ok {
+		return o.Origin()
+	}
+	return Origin{}
+}
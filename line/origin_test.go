@@ -0,0 +1,27 @@
+package line
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrigin(t *testing.T) {
+	origin := Origin{Filename: "src/main.go", Lineno: 42}
+
+	rl := NewRaw(1, "package main", false)
+	assert.Equal(t, Origin{}, OriginOf(rl), "origin should not be known at first")
+	assert.Equal(t, "", OriginOf(rl).String(), "unknown origin should be empty")
+
+	rl.SetOrigin(origin)
+	assert.Equal(t, origin, OriginOf(rl), "origin should match")
+	assert.Equal(t, "src/main.go:42", OriginOf(rl).String(), "origin string should match")
+	assert.Equal(t, origin, OriginOf(NewMatched(rl, nil)), "matched lines should report the origin of the line")
+
+	jl, err := NewJSON(2, `{"name": "foo"}`, &JSONFormat{})
+	if !assert.NoError(t, err, "NewJSON should succeed") {
+		return
+	}
+	jl.SetOrigin(origin)
+	assert.Equal(t, origin, OriginOf(NewMatched(jl, nil)), "JSON lines should report their origin")
+}
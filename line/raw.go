@@ -12,24 +12,48 @@ import (
 // string to display and the string to emit upon selection of
 // of said line
 func NewRaw(id uint64, v string, enableSep bool) *Raw {
+	return NewRawWithFields(id, v, enableSep, nil)
+}
+
+// NewRawWithFields creates a new Raw, like NewRaw. If `fields` is
+// non-nil, the strings used for matching, display, and output are
+// composed from the fields that it specifies
+func NewRawWithFields(id uint64, v string, enableSep bool, fields *Fields) *Raw {
 	rl := &Raw{
 		id:            id,
 		buf:           v,
 		sepLoc:        -1,
 		displayString: "",
 		dirty:         false,
+		fields:        fields,
 	}
 
-	if !enableSep {
-		return rl
+	if enableSep {
+		if i := strings.IndexByte(rl.buf, '\000'); i != -1 {
+			rl.sepLoc = i
+		}
 	}
 
-	if i := strings.IndexByte(rl.buf, '\000'); i != -1 {
-		rl.sepLoc = i
+	if fields != nil {
+		rl.applyFields()
 	}
 	return rl
 }
 
+// applyFields pre-computes the strings used for display and matching.
+// This is done once, as these are requested repeatedly
+func (rl *Raw) applyFields() {
+	base := rl.baseDisplayString()
+	tokens := rl.fields.split(base)
+	rl.displayString, rl.displaySegments = rl.fields.compose(base, tokens, rl.fields.Display)
+	if len(rl.fields.Match) == 0 {
+		// matching is done against what is displayed
+		rl.matchString = rl.displayString
+		return
+	}
+	rl.matchString, rl.matchSegments = rl.fields.compose(base, tokens, rl.fields.Match)
+}
+
 // Less implements the btree.Item interface
 func (rl *Raw) Less(b btree.Item) bool {
 	return rl.id < b.(Line).ID()
@@ -57,23 +81,52 @@ func (rl Raw) Buffer() string {
 
 // DisplayString returns the string to be displayed
 func (rl Raw) DisplayString() string {
-	if rl.displayString != "" {
+	// With fields, the display string has been computed, and may be
+	// empty if none of the fields are in the line
+	if rl.fields != nil || rl.displayString != "" {
 		return rl.displayString
 	}
 
+	rl.displayString = rl.baseDisplayString()
+	return rl.displayString
+}
+
+func (rl Raw) baseDisplayString() string {
 	if i := rl.sepLoc; i > -1 {
-		rl.displayString = util.StripANSISequence(rl.buf[:i])
-	} else {
-		rl.displayString = util.StripANSISequence(rl.buf)
+		return util.StripANSISequence(rl.buf[:i])
 	}
-	return rl.displayString
+	return util.StripANSISequence(rl.buf)
+}
+
+// MatchString returns the string that filters should match against
+func (rl Raw) MatchString() string {
+	if rl.fields == nil {
+		return rl.DisplayString()
+	}
+	return rl.matchString
+}
+
+// MatchToDisplayIndices translates indices into the string returned by
+// MatchString into indices into the string returned by DisplayString.
+// Matches against fields that are not displayed are dropped
+func (rl Raw) MatchToDisplayIndices(matches [][]int) [][]int {
+	if rl.matchSegments == nil || len(matches) == 0 {
+		return matches
+	}
+	return mapIndices(matches, rl.matchSegments, rl.displaySegments)
 }
 
 // Output returns the string to be displayed *after peco is done
 func (rl Raw) Output() string {
+	out := rl.buf
 	if i := rl.sepLoc; i > -1 {
-		return rl.buf[i+1:]
+		out = rl.buf[i+1:]
 	}
-	return rl.buf
+
+	if rl.fields == nil || len(rl.fields.Output) == 0 {
+		return out
+	}
+	out, _ = rl.fields.compose(out, rl.fields.split(out), rl.fields.Output)
+	return out
 }
 
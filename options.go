@@ -32,6 +32,17 @@ func (options CLIOptions) Validate() error {
 			return errors.New("unknown layout: '" + options.OptLayout + "'")
 		}
 	}
+	if options.OptHeight != "" {
+		if _, _, err := parseHeight(options.OptHeight); err != nil {
+			return err
+		}
+	}
+	if options.OptPrintIndices && options.OptFilter == nil {
+		return errors.New("--print-indices can only be used with --filter")
+	}
+	if options.OptFollow && options.OptFilter != nil {
+		return errors.New("--follow cannot be used with --filter, as the input never ends")
+	}
 	return nil
 }
 
@@ -39,7 +50,7 @@ func (options CLIOptions) help() []byte {
 	buf := bytes.Buffer{}
 
 	fmt.Fprintf(&buf, `
-Usage: peco [options] [FILE]
+Usage: peco [options] [FILE...]
 
 Options:
 `)
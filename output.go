@@ -0,0 +1,105 @@
+package peco
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/peco/peco/line"
+	"github.com/pkg/errors"
+)
+
+// outputLines returns what is known about the lines that are printed
+func (p *Peco) outputLines(lines []line.Line) []OutputLine {
+	// Lines only know their IDs, so look up their positions in the input
+	index := make(map[uint64]int)
+	if src := p.currentSource(); src != nil {
+		for i, l := range src.linesInRange(0, src.Size()) {
+			index[l.ID()] = i
+		}
+	}
+
+	out := make([]OutputLine, len(lines))
+	for i, l := range lines {
+		ol := OutputLine{
+			Index:   -1,
+			Line:    l.Output(),
+			Display: l.DisplayString(),
+			Matches: [][]int{},
+			Origin:  line.OriginOf(l).String(),
+		}
+		if n, ok := index[l.ID()]; ok {
+			ol.Index = n
+		}
+		if m, ok := l.(MatchIndexer); ok && m.Indices() != nil {
+			ol.Matches = m.Indices()
+		}
+
+		for {
+			m, ok := l.(*line.Matched)
+			if !ok {
+				break
+			}
+			l = m.Line
+		}
+		if jl, ok := l.(*line.JSON); ok {
+			ol.Record = jl.Record()
+		}
+		out[i] = ol
+	}
+	return out
+}
+
+// outputString returns what is printed for l by default. With
+// --print-origin, the line is prefixed with where it was read from, as
+// in "filename:lineno:line"
+func (p *Peco) outputString(l line.Line) string {
+	if p.printOrigin {
+		if origin := line.OriginOf(l).String(); origin != "" {
+			return origin + ":" + l.Output()
+		}
+	}
+	return l.Output()
+}
+
+// formatResults writes the lines to buf in the format specified by
+// --output or --output-format. Returns false if neither was specified,
+// in which case nothing is written
+func (p *Peco) formatResults(buf *bytes.Buffer, lines []line.Line, query string) (bool, error) {
+	if p.outputFormat == nil && !p.outputJSON {
+		return false, nil
+	}
+
+	results := OutputResults{
+		Query:     query,
+		Key:       p.finishKey,
+		Action:    p.finishAction,
+		Selection: p.outputLines(lines),
+	}
+	if f := p.Filters().Current(); f != nil {
+		results.Filter = f.String()
+	}
+
+	if p.outputJSON {
+		enc := json.NewEncoder(buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(results); err != nil {
+			return true, errors.Wrap(err, "failed to encode results")
+		}
+		return true, nil
+	}
+
+	for _, l := range results.Selection {
+		data := outputFormatData{
+			OutputLine: l,
+			Query:      results.Query,
+			Filter:     results.Filter,
+			Key:        results.Key,
+			Action:     results.Action,
+		}
+		if err := p.outputFormat.Execute(buf, data); err != nil {
+			return true, errors.Wrap(err, "failed to execute output format")
+		}
+		buf.WriteByte('\n')
+	}
+	return true, nil
+}
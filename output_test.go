@@ -0,0 +1,180 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// waitForLines waits until n lines are displayed, which means that the
+// query has been run
+func waitForLines(ctx context.Context, p *Peco, n int) bool {
+	for p.CurrentLineBuffer().Size() != n {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	return true
+}
+
+func TestOutputJSON(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := newPeco()
+	p.Argv = []string{"peco", "--output", "json", "--query", "ba"}
+	p.Stdin = bytes.NewBufferString("foo\nbar\nbaz\n")
+	var out bytes.Buffer
+	p.Stdout = &out
+
+	resultCh := make(chan error)
+	go func() {
+		defer close(resultCh)
+		select {
+		case <-ctx.Done():
+			return
+		case resultCh <- p.Run(ctx):
+			return
+		}
+	}()
+
+	<-p.Ready()
+	// Both lines must be matched before moving to the second one
+	if !waitForLines(ctx, p, 2) {
+		t.Errorf("timeout reached")
+		return
+	}
+	p.screen.SendEvent(termbox.Event{Key: termbox.KeyCtrlN})
+	for p.Location().LineNumber() != 1 {
+		select {
+		case <-ctx.Done():
+			t.Errorf("timeout reached")
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	p.screen.SendEvent(termbox.Event{Key: termbox.KeyEnter})
+
+	select {
+	case <-ctx.Done():
+		t.Errorf("timeout reached")
+		return
+	case err := <-resultCh:
+		if !assert.True(t, util.IsCollectResultsError(err), "isCollectResultsError") {
+			return
+		}
+		if !assert.NoError(t, p.PrintResults(), "PrintResults should succeed") {
+			return
+		}
+	}
+
+	expected := `{"query":"ba","filter":"IgnoreCase","key":"Enter","action":"peco.Finish","selection":[{"index":2,"line":"baz","display":"baz","matches":[[0,2]],"origin":"-:3"}]}` + "\n"
+	assert.Equal(t, expected, out.String(), "output should match")
+}
+
+func TestOutputOptions(t *testing.T) {
+	testValues := []struct {
+		name string
+		args []string
+	}{
+		{"Unknown output", []string{"--output", "xml"}},
+		{"Both", []string{"--output", "json", "--output-format", "{{.Line}}"}},
+		{"Bad template", []string{"--output-format", "{{.Line"}},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			p := newPeco()
+			p.Argv = append([]string{"peco"}, v.args...)
+			p.Stdin = bytes.NewBufferString("foo\n")
+			assert.Error(t, p.Run(context.Background()), "p.Run() should fail")
+		})
+	}
+}
+
+func TestExpect(t *testing.T) {
+	testValues := []struct {
+		name     string
+		args     []string
+		lines    int
+		key      termbox.Key
+		expected string
+	}{
+		{"Expected key", []string{"--expect", "C-o,C-x"}, 2, termbox.KeyCtrlX, "C-x\nfoo\n"},
+		{"Other keys", []string{"--expect", "C-o,C-x"}, 2, termbox.KeyEnter, "\nfoo\n"},
+		{"Print query", []string{"--expect", "C-o", "--print-query", "--query", "a"}, 1, termbox.KeyCtrlO, "a\nC-o\nbar\n"},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			p := newPeco()
+			p.Argv = append([]string{"peco"}, v.args...)
+			p.Stdin = bytes.NewBufferString("foo\nbar\n")
+			var out bytes.Buffer
+			p.Stdout = &out
+
+			resultCh := make(chan error)
+			go func() {
+				defer close(resultCh)
+				select {
+				case <-ctx.Done():
+					return
+				case resultCh <- p.Run(ctx):
+					return
+				}
+			}()
+
+			<-p.Ready()
+			if !waitForLines(ctx, p, v.lines) {
+				t.Errorf("timeout reached")
+				return
+			}
+			p.screen.SendEvent(termbox.Event{Key: v.key})
+
+			select {
+			case <-ctx.Done():
+				t.Errorf("timeout reached")
+				return
+			case err := <-resultCh:
+				if !assert.True(t, util.IsCollectResultsError(err), "isCollectResultsError") {
+					return
+				}
+				if !assert.NoError(t, p.PrintResults(), "PrintResults should succeed") {
+					return
+				}
+			}
+			assert.Equal(t, v.expected, out.String(), "output should match")
+		})
+	}
+}
+
+func TestExpectVimFind(t *testing.T) {
+	state := newPeco()
+	state.Argv = []string{"peco", "--expect", "C-x", "--vim", state.Argv[1]}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go state.Run(ctx)
+
+	<-state.Ready()
+
+	km := state.Keymap()
+	km.ExecuteAction(ctx, state, termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEsc})
+	km.ExecuteAction(ctx, state, termbox.Event{Type: termbox.EventKey, Ch: 'f'})
+
+	// An expected key that is the target of 'f' cancels the find
+	km.ExecuteAction(ctx, state, termbox.Event{Type: termbox.EventKey, Key: termbox.KeyCtrlX})
+	assert.NoError(t, state.Err(), "peco should not finish")
+
+	km.ExecuteAction(ctx, state, termbox.Event{Type: termbox.EventKey, Key: termbox.KeyCtrlX})
+	assert.True(t, util.IsCollectResultsError(state.Err()), "peco should finish")
+}
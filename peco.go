@@ -9,9 +9,13 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"reflect"
 	"runtime"
+	"strings"
 	"sync"
+	"text/template"
 	"time"
 	"unicode/utf8"
 
@@ -21,6 +25,7 @@ import (
 	"github.com/lestrrat-go/pdebug"
 	"github.com/peco/peco/filter"
 	"github.com/peco/peco/hub"
+	"github.com/peco/peco/internal/keyseq"
 	"github.com/peco/peco/internal/util"
 	"github.com/peco/peco/line"
 	"github.com/peco/peco/pipeline"
@@ -221,9 +226,127 @@ func (p *Peco) SingleKeyJumpIndex(ch rune) (uint, bool) {
 }
 
 func (p *Peco) Source() pipeline.Source {
+	return p.currentSource()
+}
+
+func (p *Peco) currentSource() *Source {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 	return p.source
 }
 
+// replaceSource makes src the source of the lines, and runs the current
+// query against its lines
+func (p *Peco) replaceSource(src *Source) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Peco.replaceSource (%d lines)", src.Size())
+		defer g.End()
+	}
+
+	p.mutex.Lock()
+	old := p.source
+	p.source = src
+	p.mutex.Unlock()
+	p.resultCache.Remove(old)
+
+	// The selected lines are no longer there, so select the new lines
+	// with the same contents instead
+	sel := p.Selection()
+	selected := make(map[string]struct{})
+	sel.Ascend(func(it btree.Item) bool {
+		selected[it.(line.Line).Buffer()] = struct{}{}
+		return true
+	})
+	sel.Reset()
+	if len(selected) > 0 {
+		for _, l := range src.linesInRange(0, src.Size()) {
+			if _, ok := selected[l.Buffer()]; ok {
+				sel.Add(l)
+			}
+		}
+	}
+	p.ExecQuery(nil)
+}
+
+// readSource reads all of the output of cmd into a new source, which
+// replaces the current one
+func (p *Peco) readSource(ctx context.Context, cmd *exec.Cmd) error {
+	// The input may still be waiting for its first line
+	cur := p.currentSource()
+	if cur == nil {
+		return errors.New("no input to reload yet")
+	}
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return errors.Wrap(err, "failed to create pipe")
+	}
+	if err := cmd.Start(); err != nil {
+		return errors.Wrap(err, "failed to start command")
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cmd.Process.Kill()
+		case <-done:
+		}
+	}()
+
+	// Keep the name of the original input, so that PECO_FILENAME does
+	// not change
+	src := p.newSource(cur.Name(), out, false)
+	src.Setup(ctx, p)
+	if err := cmd.Wait(); err != nil {
+		return errors.Wrap(err, "command failed")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	p.replaceSource(src)
+	return nil
+}
+
+// newSource creates a source that reads lines from in, as specified by
+// the command line options
+func (p *Peco) newSource(name string, in io.Reader, isInfinite bool) *Source {
+	src := NewSource(name, in, isInfinite, p.idgen, p.bufferSize, p.enableSep)
+	src.fields = p.fields
+	src.json = p.json
+	return src
+}
+
+// newCommandSource starts cmd, and creates a source that reads its
+// output. The command is killed if ctx is canceled before all of the
+// output has been read
+func (p *Peco) newCommandSource(ctx context.Context, cmd *exec.Cmd, name string, isInfinite bool) (*Source, error) {
+	if pdebug.Enabled {
+		pdebug.Printf("Using the output of %s as input", cmd.Args)
+	}
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create pipe")
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Wrap(err, "failed to start command")
+	}
+
+	src := p.newSource(name, out, isInfinite)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cmd.Process.Kill()
+		case <-src.SetupDone():
+		}
+		cmd.Wait()
+	}()
+	return src, nil
+}
+
 func (p *Peco) Filters() *filter.Set {
 	return &p.filters
 }
@@ -263,6 +386,25 @@ func (p *Peco) Keymap() Keymap {
 	return p.keymap
 }
 
+// keymapModes returns the names of the active modes, whose key bindings
+// are used before the global key bindings, in order of precedence
+func (p *Peco) keymapModes() []string {
+	var modes []string
+	if p.SingleKeyJumpMode() {
+		modes = append(modes, keymapSingleKeyJumpMode)
+	}
+	if p.SelectionRangeStart().Valid() {
+		modes = append(modes, keymapRangeMode)
+	}
+	if v := p.vim; v != nil {
+		modes = append(modes, v.Mode())
+	}
+	if pv := p.preview; pv != nil && pv.Visible() {
+		modes = append(modes, keymapPreviewMode)
+	}
+	return modes
+}
+
 func (p *Peco) Setup() (err error) {
 	if pdebug.Enabled {
 		g := pdebug.Marker("Peco.Setup").BindError(&err)
@@ -304,6 +446,7 @@ func (p *Peco) selectOneAndExitIfPossible() {
 	if b := p.CurrentLineBuffer(); b.Size() == 1 {
 		if l, err := b.LineAt(0); err == nil {
 			p.resultCh = make(chan line.Line)
+			p.finishAction = "select-1"
 			p.Exit(errCollectResults{})
 			p.resultCh <- l
 			close(p.resultCh)
@@ -325,6 +468,10 @@ func (p *Peco) Run(ctx context.Context) (err error) {
 		return errors.Wrap(err, "failed to setup peco")
 	}
 
+	if p.filterMode {
+		return p.runFilterMode(ctx, p.filterQuery)
+	}
+
 	var _cancelOnce sync.Once
 	var _cancel func()
 	ctx, _cancel = context.WithCancel(ctx)
@@ -349,6 +496,8 @@ func (p *Peco) Run(ctx context.Context) (err error) {
 
 	go sigH.Loop(ctx, cancel)
 
+	defer p.cancelReload()
+
 	// SetupSource is done AFTER other components are ready, otherwise
 	// we can't draw onto the screen while we are reading a really big
 	// buffer.
@@ -357,19 +506,36 @@ func (p *Peco) Run(ctx context.Context) (err error) {
 	if err != nil {
 		return errors.Wrap(err, "failed to setup input source")
 	}
+	p.mutex.Lock()
 	p.source = src
+	p.mutex.Unlock()
+
+	// SetupSource waits for the first line, so there is a source to
+	// replace by the time the first reload happens
+	if p.sourceCommand != "" {
+		go p.reloadLoop(ctx)
+	}
 
 	go func() {
-		<-p.source.Ready()
+		<-src.Ready()
 		// screen.Init must be called within Run() because we
 		// want to make sure to call screen.Close() after getting
 		// out of Run()
 		p.screen.Init(&p.config)
-		go NewInput(p, p.Keymap(), p.screen.PollEvent(ctx, &p.config)).Loop(ctx, cancel)
-		go NewView(p).Loop(ctx, cancel)
+		view := NewView(p)
+		go NewInput(p, p.Keymap(), p.screen.PollEvent(ctx, &p.config), view).Loop(ctx, cancel)
+		go view.Loop(ctx, cancel)
 		go NewFilter(p).Loop(ctx, cancel)
+
+		if err := p.historyErr; err != nil {
+			p.Hub().SendStatusMsg(ctx, err.Error())
+		}
 	}()
 	defer p.screen.Close()
+	defer p.filters.Close()
+	if p.preview != nil {
+		defer p.preview.Stop()
+	}
 
 	if p.Query().Len() <= 0 {
 		// Re-set the source only if there are no queries
@@ -388,7 +554,7 @@ func (p *Peco) Run(ctx context.Context) (err error) {
 			// source.Ready(), because Ready returns as soon as we get
 			// a line, where as SetupDone waits until we're completely
 			// done reading the input
-			<-p.source.SetupDone()
+			<-src.SetupDone()
 			p.selectOneAndExitIfPossible()
 		}()
 	}
@@ -404,7 +570,7 @@ func (p *Peco) Run(ctx context.Context) (err error) {
 
 	if p.Query().Len() > 0 {
 		go func() {
-			<-p.source.Ready()
+			<-src.Ready()
 
 			// iff p.selectOneAndExit is true, we should check after exec query is run
 			// if we only have one item
@@ -461,17 +627,62 @@ func (p *Peco) SetupSource(ctx context.Context) (s *Source, err error) {
 	var in io.Reader
 	var filename string
 	var isInfinite bool
+	var src *Source
 	switch {
+	case p.dynamicSource != "":
+		query := p.initialQuery
+		if p.filterMode {
+			query = p.filterQuery
+		}
+		query, _ = splitDynamicQuery(query)
+
+		// The command is run again whenever the query changes, which
+		// cancels the previous one
+		var cancel func()
+		ctx, cancel = context.WithCancel(ctx)
+		p.reloadMutex.Lock()
+		p.reloadCancel = cancel
+		p.dynamicQuery = query
+		p.reloadMutex.Unlock()
+
+		if src, err = p.newCommandSource(ctx, p.dynamicSourceCommand(query), `-`, true); err != nil {
+			return nil, errors.Wrap(err, "failed to start dynamic source command")
+		}
+	case p.sourceCommand != "":
+		if src, err = p.newCommandSource(ctx, util.Shell(p.sourceCommand), `-`, false); err != nil {
+			return nil, errors.Wrap(err, "failed to start source command")
+		}
 	case len(p.args) > 1:
-		f, err := os.Open(p.args[1])
+		files, err := expandInputFiles(p.args[1:])
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to open file for input")
+			return nil, err
 		}
-		if pdebug.Enabled {
-			pdebug.Printf("Using %s as input", p.args[1])
+		// All of the files are read into the same source
+		opened := make([]*os.File, 0, len(files))
+		for _, name := range files {
+			f, err := os.Open(name)
+			if err != nil {
+				for _, f := range opened {
+					f.Close()
+				}
+				return nil, errors.Wrap(err, "failed to open file for input")
+			}
+			if pdebug.Enabled {
+				pdebug.Printf("Using %s as input", name)
+			}
+			opened = append(opened, f)
+		}
+		inputs := make([]io.Reader, len(opened))
+		for i, f := range opened {
+			inputs[i] = f
+			if p.follow {
+				inputs[i] = newFollowFile(ctx, files[i], f)
+			}
+		}
+		src = p.newSource(files[0], inputs[0], p.follow)
+		for i, in := range inputs[1:] {
+			src.addInput(files[i+1], in)
 		}
-		in = f
-		filename = p.args[1]
 	case !util.IsTty(p.Stdin):
 		if pdebug.Enabled {
 			pdebug.Printf("Using p.Stdin as input")
@@ -487,7 +698,9 @@ func (p *Peco) SetupSource(ctx context.Context) (s *Source, err error) {
 		return nil, errors.New("you must supply something to work with via filename or stdin")
 	}
 
-	src := NewSource(filename, in, isInfinite, p.idgen, p.bufferSize, p.enableSep)
+	if src == nil {
+		src = p.newSource(filename, in, isInfinite)
+	}
 
 	// Block until we receive something from `in`
 	if pdebug.Enabled {
@@ -500,6 +713,30 @@ func (p *Peco) SetupSource(ctx context.Context) (s *Source, err error) {
 	return src, nil
 }
 
+// expandInputFiles expands the glob patterns in args, for shells that
+// do not, or in case the patterns were quoted. Names without glob
+// characters are used as they are
+func expandInputFiles(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		// Names such as "log[1].txt" are files, not patterns
+		if _, err := os.Stat(arg); err == nil || !strings.ContainsAny(arg, "*?[") {
+			files = append(files, arg)
+			continue
+		}
+
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid pattern '%s'", arg)
+		}
+		if len(matches) == 0 {
+			return nil, errors.Errorf("no files match '%s'", arg)
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
 func readConfig(cfg *Config, filename string) error {
 	if filename != "" {
 		if err := cfg.ReadFilename(filename); err != nil {
@@ -520,6 +757,10 @@ func (p *Peco) ApplyConfig(opts CLIOptions) error {
 		}
 	}
 
+	if v := p.config.QueryExecutionDelay; v > 0 {
+		p.queryExecDelay = time.Duration(v) * time.Millisecond
+	}
+
 	p.maxScanBufferSize = 256
 	if v := p.config.MaxScanBufferSize; v > 0 {
 		p.maxScanBufferSize = v
@@ -539,6 +780,18 @@ func (p *Peco) ApplyConfig(opts CLIOptions) error {
 		p.layoutType = v
 	}
 
+	if v := opts.OptHeight; v != "" {
+		lines, percent, err := parseHeight(v)
+		if err != nil {
+			return errors.Wrap(err, "failed to parse height")
+		}
+		s, err := NewInlineScreen(lines, percent)
+		if err != nil {
+			return errors.Wrap(err, "failed to create inline screen")
+		}
+		p.screen = s
+	}
+
 	p.prompt = p.config.Prompt
 	if v := opts.OptPrompt; len(v) > 0 {
 		p.prompt = v
@@ -547,6 +800,14 @@ func (p *Peco) ApplyConfig(opts CLIOptions) error {
 	}
 
 	p.use256Color = p.config.Use256Color
+	p.ansi = opts.OptANSI || p.config.ANSI
+	if opts.OptMouse {
+		// The screen is set up using the configuration
+		p.config.Mouse = true
+	}
+	if opts.OptVim || p.config.Vim {
+		p.vim = NewVim()
+	}
 
 	p.onCancel = successKey
 	if opts.OptOnCancel == errorKey || p.config.OnCancel == errorKey {
@@ -560,6 +821,35 @@ func (p *Peco) ApplyConfig(opts CLIOptions) error {
 	}
 	p.selectOneAndExit = opts.OptSelect1
 	p.printQuery = opts.OptPrintQuery
+	p.showOrigin = opts.OptShowOrigin
+	p.printOrigin = opts.OptPrintOrigin
+	p.follow = opts.OptFollow
+	if p.follow && len(p.args) < 2 {
+		return errors.New("--follow can only be used with files")
+	}
+
+	switch opts.OptOutput {
+	case "", OutputText:
+	case OutputJSON:
+		p.outputJSON = true
+	default:
+		return errors.Errorf("unknown output format: '%s'", opts.OptOutput)
+	}
+	if v := opts.OptOutputFormat; v != "" {
+		if p.outputJSON {
+			return errors.New("--output-format cannot be used with --output json")
+		}
+		tmpl, err := template.New("output").Parse(v)
+		if err != nil {
+			return errors.Wrap(err, "failed to parse output format")
+		}
+		p.outputFormat = tmpl
+	}
+	if v := opts.OptFilter; v != nil {
+		p.filterMode = true
+		p.filterQuery = *v
+	}
+	p.printIndices = opts.OptPrintIndices
 	p.initialQuery = opts.OptQuery
 	p.initialFilter = opts.OptInitialFilter
 	if len(p.initialFilter) <= 0 {
@@ -569,10 +859,34 @@ func (p *Peco) ApplyConfig(opts CLIOptions) error {
 		p.initialFilter = opts.OptInitialMatcher
 	}
 
+	if err := p.populateFields(opts); err != nil {
+		return errors.Wrap(err, "failed to populate fields")
+	}
+
+	if err := p.populateJSON(opts); err != nil {
+		return errors.Wrap(err, "failed to populate JSON")
+	}
+
+	if err := p.populateReload(opts); err != nil {
+		return errors.Wrap(err, "failed to populate reload")
+	}
+
+	if err := p.populatePreview(opts); err != nil {
+		return errors.Wrap(err, "failed to populate preview")
+	}
+
+	if err := p.populateHistory(opts); err != nil {
+		return errors.Wrap(err, "failed to populate history")
+	}
+
 	if err := p.populateFilters(); err != nil {
 		return errors.Wrap(err, "failed to populate filters")
 	}
 
+	if err := p.populateExpect(opts); err != nil {
+		return errors.Wrap(err, "failed to populate expected keys")
+	}
+
 	if err := p.populateKeymap(); err != nil {
 		return errors.Wrap(err, "failed to populate keymap")
 	}
@@ -592,6 +906,120 @@ func (p *Peco) ApplyConfig(opts CLIOptions) error {
 	return nil
 }
 
+func (p *Peco) populateFields(opts CLIOptions) error {
+	pick := func(opt, cfg string) string {
+		if opt != "" {
+			return opt
+		}
+		return cfg
+	}
+
+	fields, err := line.NewFields(
+		pick(opts.OptDelimiter, p.config.Delimiter),
+		pick(opts.OptNth, p.config.Nth),
+		pick(opts.OptWithNth, p.config.WithNth),
+		pick(opts.OptOutputNth, p.config.OutputNth),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse field specification")
+	}
+	p.fields = fields
+	return nil
+}
+
+func (p *Peco) populateJSON(opts CLIOptions) error {
+	cfg := p.config.JSON
+	if v := opts.OptJSONDisplay; v != "" {
+		cfg.Display = v
+	}
+	if v := opts.OptJSONMatch; v != "" {
+		cfg.Match = v
+	}
+	if v := opts.OptJSONOutput; v != "" {
+		cfg.Output = v
+	}
+	if !opts.OptJSON {
+		return nil
+	}
+
+	f, err := line.NewJSONFormat(cfg.Display, cfg.Match, cfg.Output)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse JSON format")
+	}
+	p.json = f
+	return nil
+}
+
+func (p *Peco) populatePreview(opts CLIOptions) error {
+	cfg := p.config.Preview
+	if v := opts.OptPreview; v != "" {
+		cfg.Command = v
+	}
+	if v := opts.OptPreviewPosition; v != "" {
+		cfg.Position = v
+	}
+	if cfg.Command == "" {
+		return nil
+	}
+
+	if cfg.Position != "" && !IsValidPreviewPosition(cfg.Position) {
+		return errors.Errorf("unknown preview position: '%s'", cfg.Position)
+	}
+	p.preview = NewPreview(cfg.Command, cfg.Position, cfg.Size, cfg.Hidden, p.Styles(), p.use256Color)
+	return nil
+}
+
+func (p *Peco) populateReload(opts CLIOptions) error {
+	p.sourceCommand = opts.OptSourceCommand
+	p.dynamicSource = opts.OptDynamicSource
+	if p.sourceCommand != "" && p.dynamicSource != "" {
+		return errors.New("--source-command and --dynamic-source cannot be used together")
+	}
+	if v := opts.OptReloadInterval; v != "" {
+		if p.sourceCommand == "" {
+			return errors.New("--reload-interval requires --source-command")
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return errors.Errorf("invalid reload interval: '%s'", v)
+		}
+		p.reloadInterval = d
+	}
+	return nil
+}
+
+func (p *Peco) populateHistory(opts CLIOptions) error {
+	cfg := p.config.History
+	path := opts.OptHistory
+	if path == "" {
+		if cfg.Disable {
+			return nil
+		}
+		path = cfg.Path
+	}
+	if path == "" {
+		var err error
+		if path, err = defaultHistoryPath(); err != nil {
+			// Without a place to keep it, there is no history
+			return nil
+		}
+	}
+
+	h := NewHistory(path, opts.OptHistoryContext, cfg.Size)
+	if err := h.Load(); err != nil {
+		// A broken history file should not keep peco from starting.
+		// The error is shown once the screen is ready
+		if pdebug.Enabled {
+			pdebug.Printf("failed to load history: %s", err)
+		}
+		p.history = nil
+		p.historyErr = errors.Wrap(err, "failed to load history")
+		return nil
+	}
+	p.history = h
+	return nil
+}
+
 func (p *Peco) populateInitialFilter() error {
 	if v := p.initialFilter; len(v) > 0 {
 		if err := p.filters.SetCurrentByName(v); err != nil {
@@ -621,21 +1049,54 @@ func (p *Peco) populateSingleKeyJump() error {
 func (p *Peco) populateFilters() error {
 	p.filters.Add(filter.NewIgnoreCase())
 	p.filters.Add(filter.NewCaseSensitive())
+	p.filters.Add(filter.NewLiteralIgnoreCase())
+	p.filters.Add(filter.NewLiteralCaseSensitive())
 	p.filters.Add(filter.NewSmartCase())
 	p.filters.Add(filter.NewRegexp())
 	p.filters.Add(filter.NewFuzzy())
+	p.filters.Add(filter.NewScoredFuzzy())
+	p.filters.Add(filter.NewExtended())
 
 	for name, c := range p.config.CustomFilter {
-		f := filter.NewExternalCmd(name, c.Cmd, c.Args, c.BufferThreshold, p.idgen, p.enableSep)
+		f := filter.NewExternalCmd(name, c.Cmd, c.Args, c.BufferThreshold, p.idgen, p.enableSep, c.Persistent, c.LineID, time.Duration(c.Timeout)*time.Millisecond)
 		p.filters.Add(f)
 	}
 
 	return nil
 }
 
+func (p *Peco) populateExpect(opts CLIOptions) error {
+	names := p.config.Expect
+	if v := opts.OptExpect; v != "" {
+		names = append(names, v)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	keys, err := keyseq.ToKeyList(strings.Join(names, ","))
+	if err != nil {
+		return errors.Wrap(err, "failed to parse expected keys")
+	}
+	for _, k := range keys {
+		p.expect = append(p.expect, k.String())
+	}
+	return nil
+}
+
+// isExpectedKey returns true if name is one of the keys given by --expect
+func (p *Peco) isExpectedKey(name string) bool {
+	for _, k := range p.expect {
+		if k == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *Peco) populateKeymap() error {
 	// Create a new keymap object
-	k := NewKeymap(p.config.Keymap, p.config.Action)
+	k := NewKeymap(p.config.Keymap, p.config.Action, p.config.ModeKeymap)
 	if err := k.ApplyKeybinding(); err != nil {
 		return errors.Wrap(err, "failed to apply key bindings")
 	}
@@ -666,16 +1127,20 @@ func (p *Peco) SetCurrentLineBuffer(b Buffer) {
 }
 
 func (p *Peco) ResetCurrentLineBuffer() {
-	p.SetCurrentLineBuffer(p.source)
+	p.SetCurrentLineBuffer(p.currentSource())
 }
 
 func (p *Peco) sendQuery(ctx context.Context, q string, nextFunc func()) {
 	if pdebug.Enabled {
-		g := pdebug.Marker("sending query to filter goroutine (q=%v, isInfinite=%t)", q, p.source.IsInfinite())
+		g := pdebug.Marker("sending query to filter goroutine (q=%v, isInfinite=%t)", q, p.currentSource().IsInfinite())
 		defer g.End()
 	}
 
-	if p.source.IsInfinite() {
+	if p.dynamicSource != "" {
+		q = p.updateDynamicSource(q)
+	}
+
+	if p.currentSource().IsInfinite() {
 		// If the source is a stream, we can't do batch mode, and hence
 		// we can't guarantee proper timing. But... okay, we simulate
 		// something like it
@@ -719,7 +1184,7 @@ func (p *Peco) ExecQuery(nextFunc func()) bool {
 	// If this is an empty query, reset the display to show
 	// the raw source buffer
 	q := p.Query()
-	if q.Len() <= 0 {
+	if q.Len() <= 0 && p.dynamicSource == "" {
 		if pdebug.Enabled {
 			pdebug.Printf("empty query, reset buffer")
 		}
@@ -777,9 +1242,9 @@ func (p *Peco) ExecQuery(nextFunc func()) bool {
 	return true
 }
 
-func (p *Peco) PrintResults() {
+func (p *Peco) PrintResults() (err error) {
 	if pdebug.Enabled {
-		g := pdebug.Marker("Peco.PrintResults")
+		g := pdebug.Marker("Peco.PrintResults").BindError(&err)
 		defer g.End()
 	}
 	selection := p.Selection()
@@ -797,18 +1262,38 @@ func (p *Peco) PrintResults() {
 		})
 	}()
 
+	var lines []line.Line
+	for l := range p.ResultCh() {
+		lines = append(lines, l)
+	}
+
 	var buf bytes.Buffer
 
 	if pdebug.Enabled {
 		pdebug.Printf("--print-query was %t", p.printQuery)
 	}
-	if p.printQuery {
+	if p.printQuery && !p.outputJSON {
 		buf.WriteString(p.Query().String())
 		buf.WriteByte('\n')
 	}
-	for line := range p.ResultCh() {
-		buf.WriteString(line.Output())
+	if len(p.expect) > 0 && !p.outputJSON {
+		// The line is empty unless one of the expected keys was used,
+		// so that scripts can always find the results on the next line
+		if p.isExpectedKey(p.finishKey) {
+			buf.WriteString(p.finishKey)
+		}
 		buf.WriteByte('\n')
 	}
-	p.Stdout.Write(buf.Bytes())
+	formatted, err := p.formatResults(&buf, lines, p.Query().String())
+	if err != nil {
+		return errors.Wrap(err, "failed to format results")
+	}
+	if !formatted {
+		for _, l := range lines {
+			buf.WriteString(p.outputString(l))
+			buf.WriteByte('\n')
+		}
+	}
+	_, err = p.Stdout.Write(buf.Bytes())
+	return err
 }
@@ -5,7 +5,11 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -20,6 +24,20 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+func TestMain(m *testing.M) {
+	// Keep the queries from the tests out of the user's history
+	dir, err := ioutil.TempDir("", "peco-data-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create data directory: %s\n", err)
+		os.Exit(1)
+	}
+	os.Setenv("XDG_DATA_HOME", dir)
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 type nullHub struct{}
 
 func (h nullHub) Batch(_ context.Context, _ func(context.Context), _ bool)           {}
@@ -489,3 +507,126 @@ func TestPrintQuery(t *testing.T) {
 		}
 	})
 }
+
+func TestFilterMode(t *testing.T) {
+	testValues := []struct {
+		name   string
+		args   []string
+		input  string
+		output string
+		status int
+	}{
+		{"Match", []string{"--filter", "foo"}, "foo bar\nbaz\nbarfoo\n", "foo bar\nbarfoo\n", 0},
+		{"Empty query", []string{"--filter", ""}, "foo\nbar\n", "foo\nbar\n", 0},
+		{"Indices", []string{"--filter", "a", "--print-indices"}, "foo\nbar\n", "bar\t[[1,2]]\n", 0},
+		{"Ranked", []string{"--filter", "fo", "--initial-filter", "ScoredFuzzy"}, "fxo\nfoo\n", "foo\nfxo\n", 0},
+		{"Output format", []string{"--filter", "a", "--output-format", "{{.Index}} {{.Line}} {{.Matches}}"}, "foo\nbar\n", "1 bar [[1 2]]\n", 0},
+		{"Output JSON", []string{"--filter", "a", "--output", "json", "--print-query"}, "foo\nbar\n", `{"query":"a","filter":"IgnoreCase","key":"","action":"","selection":[{"index":1,"line":"bar","display":"bar","matches":[[1,2]],"origin":"-:2"}]}` + "\n", 0},
+		{"Print origin", []string{"--filter", "a", "--print-origin"}, "foo\nbar\n", "-:2:bar\n", 0},
+		{"No match", []string{"--filter", "qux"}, "foo\nbar\n", "", exitStatusNoMatch},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			screen := NewDummyScreen()
+			p := newPeco()
+			p.Argv = append([]string{"peco"}, v.args...)
+			p.Stdin = bytes.NewBufferString(v.input)
+			p.screen = screen
+			var out bytes.Buffer
+			p.Stdout = &out
+
+			err := p.Run(ctx)
+			if v.status == 0 {
+				if !assert.NoError(t, err, "p.Run() should succeed") {
+					return
+				}
+			} else {
+				if !assert.True(t, util.IsIgnorableError(err), "p.Run() should return an ignorable error") {
+					return
+				}
+				st, ok := util.GetExitStatus(err)
+				if !assert.True(t, ok, "error should have an exit status") {
+					return
+				}
+				assert.Equal(t, v.status, st, "exit status should match")
+			}
+			assert.Equal(t, v.output, out.String(), "output should match")
+			assert.Empty(t, screen.events, "screen should not be used")
+		})
+	}
+
+	t.Run("Filter error", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		p := newPeco()
+		p.Argv = []string{"peco", "--filter", "(", "--initial-filter", "Regexp"}
+		p.Stdin = bytes.NewBufferString("foo\n")
+		p.Stdout = &bytes.Buffer{}
+
+		err := p.Run(ctx)
+		if !assert.Error(t, err, "p.Run() should fail") {
+			return
+		}
+		assert.False(t, util.IsIgnorableError(err), "error should not be ignorable")
+	})
+}
+
+func TestInputFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peco-input-")
+	if !assert.NoError(t, err, "ioutil.TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"a.txt": "apple\nbanana\n",
+		"b.txt": "cherry\navocado\n",
+		"c.log": "grape\napricot\n",
+	}
+	for name, content := range files {
+		if !assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644), "ioutil.WriteFile should succeed") {
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := newPeco()
+	p.Argv = []string{"peco", "--filter", "a", "--print-origin", filepath.Join(dir, "*.txt"), filepath.Join(dir, "c.log")}
+	var out bytes.Buffer
+	p.Stdout = &out
+	if !assert.NoError(t, p.Run(ctx), "p.Run() should succeed") {
+		return
+	}
+
+	// The files are read concurrently, so the lines may be in any order
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	sort.Strings(lines)
+	expected := []string{
+		filepath.Join(dir, "a.txt") + ":1:apple",
+		filepath.Join(dir, "a.txt") + ":2:banana",
+		filepath.Join(dir, "b.txt") + ":2:avocado",
+		filepath.Join(dir, "c.log") + ":1:grape",
+		filepath.Join(dir, "c.log") + ":2:apricot",
+	}
+	assert.Equal(t, expected, lines, "lines from all of the files should be printed with their origin")
+
+	_, err = expandInputFiles([]string{filepath.Join(dir, "*.md")})
+	assert.Error(t, err, "patterns that match nothing should fail")
+
+	literal := filepath.Join(dir, "log[1].txt")
+	if !assert.NoError(t, ioutil.WriteFile(literal, []byte("lemon\n"), 0644), "ioutil.WriteFile should succeed") {
+		return
+	}
+	expanded, err := expandInputFiles([]string{literal})
+	if !assert.NoError(t, err, "expandInputFiles should succeed") {
+		return
+	}
+	assert.Equal(t, []string{literal}, expanded, "existing files should not be treated as patterns")
+}
@@ -0,0 +1,242 @@
+package peco
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/pdebug"
+	"github.com/peco/peco/internal/util"
+	"github.com/peco/peco/line"
+)
+
+// NewPreview creates a new Preview that runs `command` for the current
+// line. Occurrences of {} in the command are replaced with the line,
+// quoted for the shell. `size` is the percentage of the screen used by
+// the preview pane
+func NewPreview(command, position string, size int, hidden bool, styles *StyleSet, use256Color bool) *Preview {
+	if position == "" {
+		position = PreviewPositionRight
+	}
+	if size <= 0 || size >= 100 {
+		size = DefaultPreviewSize
+	}
+	return &Preview{
+		command:     command,
+		position:    position,
+		size:        size,
+		styles:      styles,
+		use256Color: use256Color,
+		visible:     !hidden,
+	}
+}
+
+// IsValidPreviewPosition checks if the specified position is supported
+func IsValidPreviewPosition(v string) bool {
+	return v == PreviewPositionRight || v == PreviewPositionBottom
+}
+
+// Position returns where the preview pane is placed
+func (pv *Preview) Position() string {
+	return pv.position
+}
+
+// Size returns the percentage of the screen used by the preview pane
+func (pv *Preview) Size() int {
+	return pv.size
+}
+
+// Visible returns true if the preview pane should be displayed
+func (pv *Preview) Visible() bool {
+	pv.mutex.Lock()
+	defer pv.mutex.Unlock()
+	return pv.visible
+}
+
+// Toggle shows or hides the preview pane. While the pane is hidden,
+// the command is not run
+func (pv *Preview) Toggle() {
+	pv.mutex.Lock()
+	defer pv.mutex.Unlock()
+
+	pv.visible = !pv.visible
+	if !pv.visible {
+		pv.reset()
+	}
+}
+
+// Scroll moves the preview output by n lines
+func (pv *Preview) Scroll(n int) bool {
+	pv.mutex.Lock()
+	defer pv.mutex.Unlock()
+
+	offset := pv.offset + n
+	if offset > len(pv.lines)-1 {
+		offset = len(pv.lines) - 1
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if offset == pv.offset {
+		return false
+	}
+	pv.offset = offset
+	return true
+}
+
+// Lines returns the lines of output, starting from the current scroll
+// position
+func (pv *Preview) Lines() [][]ansiSegment {
+	pv.mutex.Lock()
+	defer pv.mutex.Unlock()
+	if pv.offset >= len(pv.lines) {
+		return nil
+	}
+	return pv.lines[pv.offset:]
+}
+
+// Update makes sure that the preview is for the given line. If it isn't,
+// the command for the previous line is canceled, and a new one is
+// started. A nil line clears the preview
+func (pv *Preview) Update(state *Peco, l line.Line) {
+	pv.mutex.Lock()
+	defer pv.mutex.Unlock()
+
+	if l == nil {
+		pv.reset()
+		return
+	}
+	if pv.cancel != nil && pv.target == l.ID() {
+		return
+	}
+
+	pv.reset()
+	ctx, cancel := context.WithCancel(context.Background())
+	pv.cancel = cancel
+	pv.target = l.ID()
+	pv.generation++
+
+	cmd := util.Shell(expandPreviewCommand(pv.command, l.Output()))
+	go pv.run(ctx, cancel, state, cmd, pv.generation)
+}
+
+// Stop cancels the running command, if any
+func (pv *Preview) Stop() {
+	pv.mutex.Lock()
+	defer pv.mutex.Unlock()
+	pv.reset()
+}
+
+// reset cancels the running command, and clears the output. Must be
+// called while holding the lock
+func (pv *Preview) reset() {
+	if pv.cancel != nil {
+		pv.cancel()
+		pv.cancel = nil
+	}
+	pv.generation++
+	pv.lines = nil
+	pv.offset = 0
+}
+
+// appendLine adds a line of output for the command started as
+// `generation`. Returns false if the output is no longer needed
+func (pv *Preview) appendLine(generation uint64, segments []ansiSegment) bool {
+	pv.mutex.Lock()
+	defer pv.mutex.Unlock()
+
+	if pv.generation != generation || len(pv.lines) >= previewMaxLines {
+		return false
+	}
+	pv.lines = append(pv.lines, segments)
+	pv.changed = true
+	return true
+}
+
+// takeChanged returns true if output was added since the last call
+func (pv *Preview) takeChanged() bool {
+	pv.mutex.Lock()
+	defer pv.mutex.Unlock()
+
+	changed := pv.changed
+	pv.changed = false
+	return changed
+}
+
+func (pv *Preview) run(ctx context.Context, cancel func(), state *Peco, cmd *exec.Cmd, generation uint64) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Preview.run %v", cmd.Args)
+		defer g.End()
+	}
+	defer cancel()
+
+	done := make(chan struct{})
+	defer close(done)
+	go pv.drawLoop(state, done)
+
+	ansi := newANSIState(pv.styles.Basic.fg, pv.styles.Basic.bg, pv.use256Color)
+
+	// Use an actual pipe, so that Wait() does not wait for processes
+	// that the command may have left running in the background
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		pv.appendLine(generation, ansi.Parse("failed to create pipe: "+err.Error()))
+		return
+	}
+	defer pr.Close()
+
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	err = cmd.Start()
+	pw.Close()
+	if err != nil {
+		pv.appendLine(generation, ansi.Parse("failed to run preview command: "+err.Error()))
+		return
+	}
+	go cmd.Wait()
+
+	go func() {
+		// Stop the command once the output is no longer needed.
+		// Closing the reader also stops the loop below
+		<-ctx.Done()
+		cmd.Process.Kill()
+		pr.Close()
+	}()
+
+	scanner := bufio.NewScanner(pr)
+	for scanner.Scan() {
+		if !pv.appendLine(generation, ansi.Parse(scanner.Text())) {
+			return
+		}
+	}
+}
+
+// drawLoop requests the screen to be redrawn while output is being
+// read, at most once every previewDrawInterval
+func (pv *Preview) drawLoop(state *Peco, done chan struct{}) {
+	t := time.NewTicker(previewDrawInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-done:
+			if pv.takeChanged() {
+				state.Hub().SendDraw(context.Background(), nil)
+			}
+			return
+		case <-t.C:
+			if pv.takeChanged() {
+				state.Hub().SendDraw(context.Background(), nil)
+			}
+		}
+	}
+}
+
+// expandPreviewCommand replaces all occurrences of {} in command with
+// the shell quoted line
+func expandPreviewCommand(command, l string) string {
+	return strings.Replace(command, "{}", util.ShellQuote(l), -1)
+}
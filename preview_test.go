@@ -0,0 +1,151 @@
+package peco
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peco/peco/filter"
+	"github.com/peco/peco/hub"
+	"github.com/peco/peco/line"
+	"github.com/stretchr/testify/assert"
+)
+
+func previewText(pv *Preview) []string {
+	var ret []string
+	for _, segments := range pv.Lines() {
+		var buf strings.Builder
+		for _, seg := range segments {
+			buf.WriteString(seg.text)
+		}
+		ret = append(ret, buf.String())
+	}
+	return ret
+}
+
+func waitPreview(t *testing.T, pv *Preview, n int) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if len(pv.Lines()) >= n {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return assert.Fail(t, "timed out waiting for preview output")
+}
+
+func TestPreview(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses /bin/sh")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	state := newPeco()
+	state.hub = hub.New(5)
+	go drainHub(ctx, state.Hub())
+
+	pv := NewPreview("echo {}; printf '\\033[31mred\\033[0m\\n'", "", 0, false, state.Styles(), false)
+	defer pv.Stop()
+
+	pv.Update(state, line.NewRaw(1, "it's a line", false))
+	if !waitPreview(t, pv, 2) {
+		return
+	}
+	assert.Equal(t, []string{"it's a line", "red"}, previewText(pv), "output should be available")
+
+	assert.True(t, pv.Scroll(1), "should be able to scroll down")
+	assert.Equal(t, []string{"red"}, previewText(pv), "output should be scrolled")
+	assert.False(t, pv.Scroll(1), "should not scroll past the last line")
+	assert.True(t, pv.Scroll(-10), "should be able to scroll up")
+	assert.False(t, pv.Scroll(-1), "should not scroll past the first line")
+
+	// Updating with the same line should not run the command again
+	pv.Update(state, line.NewRaw(1, "it's a line", false))
+	assert.Len(t, pv.Lines(), 2, "output should be kept")
+
+	pv.Update(state, line.NewRaw(2, "another", false))
+	if !waitPreview(t, pv, 2) {
+		return
+	}
+	assert.Equal(t, []string{"another", "red"}, previewText(pv), "output should be for the new line")
+
+	pv.Toggle()
+	assert.False(t, pv.Visible(), "preview should be hidden")
+	assert.Empty(t, pv.Lines(), "output should be discarded")
+
+	t.Run("Cancel", func(t *testing.T) {
+		pv := NewPreview("echo start; sleep 10; echo end", "", 0, false, state.Styles(), false)
+		pv.Update(state, line.NewRaw(1, "foo", false))
+		if !waitPreview(t, pv, 1) {
+			return
+		}
+
+		start := time.Now()
+		pv.Update(state, line.NewRaw(2, "bar", false))
+		if !waitPreview(t, pv, 1) {
+			return
+		}
+		assert.True(t, time.Since(start) < 5*time.Second, "previous command should not block the next")
+		pv.Stop()
+	})
+}
+
+func TestExpandPreviewCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses /bin/sh quoting")
+	}
+	assert.Equal(t, `cat 'foo bar' | head -n 'foo bar'`, expandPreviewCommand("cat {} | head -n {}", "foo bar"))
+	assert.Equal(t, `echo 'it'\''s'`, expandPreviewCommand("echo {}", "it's"))
+	assert.Equal(t, `date`, expandPreviewCommand("date", "foo"))
+}
+
+func TestPreviewLayout(t *testing.T) {
+	testValues := []struct {
+		name      string
+		layout    string
+		position  string
+		hidden    bool
+		rect      previewRect
+		visible   bool
+		perPage   int
+		listWidth int
+	}{
+		{"Right", LayoutTypeTopDown, PreviewPositionRight, false, previewRect{41, 1, 39, 8}, true, 8, 40},
+		{"Bottom", LayoutTypeTopDown, PreviewPositionBottom, false, previewRect{0, 6, 80, 3}, true, 4, 80},
+		{"Bottom (bottom-up)", LayoutTypeBottomUp, PreviewPositionBottom, false, previewRect{0, 0, 80, 3}, true, 4, 80},
+		{"Right (bottom-up)", LayoutTypeBottomUp, PreviewPositionRight, false, previewRect{41, 0, 39, 8}, true, 8, 40},
+		{"Hidden", LayoutTypeTopDown, PreviewPositionRight, true, previewRect{}, false, 8, 80},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			state := newPeco()
+			state.hub = hub.New(5)
+			state.Filters().Add(filter.NewIgnoreCase())
+			state.SetCurrentLineBuffer(newTestMemoryBuffer(line.NewRaw(0, "foo", false)))
+			state.preview = NewPreview("true", v.position, 50, v.hidden, state.Styles(), false)
+			defer state.preview.Stop()
+
+			var l *BasicLayout
+			if v.layout == LayoutTypeBottomUp {
+				l = NewBottomUpLayout(state)
+			} else {
+				l = NewDefaultLayout(state)
+			}
+
+			r, ok := l.previewRect()
+			if !assert.Equal(t, v.visible, ok, "preview visibility should match") {
+				return
+			}
+			assert.Equal(t, v.rect, r, "preview area should match")
+			assert.Equal(t, v.perPage, l.linesPerPage(), "lines per page should match")
+
+			l.DrawScreen(state, nil)
+			assert.Equal(t, v.listWidth, l.list.Width(), "list width should match")
+		})
+	}
+}
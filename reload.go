@@ -0,0 +1,116 @@
+package peco
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"os/signal"
+	"time"
+
+	"github.com/lestrrat-go/pdebug"
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/internal/util"
+	"github.com/pkg/errors"
+)
+
+// reloadSource runs the source command again, and replaces the lines
+// with its output once it has all been read. A reload that is still
+// running is cancelled
+func (p *Peco) reloadSource(ctx context.Context) error {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Peco.reloadSource")
+		defer g.End()
+	}
+
+	if p.sourceCommand == "" {
+		return errors.New("no source command specified")
+	}
+
+	if err := p.reloadCommand(ctx, util.Shell(p.sourceCommand)); err != nil {
+		return errors.Wrap(err, "failed to reload source")
+	}
+	return nil
+}
+
+// reloadCommand replaces the lines with the output of cmd, cancelling
+// the reload that is still running, if any. No error is returned if
+// this reload is cancelled in turn
+func (p *Peco) reloadCommand(ctx context.Context, cmd *exec.Cmd) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	p.reloadMutex.Lock()
+	if p.reloadCancel != nil {
+		p.reloadCancel()
+	}
+	p.reloadCancel = cancel
+	p.reloadMutex.Unlock()
+
+	if err := p.readSource(ctx, cmd); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// cancelReload cancels the reload, or the dynamic source command, that
+// is running
+func (p *Peco) cancelReload() {
+	p.reloadMutex.Lock()
+	defer p.reloadMutex.Unlock()
+	if p.reloadCancel != nil {
+		p.reloadCancel()
+	}
+}
+
+// reloadLoop reloads the source every reloadInterval, and whenever one
+// of reloadSignals is received
+func (p *Peco) reloadLoop(ctx context.Context) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Peco.reloadLoop")
+		defer g.End()
+	}
+
+	var tick <-chan time.Time
+	if p.reloadInterval > 0 {
+		ticker := time.NewTicker(p.reloadInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	if len(reloadSignals) > 0 {
+		signal.Notify(sigCh, reloadSignals...)
+		defer signal.Stop(sigCh)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		case <-sigCh:
+		}
+
+		if err := p.reloadSource(ctx); err != nil {
+			p.Hub().SendStatusMsgAndClear(context.Background(), err.Error(), executeStatusClearDelay)
+		}
+	}
+}
+
+func doReloadSource(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doReloadSource")
+		defer g.End()
+	}
+
+	if state.sourceCommand == "" {
+		state.Hub().SendStatusMsg(ctx, "No source command specified")
+		return
+	}
+
+	go func() {
+		if err := state.reloadSource(ctx); err != nil {
+			state.Hub().SendStatusMsgAndClear(context.Background(), err.Error(), executeStatusClearDelay)
+		}
+	}()
+}
@@ -0,0 +1,11 @@
+// +build !windows
+
+package peco
+
+import (
+	"os"
+	"syscall"
+)
+
+// reloadSignals are the signals that make peco reload the source command
+var reloadSignals = []os.Signal{syscall.SIGUSR1}
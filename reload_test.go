@@ -0,0 +1,112 @@
+// +build !windows
+
+package peco
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/btree"
+	"github.com/peco/peco/line"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadSource(t *testing.T) {
+	state := newPeco()
+	state.Argv = []string{"peco", "--source-command", `printf 'foo\nbar\nbaz\n'`}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go state.Run(ctx)
+
+	<-state.Ready()
+	<-state.currentSource().SetupDone()
+
+	bar, err := state.currentSource().LineAt(1)
+	if !assert.NoError(t, err, "LineAt should succeed") {
+		return
+	}
+	state.Selection().Add(bar)
+
+	state.sourceCommand = `printf 'qux\nbar\n'`
+	if !assert.NoError(t, state.reloadSource(ctx), "reloadSource should succeed") {
+		return
+	}
+
+	src := state.currentSource()
+	if !assert.Equal(t, 2, src.Size(), "the lines should be replaced") {
+		return
+	}
+	var selected []line.Line
+	state.Selection().Ascend(func(it btree.Item) bool {
+		selected = append(selected, it.(line.Line))
+		return true
+	})
+	if assert.Len(t, selected, 1, "the selection should be kept") {
+		assert.Equal(t, "bar", selected[0].Buffer(), "the same line should be selected")
+		assert.NotEqual(t, bar.ID(), selected[0].ID(), "the new line should be selected")
+	}
+
+	state.sourceCommand = `echo partial; exit 1`
+	assert.Error(t, state.reloadSource(ctx), "reloadSource should fail")
+	assert.Equal(t, src, state.currentSource(), "the lines should be kept if the command fails")
+}
+
+func TestReloadBeforeInput(t *testing.T) {
+	// A reload may be requested before the command prints anything
+	state := newPeco()
+	state.sourceCommand = "echo foo"
+	assert.Error(t, state.reloadSource(context.Background()), "reloadSource should fail without an input to replace")
+}
+
+func TestPopulateReload(t *testing.T) {
+	testValues := []struct {
+		argv     []string
+		interval time.Duration
+		ok       bool
+	}{
+		{[]string{"--source-command", "ls"}, 0, true},
+		{[]string{"--source-command", "ls", "--reload-interval", "5s"}, 5 * time.Second, true},
+		{[]string{"--source-command", "ls", "--reload-interval", "5"}, 0, false},
+		{[]string{"--reload-interval", "5s"}, 0, false},
+	}
+
+	for _, v := range testValues {
+		var opts CLIOptions
+		if _, err := opts.parse(v.argv); !assert.NoError(t, err, "parse should succeed") {
+			return
+		}
+		p := New()
+		err := p.populateReload(opts)
+		if !v.ok {
+			assert.Error(t, err, "populateReload(%v) should fail", v.argv)
+			continue
+		}
+		if assert.NoError(t, err, "populateReload(%v) should succeed", v.argv) {
+			assert.Equal(t, v.interval, p.reloadInterval, "interval should match")
+		}
+	}
+}
+
+func TestExecuteReloadCancelsReload(t *testing.T) {
+	state := newPeco()
+	state.Argv = []string{"peco", "--source-command", `echo foo`}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go state.Run(ctx)
+
+	<-state.Ready()
+	<-state.currentSource().SetupDone()
+
+	// The slow reload would replace the lines after the fast one
+	state.sourceCommand = `sleep 1; echo slow`
+	go state.reloadSource(ctx)
+	time.Sleep(100 * time.Millisecond)
+	doExecute(ctx, state, executeReload, `echo fast`)
+
+	time.Sleep(1500 * time.Millisecond)
+	l, err := state.currentSource().LineAt(0)
+	if assert.NoError(t, err, "LineAt should succeed") {
+		assert.Equal(t, "fast", l.Buffer(), "the previous reload should be cancelled")
+	}
+}
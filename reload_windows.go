@@ -0,0 +1,7 @@
+package peco
+
+import "os"
+
+// reloadSignals are the signals that make peco reload the source
+// command. There are none on Windows
+var reloadSignals []os.Signal
@@ -148,6 +148,7 @@ type PrintArgs struct {
 	Bg      termbox.Attribute
 	Msg     string
 	Fill    bool
+	MaxX    int // if > 0, nothing is drawn at or beyond this column
 }
 
 func (t *Termbox) Print(args PrintArgs) int {
@@ -163,6 +164,12 @@ func screenPrint(t Screen, args PrintArgs) int {
 	x := args.X
 	y := args.Y
 	xOffset := args.XOffset
+	setCell := func(x, y int, c rune, fg, bg termbox.Attribute) {
+		if args.MaxX > 0 && x >= args.MaxX {
+			return
+		}
+		t.SetCell(x, y, c, fg, bg)
+	}
 	for len(msg) > 0 {
 		c, w := utf8.DecodeRuneInString(msg)
 		if c == utf8.RuneError {
@@ -174,12 +181,12 @@ func screenPrint(t Screen, args PrintArgs) int {
 			// In case we found a tab, we draw it as 4 spaces
 			n := 4 - (x+xOffset)%4
 			for i := int(0); i <= n; i++ {
-				t.SetCell(int(x+i), int(y), ' ', fg, bg)
+				setCell(int(x+i), int(y), ' ', fg, bg)
 			}
 			written += n
 			x += n
 		} else {
-			t.SetCell(int(x), int(y), c, fg, bg)
+			setCell(int(x), int(y), c, fg, bg)
 			n := int(runewidth.RuneWidth(c))
 			x += n
 			written += n
@@ -191,6 +198,9 @@ func screenPrint(t Screen, args PrintArgs) int {
 	}
 
 	width, _ := t.Size()
+	if args.MaxX > 0 && args.MaxX < width {
+		width = args.MaxX
+	}
 	for ; x < int(width); x++ {
 		t.SetCell(int(x), int(y), ' ', fg, bg)
 	}
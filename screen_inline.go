@@ -0,0 +1,427 @@
+// +build !windows
+
+package peco
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/lestrrat-go/pdebug"
+	"github.com/mattn/go-runewidth"
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/internal/util"
+	"github.com/pkg/errors"
+)
+
+// NewInlineScreen creates a new InlineScreen that is `lines` lines
+// high, or `lines` percent of the terminal if percent is true
+func NewInlineScreen(lines int, percent bool) (Screen, error) {
+	return &InlineScreen{
+		lines:     lines,
+		percent:   percent,
+		cursorX:   -1,
+		cursorY:   -1,
+		resizeCh:  make(chan os.Signal, 1),
+		suspendCh: make(chan chan struct{}),
+		resumeCh:  make(chan chan struct{}),
+		done:      make(chan struct{}),
+	}, nil
+}
+
+// Init opens the terminal, and reserves the region that we draw to
+func (s *InlineScreen) Init(cfg *Config) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Open the terminal ourselves, so that reads are not handled by the
+	// runtime poller, and honor the timeout set by util.MakeRaw
+	fd, err := syscall.Open("/dev/tty", syscall.O_RDWR, 0)
+	if err != nil {
+		return errors.Wrap(err, "failed to open /dev/tty")
+	}
+	s.tty = os.NewFile(uintptr(fd), "/dev/tty")
+	s.use256Color = cfg.Use256Color
+	s.mouse = cfg.Mouse
+
+	if err := s.start(); err != nil {
+		s.tty.Close()
+		s.tty = nil
+		return errors.Wrap(err, "failed to initialize terminal")
+	}
+	signal.Notify(s.resizeCh, syscall.SIGWINCH)
+	return nil
+}
+
+// start puts the terminal into raw mode, and reserves the region
+func (s *InlineScreen) start() error {
+	restore, err := util.MakeRaw(s.tty.Fd())
+	if err != nil {
+		return err
+	}
+	s.restore = restore
+	s.reserve()
+	if s.mouse {
+		s.out.WriteString("\x1b[?1000h\x1b[?1006h")
+	}
+	if err := s.write(); err != nil {
+		return err
+	}
+	s.locate()
+	return nil
+}
+
+// stop clears the region, and restores the terminal
+func (s *InlineScreen) stop() error {
+	s.moveTo(0, 0)
+	s.out.WriteString("\x1b[0m\x1b[J\x1b[?25h")
+	if s.mouse {
+		s.out.WriteString("\x1b[?1006l\x1b[?1000l")
+	}
+	if err := s.write(); err != nil {
+		return err
+	}
+	if s.restore == nil {
+		return nil
+	}
+	err := s.restore()
+	s.restore = nil
+	return err
+}
+
+// reserve makes room for the region below the cursor, scrolling the
+// terminal if necessary, and places the cursor at its top
+func (s *InlineScreen) reserve() {
+	width, height, err := util.TtySize(s.tty.Fd())
+	if err != nil || width <= 0 || height <= 0 {
+		// Fallback to the size of the traditional terminal
+		width, height = 80, 24
+	}
+
+	s.width = width
+	s.height = inlineHeight(s.lines, s.percent, height)
+	s.back = make([]inlineCell, s.width*s.height)
+	s.front = make([]inlineCell, s.width*s.height)
+	for i := range s.front {
+		// Make sure that everything is drawn on the next Flush
+		s.front[i].ch = -1
+	}
+
+	s.out.WriteString("\r")
+	for i := 1; i < s.height; i++ {
+		s.out.WriteString("\n")
+	}
+	if s.height > 1 {
+		s.out.WriteString("\x1b[" + strconv.Itoa(s.height-1) + "A")
+	}
+	s.row = 0
+}
+
+// locate finds the row of the terminal that the region starts at,
+// which is needed to translate the position of mouse events. Any other
+// input that is read in the meantime is kept for PollEvent
+func (s *InlineScreen) locate() {
+	s.top = -1
+	if !s.mouse {
+		return
+	}
+	if _, err := s.tty.WriteString("\x1b[6n"); err != nil {
+		return
+	}
+
+	var input []byte
+	buf := make([]byte, 64)
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		n, err := s.tty.Read(buf)
+		if err != nil && err != io.EOF {
+			break
+		}
+		input = append(input, buf[:n]...)
+		if m := reCursorPosition.FindSubmatchIndex(input); m != nil {
+			row, _ := strconv.Atoi(string(input[m[2]:m[3]]))
+			s.top = row - 1 - s.row
+			s.pending = append(s.pending, input[:m[0]]...)
+			s.pending = append(s.pending, input[m[1]:]...)
+			return
+		}
+	}
+	s.pending = append(s.pending, input...)
+}
+
+// moveTo moves the terminal's cursor to the given position in the
+// region
+func (s *InlineScreen) moveTo(x, y int) {
+	switch {
+	case y > s.row:
+		s.out.WriteString("\x1b[" + strconv.Itoa(y-s.row) + "B")
+	case y < s.row:
+		s.out.WriteString("\x1b[" + strconv.Itoa(s.row-y) + "A")
+	}
+	s.row = y
+
+	s.out.WriteString("\r")
+	if x > 0 {
+		s.out.WriteString("\x1b[" + strconv.Itoa(x) + "C")
+	}
+}
+
+// write sends the buffered escape sequences and text to the terminal
+func (s *InlineScreen) write() error {
+	defer s.out.Reset()
+	if s.tty == nil {
+		return nil
+	}
+	_, err := s.tty.Write(s.out.Bytes())
+	return err
+}
+
+// Close clears the region, and restores the terminal. The cursor is
+// left where the region started
+func (s *InlineScreen) Close() error {
+	if pdebug.Enabled {
+		pdebug.Printf("InlineScreen: Close")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.tty == nil {
+		return nil
+	}
+	signal.Stop(s.resizeCh)
+	err := s.stop()
+	s.tty.Close()
+	s.tty = nil
+	return errors.Wrap(err, "failed to restore terminal")
+}
+
+// Flush draws the cells that changed since the last call
+func (s *InlineScreen) Flush() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.tty == nil {
+		return nil
+	}
+
+	s.out.WriteString("\x1b[?25l")
+	for y := 0; y < s.height; y++ {
+		back := s.back[y*s.width : (y+1)*s.width]
+		front := s.front[y*s.width : (y+1)*s.width]
+		if cellsEqual(back, front) {
+			continue
+		}
+
+		// Redraw the whole line, as wide characters make it hard to
+		// figure out where exactly to start drawing
+		s.moveTo(0, y)
+		fg, bg := termbox.Attribute(0xFFFF), termbox.Attribute(0xFFFF)
+		for x := 0; x < s.width; {
+			c := back[x]
+			if c.fg != fg || c.bg != bg {
+				fg, bg = c.fg, c.bg
+				writeInlineSGR(&s.out, fg, bg, s.use256Color)
+			}
+
+			ch, w := c.ch, runewidth.RuneWidth(c.ch)
+			if ch < ' ' || w == 0 || x+w > s.width {
+				ch, w = ' ', 1
+			}
+			s.out.WriteRune(ch)
+			x += w
+		}
+		copy(front, back)
+	}
+	s.out.WriteString("\x1b[0m")
+
+	if s.cursorX >= 0 && s.cursorY >= 0 && s.cursorY < s.height {
+		s.moveTo(s.cursorX, s.cursorY)
+		s.out.WriteString("\x1b[?25h")
+	}
+	return errors.Wrap(s.write(), "failed to write to terminal")
+}
+
+func cellsEqual(a, b []inlineCell) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// PollEvent returns a channel that receives the keys read from the
+// terminal, as well as resize events
+func (s *InlineScreen) PollEvent(ctx context.Context, cfg *Config) chan termbox.Event {
+	evCh := make(chan termbox.Event)
+
+	go func() {
+		defer close(evCh)
+		defer close(s.done)
+
+		send := func(ev termbox.Event) bool {
+			select {
+			case <-ctx.Done():
+				return false
+			case evCh <- ev:
+				return true
+			}
+		}
+
+		var pending []byte
+		buf := make([]byte, 256)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.resizeCh:
+				s.resize()
+				if !send(termbox.Event{Type: termbox.EventResize}) {
+					return
+				}
+			case replyCh := <-s.suspendCh:
+				s.suspend()
+				close(replyCh)
+				select {
+				case <-ctx.Done():
+					return
+				case replyCh := <-s.resumeCh:
+					s.resume()
+					close(replyCh)
+				}
+			default:
+			}
+
+			s.mutex.Lock()
+			tty, top, height := s.tty, s.top, s.height
+			pending = append(pending, s.pending...)
+			s.pending = nil
+			s.mutex.Unlock()
+			if tty == nil {
+				return
+			}
+
+			// Reads time out after a while, so that we can get back to
+			// checking for other requests
+			n, err := tty.Read(buf)
+			if err != nil && err != io.EOF {
+				return
+			}
+
+			var events []termbox.Event
+			events, pending = parseInlineInput(append(pending, buf[:n]...), n == 0)
+			for _, ev := range events {
+				if ev.Type == termbox.EventMouse {
+					// Make the position relative to the region
+					ev.MouseY -= top
+					if top < 0 || ev.MouseY < 0 || ev.MouseY >= height {
+						continue
+					}
+				}
+				if !send(ev) {
+					return
+				}
+			}
+		}
+	}()
+	return evCh
+}
+
+// resize clears the region, and reserves it again using the new size
+// of the terminal
+func (s *InlineScreen) resize() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.tty == nil {
+		return
+	}
+	s.moveTo(0, 0)
+	s.out.WriteString("\x1b[0m\x1b[J")
+	s.reserve()
+	if s.write() == nil {
+		s.locate()
+	}
+}
+
+func (s *InlineScreen) suspend() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.tty == nil {
+		return
+	}
+	s.stop()
+}
+
+func (s *InlineScreen) resume() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.tty == nil {
+		return
+	}
+	s.start()
+}
+
+// Suspend clears the region, and gives the terminal back to the user,
+// e.g. to run an external command
+func (s *InlineScreen) Suspend() {
+	ch := make(chan struct{})
+	select {
+	case s.suspendCh <- ch:
+		<-ch
+	case <-s.done:
+	}
+}
+
+// Resume reserves the region again after Suspend. The screen must be
+// redrawn afterwards
+func (s *InlineScreen) Resume() {
+	ch := make(chan struct{})
+	select {
+	case s.resumeCh <- ch:
+		<-ch
+	case <-s.done:
+	}
+}
+
+// SendEvent is only useful for testing purposes, and is a noop
+func (s *InlineScreen) SendEvent(_ termbox.Event) {
+}
+
+// SetCell writes to the region. The changes are displayed on the
+// next Flush
+func (s *InlineScreen) SetCell(x, y int, ch rune, fg, bg termbox.Attribute) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if x < 0 || x >= s.width || y < 0 || y >= s.height {
+		return
+	}
+	s.back[y*s.width+x] = inlineCell{ch: ch, fg: fg, bg: bg}
+}
+
+// SetCursor sets the position of the cursor in the region. Negative
+// values hide the cursor
+func (s *InlineScreen) SetCursor(x, y int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.cursorX, s.cursorY = x, y
+}
+
+// Size returns the dimensions of the region
+func (s *InlineScreen) Size() (int, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.width, s.height
+}
+
+// Print prints the given text to the region
+func (s *InlineScreen) Print(args PrintArgs) int {
+	return screenPrint(s, args)
+}
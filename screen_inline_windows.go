@@ -0,0 +1,9 @@
+package peco
+
+import "github.com/pkg/errors"
+
+// NewInlineScreen is not supported on Windows, as the console does not
+// understand the escape sequences that InlineScreen relies on
+func NewInlineScreen(lines int, percent bool) (Screen, error) {
+	return nil, errors.New("--height is not supported on Windows")
+}
@@ -11,5 +11,9 @@ func (t *Termbox) PostInit(cfg *Config) error {
 		termbox.SetOutputMode(termbox.Output256)
 	}
 
+	if cfg.Mouse {
+		termbox.SetInputMode(termbox.InputEsc | termbox.InputMouse)
+	}
+
 	return nil
 }
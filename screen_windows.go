@@ -4,7 +4,11 @@ import "github.com/nsf/termbox-go"
 
 func (t *Termbox) PostInit(cfg *Config) error {
 	// Windows handle Esc/Alt self
-	termbox.SetInputMode(termbox.InputEsc | termbox.InputAlt)
+	mode := termbox.InputEsc | termbox.InputAlt
+	if cfg.Mouse {
+		mode |= termbox.InputMouse
+	}
+	termbox.SetInputMode(mode)
 
 	return nil
 }
@@ -3,7 +3,11 @@ package peco
 import (
 	"bufio"
 	"context"
+	"fmt"
 	"io"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,6 +17,14 @@ import (
 	"github.com/peco/peco/pipeline"
 )
 
+// sourceErrorClearDelay is how long problems with the input are
+// displayed in the status bar
+const sourceErrorClearDelay = 5 * time.Second
+
+// sourcePollInterval is how often a source that is still being read is
+// checked for more lines to send to the filters
+const sourcePollInterval = 50 * time.Millisecond
+
 // Creates a new Source. Does not start processing the input until you
 // call Setup()
 func NewSource(name string, in io.Reader, isInfinite bool, idgen line.IDGenerator, capacity int, enableSep bool) *Source {
@@ -21,7 +33,7 @@ func NewSource(name string, in io.Reader, isInfinite bool, idgen line.IDGenerato
 		capacity:   capacity,
 		enableSep:  enableSep,
 		idgen:      idgen,
-		in:         in, // Note that this may be closed, so do not rely on it
+		inputs:     []sourceInput{{name: name, in: in}}, // Note that these may be closed, so do not rely on them
 		inClosed:   false,
 		isInfinite: isInfinite,
 		ready:      make(chan struct{}),
@@ -32,6 +44,12 @@ func NewSource(name string, in io.Reader, isInfinite bool, idgen line.IDGenerato
 	return s
 }
 
+// addInput adds another reader to read lines from, along with the
+// existing ones. This must be called before Setup()
+func (s *Source) addInput(name string, in io.Reader) {
+	s.inputs = append(s.inputs, sourceInput{name: name, in: in})
+}
+
 func (s *Source) Name() string {
 	return s.name
 }
@@ -59,13 +77,19 @@ func (s *Source) Setup(ctx context.Context, state *Peco) {
 			ticker := time.NewTicker(100 * time.Millisecond)
 			defer ticker.Stop()
 
+			// Inputs such as --follow may be idle for a long time, so
+			// only draw when lines have been read
+			drawn := -1
 			for {
 				select {
 				case <-done:
 					draw(state)
 					return
 				case <-ticker.C:
-					draw(state)
+					if n := s.Size() + s.Trimmed(); n != drawn {
+						drawn = n
+						draw(state)
+					}
 				}
 			}
 		}()
@@ -87,47 +111,38 @@ func (s *Source) Setup(ctx context.Context, state *Peco) {
 		// Note: this will be a no-op if notify.Do has been called before
 		defer notify.Do(notifycb)
 
-		if pdebug.Enabled {
-			pdebug.Printf("Source: using buffer size of %dkb", state.maxScanBufferSize)
-		}
-		scanbuf := make([]byte, state.maxScanBufferSize*1024)
-		scanner := bufio.NewScanner(s.in)
-		scanner.Buffer(scanbuf, state.maxScanBufferSize*1024)
 		defer func() {
-			if util.IsTty(s.in) {
-				return
-			}
-			if closer, ok := s.in.(io.Closer); ok {
-				s.inClosed = true
-				closer.Close()
+			for _, input := range s.inputs {
+				if util.IsTty(input.in) {
+					continue
+				}
+				if closer, ok := input.in.(io.Closer); ok {
+					s.inClosed = true
+					closer.Close()
+				}
 			}
 		}()
 
-		lines := make(chan string)
+		// Each input is read concurrently, so that a slow input does not
+		// hold up the others
+		lines := make(chan sourceLine)
+		var wg sync.WaitGroup
+		for _, input := range s.inputs {
+			wg.Add(1)
+			go func(input sourceInput) {
+				defer wg.Done()
+				s.scan(ctx, state, input, lines)
+			}(input)
+		}
 		go func() {
-			var scanned int
-			if pdebug.Enabled {
-				defer func() { pdebug.Printf("Source scanned %d lines", scanned) }()
-			}
-
-			defer close(lines)
-			for scanner.Scan() {
-				newLine := scanner.Text()
-				select {
-				case <-ctx.Done():
-					if pdebug.Enabled {
-						pdebug.Printf("Bailing out of source setup text reader loop, because ctx was canceled")
-					}
-					return
-				case lines <- newLine:
-				}
-				scanned++
-			}
+			wg.Wait()
+			close(lines)
 		}()
 
 		state.Hub().SendStatusMsg(ctx, "Waiting for input...")
 
 		readCount := 0
+		skipped := 0
 		for loop := true; loop; {
 			select {
 			case <-ctx.Done():
@@ -145,7 +160,24 @@ func (s *Source) Setup(ctx context.Context, state *Peco) {
 				}
 
 				readCount++
-				s.Append(line.NewRaw(s.idgen.Next(), l, s.enableSep))
+				newLine, err := s.parseLine(l.text, l.origin)
+				if err != nil {
+					// Only the first error is reported right away, so
+					// that the status messages do not pile up
+					if skipped == 0 {
+						where := "line " + strconv.Itoa(l.origin.Lineno)
+						if len(s.inputs) > 1 {
+							where = l.origin.String()
+						}
+						state.Hub().SendStatusMsgAndClear(ctx, fmt.Sprintf("Skipped malformed record on %s: %s", where, err), sourceErrorClearDelay)
+					}
+					skipped++
+					break
+				}
+				if newLine == nil {
+					break
+				}
+				s.Append(newLine)
 				notify.Do(notifycb)
 			}
 		}
@@ -153,9 +185,74 @@ func (s *Source) Setup(ctx context.Context, state *Peco) {
 		if pdebug.Enabled {
 			pdebug.Printf("Read all %d lines from source", readCount)
 		}
+
+		if skipped > 0 {
+			state.Hub().SendStatusMsgAndClear(ctx, fmt.Sprintf("Malformed records skipped: %d", skipped), sourceErrorClearDelay)
+		}
 	})
 }
 
+// sourceLine is a line of text read from one of the inputs
+type sourceLine struct {
+	text   string
+	origin line.Origin
+}
+
+// scan reads the lines from input, and sends them to lines until
+// everything has been read, or ctx is canceled
+func (s *Source) scan(ctx context.Context, state *Peco, input sourceInput, lines chan<- sourceLine) {
+	var scanned int
+	if pdebug.Enabled {
+		pdebug.Printf("Source: reading %s using buffer size of %dkb", input.name, state.maxScanBufferSize)
+		defer func() { pdebug.Printf("Source scanned %d lines from %s", scanned, input.name) }()
+	}
+
+	scanner := bufio.NewScanner(input.in)
+	if len(s.inputs) > 1 {
+		// There may be a lot of inputs, so let the buffers grow as needed
+		scanner.Buffer(nil, state.maxScanBufferSize*1024)
+	} else {
+		scanbuf := make([]byte, state.maxScanBufferSize*1024)
+		scanner.Buffer(scanbuf, state.maxScanBufferSize*1024)
+	}
+	for scanner.Scan() {
+		l := sourceLine{
+			text:   scanner.Text(),
+			origin: line.Origin{Filename: input.name, Lineno: scanned + 1},
+		}
+		select {
+		case <-ctx.Done():
+			if pdebug.Enabled {
+				pdebug.Printf("Bailing out of source setup text reader loop, because ctx was canceled")
+			}
+			return
+		case lines <- l:
+		}
+		scanned++
+	}
+}
+
+// parseLine creates a line from the text read from the input. Returns
+// nil if there is nothing to add, as is the case with blank lines in
+// JSON Lines input
+func (s *Source) parseLine(v string, origin line.Origin) (line.Line, error) {
+	if s.json == nil {
+		rl := line.NewRawWithFields(s.idgen.Next(), v, s.enableSep, s.fields)
+		rl.SetOrigin(origin)
+		return rl, nil
+	}
+
+	if strings.TrimSpace(v) == "" {
+		return nil, nil
+	}
+	jl, err := line.NewJSON(s.idgen.Next(), v, s.json)
+	if err != nil {
+		return nil, err
+	}
+	jl.SetOrigin(origin)
+	return jl, nil
+}
+
 // Start starts
 func (s *Source) Start(ctx context.Context, out pipeline.ChanOutput) {
 	var sent int
@@ -194,19 +291,24 @@ func (s *Source) Start(ctx context.Context, out pipeline.ChanOutput) {
 	// For the first time we get called, we may possibly be in the
 	// middle of reading a really long input stream. In this case,
 	// we should resume where we left off.
+	//
+	// Lines are tracked by their IDs, as the oldest lines are removed
+	// once the buffer is full, which changes the position of each line
 
-	var prev = 0
+	var next uint64
 	var setupDone bool
+	var wait *time.Ticker
 	for {
-		// This is where we are ready up to
-		upto := s.Size()
-		// We bail out if we are done with the setup, and our
-		// buffer has not grown
-		if setupDone && upto == prev {
-			return
+		// Check if we're done with setup before looking for more lines,
+		// so that we don't miss the lines read in the meantime
+		select {
+		case <-s.setupDone:
+			setupDone = true
+		default:
 		}
 
-		for i := prev; i < upto; i++ {
+		lines := s.linesFrom(next)
+		for _, l := range lines {
 			select {
 			case <-ctx.Done():
 				if pdebug.Enabled {
@@ -214,21 +316,34 @@ func (s *Source) Start(ctx context.Context, out pipeline.ChanOutput) {
 				}
 				return
 			default:
-				l, _ := s.LineAt(i)
 				out.Send(l)
 				sent++
 			}
 		}
-		// Remember how far we have processed
-		prev = upto
+		if len(lines) > 0 {
+			// Remember how far we have processed
+			next = lines[len(lines)-1].ID() + 1
+			continue
+		}
 
-		// Check if we're done with setup
+		// We bail out if we are done with the setup, and our
+		// buffer has not grown
+		if setupDone {
+			return
+		}
+
+		// Wait for more lines to be read. The input may never end,
+		// e.g. with --follow
+		if wait == nil {
+			wait = time.NewTicker(sourcePollInterval)
+			defer wait.Stop()
+		}
 		select {
+		case <-ctx.Done():
+			return
 		case <-s.setupDone:
-			setupDone = true
-		default:
+		case <-wait.C:
 		}
-
 	}
 }
 
@@ -260,6 +375,15 @@ func (s *Source) linesInRange(start, end int) []line.Line {
 	return s.lines[start:end]
 }
 
+// linesFrom returns the lines whose IDs are id or greater. Lines are
+// appended in the order of their IDs
+func (s *Source) linesFrom(id uint64) []line.Line {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	i := sort.Search(len(s.lines), func(i int) bool { return s.lines[i].ID() >= id })
+	return s.lines[i:]
+}
+
 func (s *Source) LineAt(n int) (line.Line, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
@@ -272,15 +396,17 @@ func (s *Source) Size() int {
 	return bufferSize(s.lines)
 }
 
+func (s *Source) Trimmed() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.trimmed
+}
+
 func (s *Source) Append(l line.Line) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	s.lines = append(s.lines, l)
-	if s.capacity > 0 && len(s.lines) > s.capacity {
-		diff := len(s.lines) - s.capacity
-
-		// Golang's version of array realloc
-		s.lines = s.lines[diff:s.capacity:s.capacity]
-	}
+	var removed int
+	s.lines, removed = appendWithCapacity(s.lines, l, s.capacity)
+	s.trimmed += removed
 }
@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"context"
+	"github.com/peco/peco/line"
+	"github.com/peco/peco/pipeline"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -86,3 +88,86 @@ func TestSource(t *testing.T) {
 		}
 	}
 }
+
+func TestSourceJSON(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ig := newIDGen()
+	go ig.Run(ctx)
+
+	input := `{"name": "foo"}
+not json
+
+{"name": "bar"}`
+	s := NewSource("-", strings.NewReader(input), false, ig, 0, false)
+	s.json = &line.JSONFormat{}
+	s.json.Display, _ = line.NewJSONTemplate(".name")
+	p := New()
+	p.hub = nullHub{}
+	s.Setup(ctx, p)
+
+	if !assert.Equal(t, 2, s.Size(), "malformed records and blank lines should be skipped") {
+		return
+	}
+	for i, expected := range []string{"foo", "bar"} {
+		l, err := s.LineAt(i)
+		if !assert.NoError(t, err, "s.LineAt(%d) should succeed", i) {
+			return
+		}
+		assert.Equal(t, expected, l.DisplayString(), "display string should match")
+	}
+}
+
+func TestSourceCapacity(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ig := newIDGen()
+	go ig.Run(ctx)
+
+	r, w := io.Pipe()
+	s := NewSource("-", r, true, ig, 2, false)
+	p := New()
+	p.hub = nullHub{}
+	go s.Setup(ctx, p)
+
+	out := pipeline.ChanOutput(make(chan interface{}))
+	go s.Start(ctx, out)
+
+	// Lines are sent as they are read, even once the oldest lines are
+	// removed from the buffer
+	receive := func(expected string) bool {
+		select {
+		case v := <-out:
+			l, ok := v.(line.Line)
+			if !assert.True(t, ok, "expected a line, got %#v", v) {
+				return false
+			}
+			return assert.Equal(t, expected, l.Buffer(), "line should match")
+		case <-time.After(5 * time.Second):
+			return assert.Fail(t, "timed out waiting for line %s", expected)
+		}
+	}
+	for _, v := range []string{"foo", "bar", "baz", "qux"} {
+		io.WriteString(w, v+"\n")
+		if !receive(v) {
+			return
+		}
+	}
+	w.Close()
+
+	select {
+	case v := <-out:
+		err, ok := v.(error)
+		assert.True(t, ok && pipeline.IsEndMark(err), "end mark should be sent once the input ends")
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "timed out waiting for end mark")
+	}
+
+	var lines []string
+	for _, l := range s.linesInRange(0, s.Size()) {
+		lines = append(lines, l.Buffer())
+	}
+	assert.Equal(t, []string{"baz", "qux"}, lines, "only the newest lines should be kept")
+}
@@ -4,9 +4,9 @@ package peco
 
 import "fmt"
 
-const _PagingRequestType_name = "ToLineAboveToScrollPageDownToLineBelowToScrollPageUpToScrollLeftToScrollRightToLineInPageToScrollFirstItemToScrollLastItem"
+const _PagingRequestType_name = "ToLineAboveToScrollPageDownToLineBelowToScrollPageUpToScrollLeftToScrollRightToLineInPageToScrollFirstItemToScrollLastItemToScrollPreviewUpToScrollPreviewDownToScrollPreviewPageUpToScrollPreviewPageDown"
 
-var _PagingRequestType_index = [...]uint8{0, 11, 27, 38, 52, 64, 77, 89, 106, 122}
+var _PagingRequestType_index = [...]uint8{0, 11, 27, 38, 52, 64, 77, 89, 106, 122, 139, 158, 179, 202}
 
 func (i PagingRequestType) String() string {
 	if i < 0 || i >= PagingRequestType(len(_PagingRequestType_index)-1) {
@@ -25,16 +25,19 @@ func (jlr JumpToLineRequest) Line() int {
 }
 
 func NewView(state *Peco) *View {
-	var layout Layout
+	return &View{
+		state:  state,
+		layout: newLayout(state),
+	}
+}
+
+// newLayout creates the layout specified by the user
+func newLayout(state *Peco) *BasicLayout {
 	switch state.LayoutType() {
 	case LayoutTypeBottomUp:
-		layout = NewBottomUpLayout(state)
+		return NewBottomUpLayout(state)
 	default:
-		layout = NewDefaultLayout(state)
-	}
-	return &View{
-		state:  state,
-		layout: layout,
+		return NewDefaultLayout(state)
 	}
 }
 
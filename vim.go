@@ -0,0 +1,555 @@
+package peco
+
+import (
+	"context"
+	"unicode"
+
+	"github.com/lestrrat-go/pdebug"
+	"github.com/nsf/termbox-go"
+)
+
+// These are the names of the modes of the vim-style editing, which are
+// also used to bind keys in each mode
+const (
+	vimInsertMode = "vim-insert"
+	vimNormalMode = "vim-normal"
+)
+
+// vimIndicatorWidth is the width of the mode indicator that is
+// displayed in front of the prompt
+const vimIndicatorWidth = 4
+
+// maxVimCount keeps counts from growing without bounds
+const maxVimCount = 9999
+
+// NewVim creates a new Vim, which starts out in insert mode
+func NewVim() *Vim {
+	return &Vim{mode: vimInsertMode}
+}
+
+// Mode returns the name of the current mode
+func (v *Vim) Mode() string {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.mode
+}
+
+// Indicator returns the text that is displayed in front of the prompt
+// to show the current mode
+func (v *Vim) Indicator() string {
+	if v.Mode() == vimNormalMode {
+		return "[N] "
+	}
+	return "[I] "
+}
+
+// setMode switches to the given mode, and cancels anything pending
+func (v *Vim) setMode(mode string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.mode = mode
+	v.reset_nolock()
+}
+
+// reset cancels the pending count, operator and 'f'. Returns false if
+// there was nothing to cancel
+func (v *Vim) reset() bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	pending := v.count > 0 || v.operator != 0 || v.finding
+	v.reset_nolock()
+	return pending
+}
+
+func (v *Vim) reset_nolock() {
+	v.count = 0
+	v.operator = 0
+	v.opCount = 0
+	v.finding = false
+}
+
+func (v *Vim) hasCount() bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.count > 0
+}
+
+func (v *Vim) addCount(digit int) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if n := v.count*10 + digit; n <= maxVimCount {
+		v.count = n
+	}
+}
+
+// setOperator makes op the pending operator. Returns true if op was
+// already pending, as in "dd"
+func (v *Vim) setOperator(op rune) bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if v.operator == op {
+		v.reset_nolock()
+		return true
+	}
+	v.operator = op
+	v.opCount = v.count
+	v.count = 0
+	return false
+}
+
+func (v *Vim) pendingOperator() rune {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.operator
+}
+
+// take returns the number of times the next command should be repeated,
+// and the pending operator, if any. Both are reset afterwards
+func (v *Vim) take() (int, rune) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	count := 1
+	if v.count > 0 {
+		count = v.count
+	}
+	if v.opCount > 0 {
+		count *= v.opCount
+	}
+	op := v.operator
+	v.reset_nolock()
+	return count, op
+}
+
+func (v *Vim) startFind() {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.finding = true
+}
+
+// takeFind returns true if 'f' is waiting for a character
+func (v *Vim) takeFind() bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	finding := v.finding
+	v.finding = false
+	return finding
+}
+
+func (v *Vim) setRegister(rs []rune) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.register = append([]rune(nil), rs...)
+}
+
+func (v *Vim) registerText() []rune {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.register
+}
+
+// vimMotion computes the position that a motion moves the caret to
+// from pos in q, when repeated count times. inclusive is true if the
+// character at the resulting position is affected when the motion is
+// used with an operator. ok is false if the motion can't be made
+type vimMotion func(q []rune, pos, count int) (target int, inclusive bool, ok bool)
+
+// vimCharClass classifies runes the way vim does for word motions:
+// white space, keyword characters, and everything else
+func vimCharClass(r rune) int {
+	switch {
+	case unicode.IsSpace(r):
+		return 0
+	case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+		return 1
+	default:
+		return 2
+	}
+}
+
+// vimRunEnd returns the position of the last rune of the run of runes
+// of the same class that starts at pos
+func vimRunEnd(q []rune, pos int) int {
+	class := vimCharClass(q[pos])
+	for pos+1 < len(q) && vimCharClass(q[pos+1]) == class {
+		pos++
+	}
+	return pos
+}
+
+func vimBackwardChar(q []rune, pos, count int) (int, bool, bool) {
+	if pos <= 0 {
+		return 0, false, false
+	}
+	if pos -= count; pos < 0 {
+		pos = 0
+	}
+	return pos, false, true
+}
+
+func vimForwardChar(q []rune, pos, count int) (int, bool, bool) {
+	if pos >= len(q) {
+		return 0, false, false
+	}
+	if pos += count; pos > len(q) {
+		pos = len(q)
+	}
+	return pos, false, true
+}
+
+func vimForwardWord(q []rune, pos, count int) (int, bool, bool) {
+	if pos >= len(q) {
+		return 0, false, false
+	}
+	for ; count > 0 && pos < len(q); count-- {
+		if vimCharClass(q[pos]) != 0 {
+			pos = vimRunEnd(q, pos) + 1
+		}
+		for pos < len(q) && vimCharClass(q[pos]) == 0 {
+			pos++
+		}
+	}
+	return pos, false, true
+}
+
+func vimBackwardWord(q []rune, pos, count int) (int, bool, bool) {
+	if pos <= 0 {
+		return 0, false, false
+	}
+	for ; count > 0 && pos > 0; count-- {
+		for pos > 0 && vimCharClass(q[pos-1]) == 0 {
+			pos--
+		}
+		if pos == 0 {
+			break
+		}
+		class := vimCharClass(q[pos-1])
+		for pos > 0 && vimCharClass(q[pos-1]) == class {
+			pos--
+		}
+	}
+	return pos, false, true
+}
+
+func vimEndOfWord(q []rune, pos, count int) (int, bool, bool) {
+	if pos >= len(q)-1 {
+		return 0, false, false
+	}
+	for ; count > 0 && pos < len(q)-1; count-- {
+		pos++
+		for pos < len(q)-1 && vimCharClass(q[pos]) == 0 {
+			pos++
+		}
+		pos = vimRunEnd(q, pos)
+	}
+	return pos, true, true
+}
+
+// vimChangeWord is used instead of vimForwardWord for "cw", which vim
+// treats like "ce", except that it also works on the last rune of a word
+func vimChangeWord(q []rune, pos, count int) (int, bool, bool) {
+	if pos >= len(q) {
+		return 0, false, false
+	}
+	pos = vimRunEnd(q, pos)
+	for count--; count > 0 && pos < len(q)-1; count-- {
+		pos++
+		for pos < len(q)-1 && vimCharClass(q[pos]) == 0 {
+			pos++
+		}
+		pos = vimRunEnd(q, pos)
+	}
+	return pos, true, true
+}
+
+func vimBeginningOfLine(q []rune, pos, count int) (int, bool, bool) {
+	return 0, false, true
+}
+
+func vimEndOfLine(q []rune, pos, count int) (int, bool, bool) {
+	if len(q) == 0 {
+		return 0, false, false
+	}
+	return len(q) - 1, true, true
+}
+
+// vimFindChar creates the motion for "f{ch}"
+func vimFindChar(ch rune) vimMotion {
+	return func(q []rune, pos, count int) (int, bool, bool) {
+		for ; count > 0; count-- {
+			for pos++; pos < len(q) && q[pos] != ch; pos++ {
+			}
+			if pos >= len(q) {
+				return 0, false, false
+			}
+		}
+		return pos, true, true
+	}
+}
+
+// vimCaretPos limits pos to the runes of a query of length n, as the
+// caret is always on a rune in normal mode
+func vimCaretPos(pos, n int) int {
+	if pos >= n {
+		pos = n - 1
+	}
+	if pos < 0 {
+		pos = 0
+	}
+	return pos
+}
+
+func makeVimMotion(m vimMotion) ActionFunc {
+	return func(ctx context.Context, state *Peco, _ termbox.Event) {
+		doVimMotion(ctx, state, m)
+	}
+}
+
+// doVimMotion moves the caret using m, or applies the pending operator
+// to the text between the caret and where m would move it to
+func doVimMotion(ctx context.Context, state *Peco, m vimMotion) {
+	v := state.vim
+	if v == nil {
+		return
+	}
+
+	count, op := v.take()
+	q := []rune(state.Query().String())
+	pos := state.Caret().Pos()
+	target, inclusive, ok := m(q, pos, count)
+	if !ok {
+		return
+	}
+
+	if op == 0 {
+		state.Caret().SetPos(vimCaretPos(target, len(q)))
+		state.Hub().SendDrawPrompt(ctx)
+		return
+	}
+
+	start, end := pos, target
+	if end < start {
+		start, end = end, start
+	} else if inclusive {
+		end++
+	}
+	if end > len(q) {
+		end = len(q)
+	}
+	vimOperate(ctx, state, op, start, end)
+}
+
+// vimOperate applies op to the runes of the query between start and end
+func vimOperate(ctx context.Context, state *Peco, op rune, start, end int) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("vimOperate %c %d-%d", op, start, end)
+		defer g.End()
+	}
+
+	v := state.vim
+	q := state.Query()
+	c := state.Caret()
+	v.setRegister([]rune(q.String())[start:end])
+
+	switch op {
+	case 'y':
+		c.SetPos(start)
+		state.Hub().SendDrawPrompt(ctx)
+		return
+	case 'c':
+		v.setMode(vimInsertMode)
+		q.DeleteRange(start, end)
+		c.SetPos(start)
+	default:
+		q.DeleteRange(start, end)
+		c.SetPos(vimCaretPos(start, q.Len()))
+	}
+
+	if state.ExecQuery(nil) {
+		return
+	}
+	state.Hub().SendDrawPrompt(ctx)
+}
+
+func makeVimOperator(op rune) ActionFunc {
+	return func(ctx context.Context, state *Peco, _ termbox.Event) {
+		v := state.vim
+		if v == nil || !v.setOperator(op) {
+			return
+		}
+		// "dd", "cc" and "yy" apply to the whole query
+		vimOperate(ctx, state, op, 0, state.Query().Len())
+	}
+}
+
+func doVimForwardWord(ctx context.Context, state *Peco, e termbox.Event) {
+	v := state.vim
+	if v == nil {
+		return
+	}
+
+	m := vimForwardWord
+	if v.pendingOperator() == 'c' {
+		q := state.Query()
+		if pos := state.Caret().Pos(); pos < q.Len() && !unicode.IsSpace(q.RuneAt(pos)) {
+			m = vimChangeWord
+		}
+	}
+	doVimMotion(ctx, state, m)
+}
+
+func doVimCount(ctx context.Context, state *Peco, e termbox.Event) {
+	v := state.vim
+	if v == nil || e.Ch < '0' || e.Ch > '9' {
+		return
+	}
+
+	// As in vim, 0 moves to the beginning of the line unless it's part
+	// of a count
+	if e.Ch == '0' && !v.hasCount() {
+		doVimMotion(ctx, state, vimBeginningOfLine)
+		return
+	}
+	v.addCount(int(e.Ch - '0'))
+}
+
+func doVimFindChar(ctx context.Context, state *Peco, _ termbox.Event) {
+	if v := state.vim; v != nil {
+		v.startFind()
+	}
+}
+
+// doVimFindTarget receives the key typed after 'f'. This does not get
+// registered anywhere, and is called by Keymap.ExecuteAction
+func doVimFindTarget(ctx context.Context, state *Peco, e termbox.Event) {
+	ch := e.Ch
+	if e.Key == termbox.KeySpace {
+		ch = ' '
+	}
+	if ch == 0 {
+		state.vim.reset()
+		return
+	}
+	doVimMotion(ctx, state, vimFindChar(ch))
+}
+
+func doVimDeleteChar(ctx context.Context, state *Peco, _ termbox.Event) {
+	v := state.vim
+	if v == nil {
+		return
+	}
+
+	count, _ := v.take()
+	pos := state.Caret().Pos()
+	n := state.Query().Len()
+	if pos >= n {
+		return
+	}
+	end := pos + count
+	if end > n {
+		end = n
+	}
+	vimOperate(ctx, state, 'd', pos, end)
+}
+
+func doVimPaste(ctx context.Context, state *Peco, _ termbox.Event) {
+	v := state.vim
+	if v == nil {
+		return
+	}
+
+	count, _ := v.take()
+	text := v.registerText()
+	if len(text) == 0 {
+		return
+	}
+
+	q := state.Query()
+	pos := state.Caret().Pos()
+	if q.Len() > 0 {
+		pos++
+	}
+	for ; count > 0; count-- {
+		for _, r := range text {
+			q.InsertAt(r, pos)
+			pos++
+		}
+	}
+	state.Caret().SetPos(pos - 1)
+
+	if state.ExecQuery(nil) {
+		return
+	}
+	state.Hub().SendDrawPrompt(ctx)
+}
+
+func doVimSelectDown(ctx context.Context, state *Peco, _ termbox.Event) {
+	doVimSelect(ctx, state, ToLineBelow)
+}
+
+func doVimSelectUp(ctx context.Context, state *Peco, _ termbox.Event) {
+	doVimSelect(ctx, state, ToLineAbove)
+}
+
+func doVimSelect(ctx context.Context, state *Peco, req PagingRequestType) {
+	count := 1
+	if v := state.vim; v != nil {
+		count, _ = v.take()
+	}
+	for ; count > 0; count-- {
+		state.Hub().SendPaging(ctx, req)
+	}
+}
+
+func doVimNormalMode(ctx context.Context, state *Peco, _ termbox.Event) {
+	v := state.vim
+	if v == nil {
+		return
+	}
+
+	// As in vim, the caret moves back onto the last character typed
+	v.setMode(vimNormalMode)
+	if c := state.Caret(); c.Pos() > 0 {
+		c.Move(-1)
+	}
+	state.Hub().SendDrawPrompt(ctx)
+}
+
+func enterVimInsertMode(ctx context.Context, state *Peco, pos int) {
+	v := state.vim
+	if v == nil {
+		return
+	}
+
+	v.setMode(vimInsertMode)
+	state.Caret().SetPos(pos)
+	state.Hub().SendDrawPrompt(ctx)
+}
+
+func doVimInsert(ctx context.Context, state *Peco, _ termbox.Event) {
+	enterVimInsertMode(ctx, state, state.Caret().Pos())
+}
+
+func doVimAppend(ctx context.Context, state *Peco, _ termbox.Event) {
+	pos := state.Caret().Pos()
+	if pos < state.Query().Len() {
+		pos++
+	}
+	enterVimInsertMode(ctx, state, pos)
+}
+
+func doVimInsertBeginningOfLine(ctx context.Context, state *Peco, _ termbox.Event) {
+	enterVimInsertMode(ctx, state, 0)
+}
+
+func doVimAppendEndOfLine(ctx context.Context, state *Peco, _ termbox.Event) {
+	enterVimInsertMode(ctx, state, state.Query().Len())
+}
+
+// doVimCancel cancels the pending count or operator, if any. Otherwise
+// it works like peco.Cancel
+func doVimCancel(ctx context.Context, state *Peco, e termbox.Event) {
+	if v := state.vim; v != nil && v.reset() {
+		return
+	}
+	doCancel(ctx, state, e)
+}
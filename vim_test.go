@@ -0,0 +1,111 @@
+package peco
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nsf/termbox-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVimMotions(t *testing.T) {
+	testValues := []struct {
+		name      string
+		motion    vimMotion
+		query     string
+		pos       int
+		count     int
+		target    int
+		inclusive bool
+		ok        bool
+	}{
+		{"w", vimForwardWord, "foo bar baz", 0, 1, 4, false, true},
+		{"w over punctuation", vimForwardWord, "foo.bar baz", 0, 1, 3, false, true},
+		{"2w", vimForwardWord, "foo bar baz", 0, 2, 8, false, true},
+		{"w at the last word", vimForwardWord, "foo bar", 4, 1, 7, false, true},
+		{"w at the end", vimForwardWord, "foo", 3, 1, 0, false, false},
+		{"b", vimBackwardWord, "foo bar baz", 8, 1, 4, false, true},
+		{"b in a word", vimBackwardWord, "foo bar baz", 9, 1, 8, false, true},
+		{"3b", vimBackwardWord, "foo bar baz", 10, 3, 0, false, true},
+		{"b at the beginning", vimBackwardWord, "foo", 0, 1, 0, false, false},
+		{"e", vimEndOfWord, "foo bar baz", 0, 1, 2, true, true},
+		{"e at the end of a word", vimEndOfWord, "foo bar baz", 2, 1, 6, true, true},
+		{"2e", vimEndOfWord, "foo bar baz", 0, 2, 6, true, true},
+		{"cw", vimChangeWord, "foo bar baz", 1, 1, 2, true, true},
+		{"c2w", vimChangeWord, "foo bar baz", 0, 2, 6, true, true},
+		{"h", vimBackwardChar, "foo", 2, 1, 1, false, true},
+		{"5h", vimBackwardChar, "foo", 2, 5, 0, false, true},
+		{"l", vimForwardChar, "foo", 0, 1, 1, false, true},
+		{"0", vimBeginningOfLine, "foo", 2, 1, 0, false, true},
+		{"$", vimEndOfLine, "foo", 0, 1, 2, true, true},
+		{"$ on an empty query", vimEndOfLine, "", 0, 1, 0, false, false},
+		{"fa", vimFindChar('a'), "foo bar baz", 0, 1, 5, true, true},
+		{"2fa", vimFindChar('a'), "foo bar baz", 0, 2, 9, true, true},
+		{"fx", vimFindChar('x'), "foo bar baz", 0, 1, 0, false, false},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			target, inclusive, ok := v.motion([]rune(v.query), v.pos, v.count)
+			if !assert.Equal(t, v.ok, ok, "ok should match") || !ok {
+				return
+			}
+			assert.Equal(t, v.target, target, "target should match")
+			assert.Equal(t, v.inclusive, inclusive, "inclusive should match")
+		})
+	}
+}
+
+func TestVimEditing(t *testing.T) {
+	state := newPeco()
+	state.Argv = []string{"peco", "--vim", state.Argv[1]}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go state.Run(ctx)
+
+	<-state.Ready()
+
+	testValues := []struct {
+		name  string
+		keys  string
+		query string
+		pos   int
+		mode  string
+	}{
+		{"Normal mode", "\x1b", "foo bar baz", 10, vimNormalMode},
+		{"dw", "\x1b0dw", "bar baz", 0, vimNormalMode},
+		{"d2w", "\x1b0d2w", "baz", 0, vimNormalMode},
+		{"dd", "\x1bdd", "", 0, vimNormalMode},
+		{"cw", "\x1b0wcwqux", "foo qux baz", 7, vimInsertMode},
+		{"c$", "\x1bbc$x", "foo bar x", 9, vimInsertMode},
+		{"df", "\x1b0dfb", "ar baz", 0, vimNormalMode},
+		{"x", "\x1b0x", "oo bar baz", 0, vimNormalMode},
+		{"3x at the end", "\x1b3x", "foo bar ba", 9, vimNormalMode},
+		{"yw and p", "\x1b0yw$p", "foo bar bazfoo ", 14, vimNormalMode},
+		{"Unbound keys are ignored", "\x1bXQ", "foo bar baz", 10, vimNormalMode},
+		{"Esc cancels the operator", "\x1b0d\x1bw", "foo bar baz", 4, vimNormalMode},
+		{"A", "\x1b0A!", "foo bar baz!", 12, vimInsertMode},
+		{"I", "\x1bI!", "!foo bar baz", 1, vimInsertMode},
+		{"a", "\x1b0a!", "f!oo bar baz", 2, vimInsertMode},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			state.vim.setMode(vimInsertMode)
+			state.Query().Set("foo bar baz")
+			state.Caret().SetPos(state.Query().Len())
+
+			for _, r := range v.keys {
+				ev := termbox.Event{Type: termbox.EventKey, Ch: r}
+				if r == '\x1b' {
+					ev = termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEsc}
+				}
+				state.Keymap().ExecuteAction(ctx, state, ev)
+			}
+
+			expectQueryString(t, state.Query(), v.query)
+			expectCaretPos(t, state.Caret(), v.pos)
+			assert.Equal(t, v.mode, state.vim.Mode(), "mode should match")
+		})
+	}
+}